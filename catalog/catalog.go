@@ -0,0 +1,167 @@
+// Package catalog は保存先にあるエピソードの一覧を保持する。
+// フィードを作るたびに保存先の全てのファイルを読まなくて済むように、録音が終わるたびに少しずつ更新する。
+package catalog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/upamune/radicaster/metadata"
+)
+
+// Episode は保存先にある音声ファイルとそのメタデータ。
+type Episode struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Metadata はメタデータのファイルが無い場合は nil になる。
+	Metadata *metadata.EpisodeMetadata `json:"metadata,omitempty"`
+}
+
+// FeedPath はエピソードを載せるフィードのパスを返す。メタデータが無い場合はデフォルトのフィードになる。
+func (e Episode) FeedPath() string {
+	if e.Metadata == nil {
+		return ""
+	}
+	return e.Metadata.FeedPath()
+}
+
+// Store はエピソードの一覧をJSONファイルに保存する。
+// path が空の場合はファイルに保存しない。
+type Store struct {
+	mu       sync.RWMutex
+	path     string
+	episodes map[string]Episode
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:     path,
+		episodes: make(map[string]Episode),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Wrap(err, "failed to open episode catalog file")
+	}
+	defer f.Close()
+
+	var episodes []Episode
+	if err := json.NewDecoder(f).Decode(&episodes); err != nil {
+		return nil, errors.Wrap(err, "failed to decode episode catalog json")
+	}
+	for _, ep := range episodes {
+		s.episodes[ep.Key] = ep
+	}
+	return s, nil
+}
+
+// Put は同じキーのエピソードがあれば上書きし、無ければ追加する。
+func (s *Store) Put(ep Episode) error {
+	return s.Update([]Episode{ep}, nil)
+}
+
+// Delete はキーのエピソードを取り除く。無い場合は何もしない。
+func (s *Store) Delete(key string) error {
+	return s.Update(nil, []string{key})
+}
+
+// Update は episodes を追加か上書きし、deletedKeys のエピソードを取り除いてから一度だけ保存する。
+func (s *Store) Update(episodes []Episode, deletedKeys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := len(episodes) > 0
+	for _, ep := range episodes {
+		s.episodes[ep.Key] = ep
+	}
+	for _, key := range deletedKeys {
+		if _, ok := s.episodes[key]; !ok {
+			continue
+		}
+		delete(s.episodes, key)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// Replace は全てのエピソードを episodes に置き換える。保存先を全て読み直した時に使う。
+func (s *Store) Replace(episodes []Episode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.episodes = make(map[string]Episode, len(episodes))
+	for _, ep := range episodes {
+		s.episodes[ep.Key] = ep
+	}
+	return s.save()
+}
+
+func (s *Store) Get(key string) (Episode, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ep, ok := s.episodes[key]
+	return ep, ok
+}
+
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.episodes)
+}
+
+// List はキーの順にエピソードを返す。
+func (s *Store) List() []Episode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list()
+}
+
+func (s *Store) list() []Episode {
+	episodes := make([]Episode, 0, len(s.episodes))
+	for _, ep := range s.episodes {
+		episodes = append(episodes, ep)
+	}
+	slices.SortFunc(episodes, func(a, b Episode) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return episodes
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	// NOTE: 書き込み途中で落ちても一覧が壊れないように一時ファイルからリネームする
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".episodes-*.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp episode catalog file")
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(s.list()); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to encode episode catalog json")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temp episode catalog file")
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return errors.Wrap(err, "failed to rename episode catalog file")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/upamune/radicaster/metadata"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "episodes.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	publishedAt := time.Date(2023, 9, 27, 1, 0, 0, 0, time.UTC)
+	for _, ep := range []Episode{
+		{Key: "zenroku/lfr/ann.aac", Size: 30, Metadata: &metadata.EpisodeMetadata{Title: "ann", PublishedAt: publishedAt, Path: "lfr", ZenrokuMode: true}},
+		{Key: "ann.aac", Size: 10, Metadata: &metadata.EpisodeMetadata{Title: "ann", PublishedAt: publishedAt, Path: "/ANN"}},
+		{Key: "junk.aac", Size: 20},
+	} {
+		if err := s.Put(ep); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if err := s.Put(Episode{Key: "junk.aac", Size: 25}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Delete("unknown.aac"); err != nil {
+		t.Fatalf("Delete() for an unknown key error = %v", err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	episodes := reloaded.List()
+	if len(episodes) != 3 {
+		t.Fatalf("List() returned %d episodes, want 3", len(episodes))
+	}
+	for i, want := range []struct {
+		key      string
+		size     int64
+		feedPath string
+	}{
+		{key: "ann.aac", size: 10, feedPath: "ann"},
+		{key: "junk.aac", size: 25, feedPath: ""},
+		{key: "zenroku/lfr/ann.aac", size: 30, feedPath: "zenroku/lfr"},
+	} {
+		got := episodes[i]
+		if got.Key != want.key || got.Size != want.size || got.FeedPath() != want.feedPath {
+			t.Errorf("List()[%d] = %s(%d bytes, feed %q), want %s(%d bytes, feed %q)", i, got.Key, got.Size, got.FeedPath(), want.key, want.size, want.feedPath)
+		}
+	}
+	if got := episodes[0].Metadata.PublishedAt; !got.Equal(publishedAt) {
+		t.Errorf("PublishedAt = %s, want %s", got, publishedAt)
+	}
+
+	if err := reloaded.Delete("junk.aac"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := reloaded.Get("junk.aac"); ok {
+		t.Errorf("Get() should not find a deleted episode")
+	}
+
+	if err := reloaded.Update([]Episode{{Key: "new.aac"}, {Key: "ann.aac", Size: 15}}, []string{"zenroku/lfr/ann.aac", "unknown.aac"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if episodes := reloaded.List(); len(episodes) != 2 || episodes[0].Size != 15 || episodes[1].Key != "new.aac" {
+		t.Errorf("List() after Update() = %+v", episodes)
+	}
+
+	if err := reloaded.Replace([]Episode{{Key: "new.aac"}}); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	reloaded, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if episodes := reloaded.List(); len(episodes) != 1 || episodes[0].Key != "new.aac" {
+		t.Errorf("List() after Replace() = %+v", episodes)
+	}
+}
@@ -2,21 +2,27 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
-	httpstd "net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/cockroachdb/errors"
 	"github.com/rs/zerolog"
 	"github.com/samber/lo"
+	"github.com/upamune/radicaster/catalog"
 	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/feedtoken"
 	"github.com/upamune/radicaster/http"
+	"github.com/upamune/radicaster/job"
 	"github.com/upamune/radicaster/podcast"
 	"github.com/upamune/radicaster/record"
+	"github.com/upamune/radicaster/server"
+	"github.com/upamune/radicaster/storage"
+	"github.com/upamune/radicaster/user"
 )
 
 var (
@@ -24,6 +30,11 @@ var (
 	Revision string
 )
 
+const (
+	storageLocal = "local"
+	storageS3    = "s3"
+)
+
 func main() {
 	os.Exit(realMain())
 }
@@ -31,6 +42,7 @@ func main() {
 func realMain() int {
 	baseURL := flag.String("baseurl", "http://localhost:3333", "base URL of server")
 	targetDir := flag.String("targetdir", "./output", "audio target directory")
+	dataDir := flag.String("datadir", "./data", "directory for job history and other state")
 	basicAuth := flag.String("basicauth", "", "Basic認証のための ':' で区切られたユーザー名とパスワード")
 	programConfig := flag.String("config", "", "path for config")
 	programConfigURL := flag.String("configurl", "", "url for config")
@@ -39,6 +51,27 @@ func realMain() int {
 	trace := flag.Bool("trace", false, "trace mode")
 	radikoEmail := flag.String("radikoemail", "", "email for radiko")
 	radikoPassword := flag.String("radikopassword", "", "password for radiko")
+	maxDownloadConns := flag.Int("maxdownloadconns", 16, "max concurrent connections for downloading chunks across all recordings")
+	downloadRPS := flag.Float64("downloadrps", 0, "max requests per second for downloading chunks (0 means unlimited)")
+	recordDelay := flag.Duration("recorddelay", 10*time.Minute, "delay after the broadcast ends before recording programs without cron")
+	listenAddr := flag.String("listen", envOrDefault("RADICASTER_LISTEN", ":3333"), "TCP address to listen on, empty to disable (env: RADICASTER_LISTEN)")
+	tlsCertFile := flag.String("tlscert", os.Getenv("RADICASTER_TLS_CERT"), "TLS certificate file, reloaded when it changes (env: RADICASTER_TLS_CERT)")
+	tlsKeyFile := flag.String("tlskey", os.Getenv("RADICASTER_TLS_KEY"), "TLS private key file, reloaded when it changes (env: RADICASTER_TLS_KEY)")
+	unixSocket := flag.String("unixsocket", os.Getenv("RADICASTER_UNIX_SOCKET"), "unix socket path to listen on (env: RADICASTER_UNIX_SOCKET)")
+	multiUser := flag.Bool("multiuser", os.Getenv("RADICASTER_MULTI_USER") == "true", "manage admin and listener users in datadir, -basicauth becomes the first admin (env: RADICASTER_MULTI_USER)")
+	storageType := flag.String("storage", envOrDefault("RADICASTER_STORAGE", storageLocal), "where to store recordings: local (targetdir) or s3 (env: RADICASTER_STORAGE)")
+	s3Endpoint := flag.String("s3endpoint", os.Getenv("RADICASTER_S3_ENDPOINT"), "endpoint of the S3-compatible storage such as s3.amazonaws.com or localhost:9000 (env: RADICASTER_S3_ENDPOINT)")
+	s3Bucket := flag.String("s3bucket", os.Getenv("RADICASTER_S3_BUCKET"), "bucket of the S3-compatible storage (env: RADICASTER_S3_BUCKET)")
+	s3Prefix := flag.String("s3prefix", os.Getenv("RADICASTER_S3_PREFIX"), "key prefix in the bucket (env: RADICASTER_S3_PREFIX)")
+	s3Region := flag.String("s3region", os.Getenv("RADICASTER_S3_REGION"), "region of the bucket (env: RADICASTER_S3_REGION)")
+	s3AccessKey := flag.String("s3accesskey", os.Getenv("RADICASTER_S3_ACCESS_KEY"), "access key of the S3-compatible storage (env: RADICASTER_S3_ACCESS_KEY)")
+	s3SecretKey := flag.String("s3secretkey", os.Getenv("RADICASTER_S3_SECRET_KEY"), "secret key of the S3-compatible storage (env: RADICASTER_S3_SECRET_KEY)")
+	s3Insecure := flag.Bool("s3insecure", os.Getenv("RADICASTER_S3_INSECURE") == "true", "connect to the S3-compatible storage over http (env: RADICASTER_S3_INSECURE)")
+	s3PresignExpiry := flag.Duration("s3presignexpiry", time.Hour, "expiry of presigned URLs for audio files")
+	watchQuietPeriod := flag.Duration("watchquiet", 3*time.Second, "wait until files in targetdir stop changing for this period before updating feeds")
+	watchMaxWait := flag.Duration("watchmaxwait", time.Minute, "update feeds after this period even if files in targetdir keep changing")
+	rescan := flag.Bool("rescan", false, "rebuild the episode catalog by scanning the whole storage at startup")
+	useFeedToken := flag.Bool("feedtoken", os.Getenv("RADICASTER_FEED_TOKEN") == "true", "require per-feed token for feeds and audio files (env: RADICASTER_FEED_TOKEN)")
 	flag.Parse()
 
 	if baseURL == nil || *baseURL == "" {
@@ -60,72 +93,100 @@ func realMain() int {
 		Caller().
 		Logger()
 
-	if _, err := os.Stat(*targetDir); err != nil {
-		logger.Warn().Str("target_dir", *targetDir).Msg("targetDir is not found")
-		if err := os.MkdirAll(*targetDir, 0777); err != nil {
-			logger.Error().Err(err).Msg("failed to create targetDir")
+	var store storage.Storage
+	switch *storageType {
+	case storageLocal:
+		if _, err := os.Stat(*targetDir); err != nil {
+			logger.Warn().Str("target_dir", *targetDir).Msg("targetDir is not found")
+			if err := os.MkdirAll(*targetDir, 0777); err != nil {
+				logger.Error().Err(err).Msg("failed to create targetDir")
+				return 1
+			}
+		}
+		store = storage.NewLocal(*targetDir)
+	case storageS3:
+		opts := []storage.S3Option{
+			storage.WithS3Prefix(*s3Prefix),
+			storage.WithS3Region(*s3Region),
+			storage.WithPresignExpiry(*s3PresignExpiry),
+		}
+		if *s3Insecure {
+			opts = append(opts, storage.WithS3Insecure())
+		}
+		s3, err := storage.NewS3(*s3Endpoint, *s3Bucket, *s3AccessKey, *s3SecretKey, opts...)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to create s3 storage")
 			return 1
 		}
+		store = s3
+	default:
+		fmt.Fprintf(os.Stderr, "-storage must be %s or %s\n", storageLocal, storageS3)
+		return 1
+	}
+
+	if err := os.MkdirAll(*dataDir, 0777); err != nil {
+		logger.Error().Err(err).Str("data_dir", *dataDir).Msg("failed to create dataDir")
+		return 1
+	}
+
+	jobStore, err := job.NewStore(filepath.Join(*dataDir, "jobs.json"))
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to create job store")
+		return 1
 	}
 
-	watcher, err := fsnotify.NewWatcher()
+	episodes, err := catalog.NewStore(filepath.Join(*dataDir, "episodes.json"))
 	if err != nil {
-		logger.Error().Err(err).Msg("failed to create file watcher")
+		logger.Error().Err(err).Msg("failed to create episode catalog")
 		return 1
 	}
-	defer watcher.Close()
+
+	var (
+		feedTokens    *feedtoken.Store
+		podcasterOpts = []podcast.Option{podcast.WithCatalog(episodes)}
+	)
+	if *useFeedToken {
+		feedTokens, err = feedtoken.NewStore(filepath.Join(*dataDir, "feed_tokens.json"))
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to create feed token store")
+			return 1
+		}
+		podcasterOpts = append(podcasterOpts, podcast.WithFeedTokens(feedTokens))
+	}
+
+	var users *user.Store
+	if *multiUser {
+		users, err = newUserStore(filepath.Join(*dataDir, "users.json"), *basicAuth)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to create user store")
+			return 1
+		}
+	}
 
 	now := time.Now()
 	podcaster := podcast.NewPodcaster(
 		logger,
 		*baseURL,
-		*targetDir,
+		store,
 		"Radicaster",
 		*baseURL,
 		"Radicaster",
 		&now,
 		*podcastImageURL,
+		podcasterOpts...,
 	)
 
-	if err := podcaster.Sync(); err != nil {
-		logger.Error().Err(err).Msg("failed to initial sync")
-		return 1
-	}
-
-	go func() {
-		logger := logger.With().Str("component", "file_watcher").Logger()
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				logger.Info().
-					Str("event_name", event.Name).
-					Str("event_operator", event.Op.String()).
-					Msg("got a file event")
-
-				if event.Has(fsnotify.Chmod) {
-					continue
-				}
-				if err := podcaster.Sync(); err != nil {
-					logger.Error().Err(err).Msg("failed to sync")
-					continue
-				}
-				logger.Info().Msg("synced")
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				logger.Error().Err(err).Msg("got a file watcher error")
-			}
+	// NOTE: 録音は保存した Recorder がカタログに反映する。ローカルのディレクトリは直接置かれたファイルも監視して反映する
+	if local, ok := store.(*storage.Local); ok {
+		watcher, err := newFileWatcher(logger, local.Dir(), *watchQuietPeriod, *watchMaxWait, podcaster)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to create file watcher")
+			return 1
 		}
-	}()
-	if err := watcher.Add(*targetDir); err != nil {
-		logger.Error().Err(err).Msg("failed to add file watcher")
-		return 1
+		defer watcher.Close()
+		go watcher.Run()
+		logger.Info().Str("target_dir", local.Dir()).Msg("added file watcher for sync")
 	}
-	logger.Info().Str("target_dir", *targetDir).Msg("added file watcher for sync")
 
 	initConfig, err := config.Init(programConfig, programConfigURL)
 	if err != nil {
@@ -143,18 +204,35 @@ func realMain() int {
 	ctx := context.Background()
 	recorder, err := record.NewRecorder(
 		logger,
-		*targetDir,
+		store,
 		lo.FromPtr(radikoEmail),
 		lo.FromPtr(radikoPassword),
 		initConfig,
 		lo.FromPtr(programConfig),
+		record.WithSyncer(podcaster),
+		record.WithJobStore(jobStore),
+		record.WithDownloadLimit(*maxDownloadConns, *downloadRPS),
+		record.WithRecordDelay(*recordDelay),
+		record.WithWorkDir(filepath.Join(*dataDir, "work")),
 	)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to create recorder")
 		return 1
 	}
+	podcaster.SetConfigProvider(recorder)
+
+	// NOTE: カタログがまだ無い場合は、これまでの録音を載せるためにストレージを全て読む
+	if *rescan || episodes.Len() == 0 {
+		if err := podcaster.Rescan(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to rescan the storage")
+			return 1
+		}
+	} else if err := podcaster.Sync(); err != nil {
+		logger.Error().Err(err).Msg("failed to initial sync")
+		return 1
+	}
 
-	handler, err := http.NewHTTPHandler(logger, Version, Revision, podcaster, recorder, *targetDir, *basicAuth)
+	handler, err := http.NewHTTPHandler(logger, Version, Revision, podcaster, recorder, store, *basicAuth, feedTokens, users)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to create HTTP handler")
 		return 1
@@ -163,25 +241,32 @@ func realMain() int {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	server := &httpstd.Server{Addr: ":3333", Handler: handler}
+	srv, err := server.New(logger, handler, server.Config{
+		ListenAddr:  *listenAddr,
+		TLSCertFile: *tlsCertFile,
+		TLSKeyFile:  *tlsKeyFile,
+		UnixSocket:  *unixSocket,
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to create server")
+		return 1
+	}
 
 	go func() {
 		<-ctx.Done()
 		logger.Info().Msg("shutting down server in 60 seconds")
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
+		if err := srv.Shutdown(ctx); err != nil {
 			logger.Error().Err(err).Msg("failed to shutdown server")
 			return
 		}
 	}()
 
 	logger.Info().Str("base_url", *baseURL).Msg("http server is starting...")
-	if err := server.ListenAndServe(); err != nil {
-		if !errors.Is(err, httpstd.ErrServerClosed) {
-			logger.Error().Err(err).Msg("failed to listen and serve")
-			return 1
-		}
+	if err := srv.Serve(); err != nil {
+		logger.Error().Err(err).Msg("failed to serve")
+		return 1
 	}
 
 	logger.Info().Msg("server is shutdown")
@@ -189,6 +274,32 @@ func realMain() int {
 	return 0
 }
 
+// newUserStore はユーザーを読み込む。まだ誰もいなければ -basicauth のユーザーを管理者として作る。
+func newUserStore(path, basicAuth string) (*user.Store, error) {
+	users, err := user.NewStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if users.Len() > 0 {
+		return users, nil
+	}
+	name, password, ok := strings.Cut(basicAuth, ":")
+	if !ok || name == "" || password == "" {
+		return nil, errors.New("-basicauth is required to create the first admin user")
+	}
+	if _, err := users.Put(name, user.Params{Password: password, Role: user.RoleAdmin}); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return defaultValue
+}
+
 func getMinLogLevel(debug, trace *bool) zerolog.Level {
 	if trace != nil && *trace {
 		return zerolog.TraceLevel
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+)
+
+// updater は変更されたファイルをカタログに反映する。*podcast.Podcaster が満たす。
+type updater interface {
+	Update(ctx context.Context, keys ...string) error
+}
+
+// fileWatcher は targetDir 以下のファイルの変更を監視して、変更が落ち着いたらまとめてカタログに反映する。
+// 変更が続いても maxWait 経ったら反映する。
+type fileWatcher struct {
+	logger      zerolog.Logger
+	watcher     *fsnotify.Watcher
+	dir         string
+	quietPeriod time.Duration
+	maxWait     time.Duration
+	podcaster   updater
+}
+
+func newFileWatcher(logger zerolog.Logger, dir string, quietPeriod, maxWait time.Duration, podcaster updater) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create fsnotify watcher")
+	}
+	w := &fileWatcher{
+		logger:      logger.With().Str("component", "file_watcher").Logger(),
+		watcher:     watcher,
+		dir:         dir,
+		quietPeriod: quietPeriod,
+		maxWait:     maxWait,
+		podcaster:   podcaster,
+	}
+	if _, err := w.addDir(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *fileWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+// Run は Close されるまで変更を監視する。
+func (w *fileWatcher) Run() {
+	var (
+		pending  = make(map[string]struct{})
+		quiet    <-chan time.Time
+		deadline <-chan time.Time
+	)
+	// NOTE: 変更が続いていつまでも反映されないことがないように、最初の変更から maxWait 経ったら反映する
+	add := func(keys ...string) {
+		if len(pending) == 0 && len(keys) > 0 {
+			deadline = time.After(w.maxWait)
+		}
+		for _, key := range keys {
+			pending[key] = struct{}{}
+		}
+	}
+	flush := func() {
+		keys := make([]string, 0, len(pending))
+		for key := range pending {
+			keys = append(keys, key)
+		}
+		pending = make(map[string]struct{})
+		quiet, deadline = nil, nil
+
+		if err := w.podcaster.Update(context.Background(), keys...); err != nil {
+			w.logger.Error().Err(err).Strs("keys", keys).Msg("failed to sync")
+			// NOTE: 反映できなかったキーは次の変更と一緒に、遅くとも maxWait 後にやり直す
+			add(keys...)
+			return
+		}
+		w.logger.Info().Strs("keys", keys).Msg("synced")
+	}
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.logger.Debug().
+				Str("event_name", event.Name).
+				Str("event_operator", event.Op.String()).
+				Msg("got a file event")
+
+			if event.Has(fsnotify.Chmod) || isTemporaryFile(event.Name) {
+				continue
+			}
+			// NOTE: fsnotify はサブディレクトリを監視しないので、作られたディレクトリも監視する
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					// NOTE: 監視を始める前にディレクトリに置かれたファイルもあるので、それも反映する
+					keys, err := w.addDir(event.Name)
+					if err != nil {
+						w.logger.Error().Err(err).Str("dir", event.Name).Msg("failed to watch the directory")
+					}
+					add(keys...)
+					quiet = time.After(w.quietPeriod)
+					continue
+				}
+			}
+			key, err := w.key(event.Name)
+			if err != nil {
+				w.logger.Error().Err(err).Str("event_name", event.Name).Msg("failed to get the key of the file")
+				continue
+			}
+			add(key)
+			quiet = time.After(w.quietPeriod)
+		case <-quiet:
+			flush()
+		case <-deadline:
+			flush()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.logger.Error().Err(err).Msg("got a file watcher error")
+		}
+	}
+}
+
+// addDir は dir とそのサブディレクトリを監視して、中にあるファイルのキーを返す。
+func (w *fileWatcher) addDir(dir string) ([]string, error) {
+	var keys []string
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && isTemporaryFile(p) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			key, err := w.key(p)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		}
+		if err := w.watcher.Add(p); err != nil {
+			return errors.Wrapf(err, "failed to watch %s", p)
+		}
+		w.logger.Info().Str("dir", p).Msg("added file watcher for sync")
+		return nil
+	}); err != nil {
+		return keys, errors.Wrap(err, "failed to walk directory")
+	}
+	return keys, nil
+}
+
+func (w *fileWatcher) key(name string) (string, error) {
+	rel, err := filepath.Rel(w.dir, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// isTemporaryFile は書き込み途中のファイルかを返す。
+// NOTE: storage.Local の一時ファイルは "." から始まる
+func isTemporaryFile(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") ||
+		strings.HasSuffix(base, "~") ||
+		strings.HasSuffix(base, ".tmp") ||
+		strings.HasSuffix(base, ".part")
+}
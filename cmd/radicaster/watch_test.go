@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+)
+
+// fakeUpdater は Update に渡されたキーを呼び出しごとに送る。
+// 最初の failures 回はエラーを返す。
+type fakeUpdater struct {
+	calls    chan []string
+	failures int
+}
+
+func (u *fakeUpdater) Update(_ context.Context, keys ...string) error {
+	sort.Strings(keys)
+	u.calls <- keys
+	if u.failures > 0 {
+		u.failures--
+		return errors.New("failed to update")
+	}
+	return nil
+}
+
+func TestFileWatcher(t *testing.T) {
+	t.Parallel()
+	const quietPeriod = 200 * time.Millisecond
+
+	tests := map[string]struct {
+		// write は監視を始めた dir にファイルを書く。
+		write func(t *testing.T, dir string)
+		want  []string
+	}{
+		"events are coalesced": {
+			write: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "a.mp3"))
+				writeFile(t, filepath.Join(dir, "a.mp3.json"))
+				writeFile(t, filepath.Join(dir, "b.m4a"))
+			},
+			want: []string{"a.mp3", "a.mp3.json", "b.m4a"},
+		},
+		"temporary files are ignored": {
+			write: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, ".a.mp3"))
+				writeFile(t, filepath.Join(dir, "a.mp3.tmp"))
+				writeFile(t, filepath.Join(dir, "a.mp3.part"))
+				writeFile(t, filepath.Join(dir, "a.mp3~"))
+				writeFile(t, filepath.Join(dir, "a.mp3"))
+			},
+			want: []string{"a.mp3"},
+		},
+		"new subdirectories are watched": {
+			write: func(t *testing.T, dir string) {
+				sub := filepath.Join(dir, "ann")
+				if err := os.Mkdir(sub, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(sub, "a.mp3"))
+				// NOTE: 監視が始まった後に書かれたファイルも反映されるように、少し待ってから書く
+				time.Sleep(quietPeriod / 4)
+				writeFile(t, filepath.Join(sub, "b.mp3"))
+			},
+			want: []string{"ann/a.mp3", "ann/b.mp3"},
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			u := &fakeUpdater{calls: make(chan []string, 10)}
+			runFileWatcher(t, dir, quietPeriod, time.Minute, u)
+
+			tt.write(t, dir)
+
+			var got []string
+			select {
+			case got = <-u.calls:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Update was not called")
+			}
+			// NOTE: キーの重複は Update に渡す前にまとめられる
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Update() keys = %v, want %v", got, tt.want)
+			}
+			select {
+			case keys := <-u.calls:
+				t.Errorf("Update should be called once, but called again with %v", keys)
+			case <-time.After(2 * quietPeriod):
+			}
+		})
+	}
+}
+
+func TestFileWatcher_maxWait(t *testing.T) {
+	t.Parallel()
+	const (
+		quietPeriod = 200 * time.Millisecond
+		maxWait     = 500 * time.Millisecond
+	)
+	dir := t.TempDir()
+	u := &fakeUpdater{calls: make(chan []string, 10)}
+	runFileWatcher(t, dir, quietPeriod, maxWait, u)
+
+	// NOTE: quietPeriod より短い間隔で書き続けても maxWait 経てば反映される
+	started := time.Now()
+	for time.Since(started) < 4*maxWait {
+		writeFile(t, filepath.Join(dir, "a.mp3"))
+		select {
+		case got := <-u.calls:
+			if want := []string{"a.mp3"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Update() keys = %v, want %v", got, want)
+			}
+			return
+		case <-time.After(quietPeriod / 4):
+		}
+	}
+	t.Fatal("Update was not called while files kept changing")
+}
+
+func TestFileWatcher_retry(t *testing.T) {
+	t.Parallel()
+	const quietPeriod = 200 * time.Millisecond
+	dir := t.TempDir()
+	u := &fakeUpdater{calls: make(chan []string, 10), failures: 1}
+	runFileWatcher(t, dir, quietPeriod, time.Minute, u)
+
+	receive := func() []string {
+		t.Helper()
+		select {
+		case keys := <-u.calls:
+			return keys
+		case <-time.After(5 * time.Second):
+			t.Fatal("Update was not called")
+			return nil
+		}
+	}
+	writeFile(t, filepath.Join(dir, "a.mp3"))
+	if got, want := receive(), []string{"a.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Update() keys = %v, want %v", got, want)
+	}
+	// NOTE: 失敗したキーは次の変更と一緒に反映する
+	writeFile(t, filepath.Join(dir, "b.mp3"))
+	if got, want := receive(), []string{"a.mp3", "b.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Update() keys after a failure = %v, want %v", got, want)
+	}
+}
+
+// runFileWatcher は dir の監視を始めて、テストの終わりに止める。
+func runFileWatcher(t *testing.T, dir string, quietPeriod, maxWait time.Duration, u updater) {
+	t.Helper()
+	w, err := newFileWatcher(zerolog.Nop(), dir, quietPeriod, maxWait, u)
+	if err != nil {
+		t.Fatalf("newFileWatcher() error = %+v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		w.Close()
+		<-done
+	})
+}
+
+func writeFile(t *testing.T, name string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte("test"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
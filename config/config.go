@@ -4,25 +4,76 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/mail"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/goccy/go-yaml"
 	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/ffmpeg"
 	"github.com/upamune/radicaster/timeutil"
 )
 
 const (
-	AudioFormatAAC = "aac"
-	AudioFormatMP3 = "mp3"
+	// AudioFormatAAC は再エンコードせずに m4a に入れ直す。
+	AudioFormatAAC  = "aac"
+	AudioFormatM4A  = "m4a"
+	AudioFormatMP3  = "mp3"
+	AudioFormatOpus = "opus"
+	AudioFormatFLAC = "flac"
+
+	// RecordModeTimeshift は放送が終わってからタイムフリーで録音する。
+	RecordModeTimeshift = "timeshift"
+	// RecordModeLive は放送の開始時刻からライブで録音する。
+	RecordModeLive = "live"
+
+	// ZenrokuModeProgram は全録で番組ごとに1つのファイルにする。
+	ZenrokuModeProgram = "program"
+	// ZenrokuModeDay は全録で局ごとに放送日の全ての番組を1つのファイルにして、番組をチャプターにする。
+	ZenrokuModeDay = "day"
 
 	zenrokuDefaultCronExpression = "0 3 * * *"
+	ruleDefaultCronExpression    = "15 * * * *"
 )
 
 type Config struct {
 	Programs []Program `yaml:"programs" json:"programs"`
 	Zenroku  Zenroku   `yaml:"zenroku" json:"zenroku"`
+	Rules    []Rule    `yaml:"rules,omitempty" json:"rules,omitempty"`
+	// Profiles は encoding で指定できる変換の設定。組み込みのプロファイルと同じ名前の場合はこちらを使う。
+	Profiles map[string]ffmpeg.Profile `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+}
+
+// Profile は encoding の名前の変換の設定を返す。空の場合は AudioFormatAAC として扱う。
+func (c Config) Profile(encoding string) (ffmpeg.Profile, error) {
+	if encoding == "" {
+		encoding = AudioFormatAAC
+	}
+	if p, ok := c.Profiles[encoding]; ok {
+		return p, nil
+	}
+	if p, ok := ffmpeg.BuiltinProfiles[encoding]; ok {
+		return p, nil
+	}
+	return ffmpeg.Profile{}, errors.Newf("encodingが不正です: %s", encoding)
+}
+
+// Encodings は encoding に指定できる名前を返す。
+func (c Config) Encodings() []string {
+	encodings := make([]string, 0, len(ffmpeg.BuiltinProfiles)+len(c.Profiles))
+	for name := range ffmpeg.BuiltinProfiles {
+		encodings = append(encodings, name)
+	}
+	for name := range c.Profiles {
+		if _, ok := ffmpeg.BuiltinProfiles[name]; !ok {
+			encodings = append(encodings, name)
+		}
+	}
+	slices.Sort(encodings)
+	return encodings
 }
 
 type Stations map[string]Station
@@ -33,10 +84,56 @@ type Zenroku struct {
 	Encoding         string   `yaml:"encoding" json:"encoding"`
 	Stations         Stations `yaml:"stations" json:"stations"`
 	EnableStationIDs []string `yaml:"enable_stations" json:"enable_stations"`
+	// NOTE: 全録の保持ポリシーは局ごとに適用される
+	Retention   Retention       `yaml:"retention,omitempty" json:"retention,omitempty"`
+	PostProcess ffmpeg.Pipeline `yaml:"post_process,omitempty" json:"post_process,omitempty"`
+	Mode        string          `yaml:"mode,omitempty" json:"mode,omitempty"` // NOTE: 省略すると番組ごとに録音する
+}
+
+// IsDayMode は全録で局ごとに放送日を1つのファイルにするかを返す。
+func (z Zenroku) IsDayMode() bool {
+	return z.Mode == ZenrokuModeDay
+}
+
+// Retention は番組(全録の場合は局)ごとに残すエピソードの上限を表す。
+// 0 の項目は無制限として扱う。
+type Retention struct {
+	MaxEpisodes   int   `yaml:"max_episodes,omitempty" json:"max_episodes,omitempty"`
+	MaxAgeDays    int   `yaml:"max_age_days,omitempty" json:"max_age_days,omitempty"`
+	MaxTotalBytes int64 `yaml:"max_total_bytes,omitempty" json:"max_total_bytes,omitempty"`
+}
+
+func (r Retention) IsZero() bool {
+	return r.MaxEpisodes <= 0 && r.MaxAgeDays <= 0 && r.MaxTotalBytes <= 0
+}
+
+// Channel はフィードのチャンネルに表示する情報を表す。
+// Category はApple Podcastsのカテゴリ名(例: Comedy)で、空の項目はデフォルトの値を使う。
+type Channel struct {
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Author      string `yaml:"author,omitempty" json:"author,omitempty"`
+	OwnerEmail  string `yaml:"owner_email,omitempty" json:"owner_email,omitempty"`
+	Category    string `yaml:"category,omitempty" json:"category,omitempty"`
+	Language    string `yaml:"language,omitempty" json:"language,omitempty"`
+	Explicit    bool   `yaml:"explicit,omitempty" json:"explicit,omitempty"`
+}
+
+func (c Channel) IsZero() bool {
+	return c == Channel{}
+}
+
+func (c Channel) validate() error {
+	if c.OwnerEmail != "" {
+		if _, err := mail.ParseAddress(c.OwnerEmail); err != nil {
+			return errors.Wrapf(err, "owner_emailが不正です: %s", c.OwnerEmail)
+		}
+	}
+	return nil
 }
 
 type Station struct {
-	ImageURL string `yaml:"image_url" json:"image_url"`
+	ImageURL string  `yaml:"image_url" json:"image_url"`
+	Channel  Channel `yaml:"channel,omitempty" json:"channel,omitempty"`
 }
 
 type Program struct {
@@ -47,8 +144,139 @@ type Program struct {
 	StationID string             `yaml:"station" json:"station"`
 	Start     string             `yaml:"start" json:"start"`
 	Encoding  string             `yaml:"encoding" json:"encoding"`
+	Mode      string             `yaml:"mode,omitempty" json:"mode,omitempty"` // NOTE: 省略するとタイムフリーで録音する
 	ImageURL  string             `yaml:"image_url" json:"image_url"`
 	Path      string             `yaml:"path" json:"path"`
+	Retention Retention          `yaml:"retention,omitempty" json:"retention,omitempty"`
+	Channel   Channel            `yaml:"channel,omitempty" json:"channel,omitempty"`
+	// PostProcess は保存する前に順に適用する後処理。
+	PostProcess ffmpeg.Pipeline `yaml:"post_process,omitempty" json:"post_process,omitempty"`
+}
+
+// IsLive は番組をライブで録音するかを返す。
+func (p Program) IsLive() bool {
+	return p.Mode == RecordModeLive
+}
+
+// FeedPath は番組のエピソードが載るフィードのパスを返す。
+func (p Program) FeedPath() string {
+	return strings.ToLower(strings.TrimPrefix(p.Path, "/"))
+}
+
+// Schedule は録音する実際の曜日と "HHMM" 形式の開始時刻を返す。
+// Start が 24:00 から 28:59 の場合、Weekdays は番組表の曜日として扱い翌日に変換する。
+func (p Program) Schedule() ([]timeutil.Weekday, string, error) {
+	if len(p.Weekdays) == 0 {
+		_, start, err := timeutil.NormalizeBroadcastTime(0, p.Start)
+		return nil, start, err
+	}
+	weekdays := make([]timeutil.Weekday, 0, len(p.Weekdays))
+	var start string
+	for _, wd := range p.Weekdays {
+		weekday, s, err := timeutil.NormalizeBroadcastTime(wd, p.Start)
+		if err != nil {
+			return nil, "", err
+		}
+		weekdays = append(weekdays, weekday)
+		start = s
+	}
+	return weekdays, start, nil
+}
+
+// NewProgramForBroadcast は番組表の放送を毎週録音する番組の設定を返す。
+// 曜日と開始時刻は番組表の通りにするので、水曜1時の番組は火曜の "2500" になる。
+// cron は省略するので、番組表の終了時刻から少し後に録音する。
+func NewProgramForBroadcast(title, areaID, stationID string, from time.Time) Program {
+	weekday, start := timeutil.BroadcastClock(from.In(timeutil.JST()))
+	return Program{
+		Title:     title,
+		Weekdays:  []timeutil.Weekday{timeutil.Weekday(weekday)},
+		AreaID:    areaID,
+		StationID: stationID,
+		Start:     strings.Replace(start, ":", "", 1),
+		Encoding:  AudioFormatAAC,
+	}
+}
+
+// ChannelByFeedPath は feedPath のフィードに対応する番組(全録の場合は局)のチャンネル情報と画像を返す。
+func (c Config) ChannelByFeedPath(feedPath string) (Channel, string, bool) {
+	if stationID, ok := strings.CutPrefix(feedPath, "zenroku/"); ok {
+		station, ok := c.Zenroku.Stations[strings.ToLower(stationID)]
+		return station.Channel, station.ImageURL, ok
+	}
+	for _, p := range c.Programs {
+		if p.FeedPath() == feedPath {
+			return p.Channel, p.ImageURL, true
+		}
+	}
+	return Channel{}, "", false
+}
+
+// Rule は番組表をキーワードで検索して録音する条件を表す。
+type Rule struct {
+	Title       string          `yaml:"title" json:"title"`
+	Cron        string          `yaml:"cron" json:"cron"`
+	Keywords    Keywords        `yaml:"keywords" json:"keywords"`
+	AreaID      string          `yaml:"area,omitempty" json:"area,omitempty"`
+	StationIDs  []string        `yaml:"stations,omitempty" json:"stations,omitempty"`
+	Window      TimeWindow      `yaml:"window,omitempty" json:"window,omitempty"`
+	Encoding    string          `yaml:"encoding" json:"encoding"`
+	ImageURL    string          `yaml:"image_url" json:"image_url"`
+	Path        string          `yaml:"path" json:"path"`
+	Retention   Retention       `yaml:"retention,omitempty" json:"retention,omitempty"`
+	PostProcess ffmpeg.Pipeline `yaml:"post_process,omitempty" json:"post_process,omitempty"`
+}
+
+// Keywords はいずれかのキーワードを含む番組にマッチする。
+type Keywords struct {
+	Title       []string `yaml:"title,omitempty" json:"title,omitempty"`
+	Performer   []string `yaml:"performer,omitempty" json:"performer,omitempty"`
+	Description []string `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+func (k Keywords) IsZero() bool {
+	return len(k.Title) == 0 && len(k.Performer) == 0 && len(k.Description) == 0
+}
+
+// TimeWindow は番組の開始時刻の範囲を "HHMM" 形式で表す。
+// From > To の場合は日をまたぐ範囲として扱う。
+type TimeWindow struct {
+	From string `yaml:"from,omitempty" json:"from,omitempty"`
+	To   string `yaml:"to,omitempty" json:"to,omitempty"`
+}
+
+func (w TimeWindow) IsZero() bool {
+	return w.From == "" && w.To == ""
+}
+
+// Contains は "HHMM" 形式の時刻が範囲内かどうかを返す。
+func (w TimeWindow) Contains(hhmm string) bool {
+	if w.IsZero() {
+		return true
+	}
+	from, to := w.From, w.To
+	if from == "" {
+		from = "0000"
+	}
+	if to == "" {
+		to = "2400"
+	}
+	if from <= to {
+		return from <= hhmm && hhmm < to
+	}
+	return from <= hhmm || hhmm < to
+}
+
+func (w TimeWindow) validate() error {
+	for _, t := range []string{w.From, w.To} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("1504", t); err != nil && t != "2400" {
+			return errors.Newf("windowの時刻は HHMM 形式で指定してください: %s", t)
+		}
+	}
+	return nil
 }
 
 func Parse(r io.Reader) (Config, error) {
@@ -61,6 +289,14 @@ func Parse(r io.Reader) (Config, error) {
 			c.Programs[i].Encoding = AudioFormatAAC
 		}
 	}
+	for i := range c.Rules {
+		if c.Rules[i].Cron == "" {
+			c.Rules[i].Cron = ruleDefaultCronExpression
+		}
+		if c.Rules[i].Encoding == "" {
+			c.Rules[i].Encoding = AudioFormatAAC
+		}
+	}
 	if c.Zenroku.Cron == "" {
 		c.Zenroku.Cron = zenrokuDefaultCronExpression
 	}
@@ -116,8 +352,24 @@ func Init(configFilePath *string, configURL *string) (Config, error) {
 	return Config{}, nil
 }
 
+func (r Retention) MarshalZerologObject(e *zerolog.Event) {
+	e.Int("max_episodes", r.MaxEpisodes).
+		Int("max_age_days", r.MaxAgeDays).
+		Int64("max_total_bytes", r.MaxTotalBytes)
+}
+
+func (c Channel) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("description", c.Description).
+		Str("author", c.Author).
+		Str("owner_email", c.OwnerEmail).
+		Str("category", c.Category).
+		Str("language", c.Language).
+		Bool("explicit", c.Explicit)
+}
+
 func (s Station) MarshalZerologObject(e *zerolog.Event) {
-	e.Str("image_url", s.ImageURL)
+	e.Str("image_url", s.ImageURL).
+		Object("channel", s.Channel)
 }
 
 func (s Stations) MarshalZerologObject(e *zerolog.Event) {
@@ -130,8 +382,10 @@ func (z Zenroku) MarshalZerologObject(e *zerolog.Event) {
 	e.Str("cron", z.Cron).
 		Str("encoding", z.Encoding).
 		Bool("enable", z.Enable).
+		Str("mode", z.Mode).
 		Strs("enable_station_ids", z.EnableStationIDs).
-		Object("stations", z.Stations)
+		Object("stations", z.Stations).
+		Object("retention", z.Retention)
 }
 
 func (p Program) MarshalZerologObject(e *zerolog.Event) {
@@ -140,8 +394,38 @@ func (p Program) MarshalZerologObject(e *zerolog.Event) {
 		Str("station_id", p.StationID).
 		Str("start", p.Start).
 		Str("encoding", p.Encoding).
+		Str("mode", p.Mode).
 		Str("image_url", p.ImageURL).
-		Str("path", p.Path)
+		Str("path", p.Path).
+		Object("retention", p.Retention).
+		Object("channel", p.Channel)
+}
+
+func (k Keywords) MarshalZerologObject(e *zerolog.Event) {
+	e.Strs("title", k.Title).
+		Strs("performer", k.Performer).
+		Strs("description", k.Description)
+}
+
+func (r Rule) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("cron", r.Cron).
+		Str("title", r.Title).
+		Object("keywords", r.Keywords).
+		Strs("station_ids", r.StationIDs).
+		Str("window_from", r.Window.From).
+		Str("window_to", r.Window.To).
+		Str("encoding", r.Encoding).
+		Str("image_url", r.ImageURL).
+		Str("path", r.Path).
+		Object("retention", r.Retention)
+}
+
+type Rules []Rule
+
+func (r Rules) MarshalZerologArray(a *zerolog.Array) {
+	for _, r := range r {
+		a.Object(r)
+	}
 }
 
 type Programs []Program
@@ -153,10 +437,30 @@ func (p Programs) MarshalZerologArray(a *zerolog.Array) {
 }
 
 func (c Config) MarshalZerologObject(e *zerolog.Event) {
-	e.Array("programs", Programs(c.Programs))
+	e.Array("programs", Programs(c.Programs)).
+		Array("rules", Rules(c.Rules)).
+		Any("profiles", c.Profiles)
+}
+
+// validateEncoding は encoding のプロファイルがあり、後処理と組み合わせられるかを確かめる。
+func (c Config) validateEncoding(encoding string, postProcess ffmpeg.Pipeline) error {
+	profile, err := c.Profile(encoding)
+	if err != nil {
+		return err
+	}
+	// NOTE: 両方で指定するとラウドネスの正規化が2回かかってしまう
+	if profile.Loudnorm && postProcess.Has(ffmpeg.StepLoudnorm) {
+		return errors.Newf("encodingのプロファイルとpost_processの両方でloudnormが指定されています: encoding=%s", encoding)
+	}
+	return nil
 }
 
 func (c Config) Validate() error {
+	for name, profile := range c.Profiles {
+		if err := profile.Validate(); err != nil {
+			return errors.Wrapf(err, "profile=%s", name)
+		}
+	}
 	for _, program := range c.Programs {
 		p := strings.ToLower(strings.TrimPrefix(program.Path, "/"))
 		if p == "all" {
@@ -165,6 +469,87 @@ func (c Config) Validate() error {
 				program.Title,
 			)
 		}
+		if _, _, err := program.Schedule(); err != nil {
+			return errors.Wrapf(err, "startは HHMM 形式(24:00から28:59も可)で指定してください: program_title=%s", program.Title)
+		}
+		switch program.Mode {
+		case "", RecordModeTimeshift, RecordModeLive:
+		default:
+			return errors.Newf(
+				"modeは %s か %s を指定してください: program_title=%s, mode=%s",
+				RecordModeTimeshift, RecordModeLive, program.Title, program.Mode,
+			)
+		}
+		if err := c.validateEncoding(program.Encoding, program.PostProcess); err != nil {
+			return errors.Wrapf(err, "program_title=%s", program.Title)
+		}
+		if err := program.PostProcess.Validate(); err != nil {
+			return errors.Wrapf(err, "program_title=%s", program.Title)
+		}
+		if err := program.Retention.validate(); err != nil {
+			return errors.Wrapf(err, "program_title=%s", program.Title)
+		}
+		if err := program.Channel.validate(); err != nil {
+			return errors.Wrapf(err, "program_title=%s", program.Title)
+		}
+	}
+	for _, rule := range c.Rules {
+		p := strings.ToLower(strings.TrimPrefix(rule.Path, "/"))
+		if p == "all" {
+			return errors.Newf(
+				"pathに `all` は使用できません: rule_title=%s",
+				rule.Title,
+			)
+		}
+		if rule.Keywords.IsZero() {
+			return errors.Newf(
+				"keywordsを1つ以上指定してください: rule_title=%s",
+				rule.Title,
+			)
+		}
+		if err := rule.Window.validate(); err != nil {
+			return errors.Wrapf(err, "rule_title=%s", rule.Title)
+		}
+		if err := c.validateEncoding(rule.Encoding, rule.PostProcess); err != nil {
+			return errors.Wrapf(err, "rule_title=%s", rule.Title)
+		}
+		if err := rule.PostProcess.Validate(); err != nil {
+			return errors.Wrapf(err, "rule_title=%s", rule.Title)
+		}
+		if err := rule.Retention.validate(); err != nil {
+			return errors.Wrapf(err, "rule_title=%s", rule.Title)
+		}
+	}
+	if c.Zenroku.Enable {
+		if err := c.validateEncoding(c.Zenroku.Encoding, c.Zenroku.PostProcess); err != nil {
+			return errors.Wrap(err, "zenroku")
+		}
+	}
+	switch c.Zenroku.Mode {
+	case "", ZenrokuModeProgram, ZenrokuModeDay:
+	default:
+		return errors.Newf("zenrokuのmodeは %s か %s を指定してください: mode=%s", ZenrokuModeProgram, ZenrokuModeDay, c.Zenroku.Mode)
+	}
+	if err := c.Zenroku.PostProcess.Validate(); err != nil {
+		return errors.Wrap(err, "zenroku")
+	}
+	if err := c.Zenroku.Retention.validate(); err != nil {
+		return errors.Wrap(err, "zenroku")
+	}
+	for stationID, station := range c.Zenroku.Stations {
+		if err := station.Channel.validate(); err != nil {
+			return errors.Wrapf(err, "zenroku station_id=%s", stationID)
+		}
+	}
+	return nil
+}
+
+func (r Retention) validate() error {
+	if r.MaxEpisodes < 0 || r.MaxAgeDays < 0 || r.MaxTotalBytes < 0 {
+		return errors.Newf(
+			"retentionに負の値は指定できません: max_episodes=%d, max_age_days=%d, max_total_bytes=%d",
+			r.MaxEpisodes, r.MaxAgeDays, r.MaxTotalBytes,
+		)
 	}
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/upamune/radicaster/ffmpeg"
 	"github.com/upamune/radicaster/timeutil"
 )
 
@@ -24,6 +25,18 @@ func TestParse(t *testing.T) {
 				Start:     "0100",
 				Encoding:  "aac",
 				ImageURL:  "http://example.com/image.png",
+				Retention: Retention{
+					MaxEpisodes: 10,
+					MaxAgeDays:  30,
+				},
+				Channel: Channel{
+					Description: "深夜のラジオ番組",
+					Author:      "ニッポン放送",
+					OwnerEmail:  "owner@example.com",
+					Category:    "Comedy",
+					Language:    "ja",
+					Explicit:    true,
+				},
 			},
 			{
 				Weekdays: []timeutil.Weekday{
@@ -33,6 +46,40 @@ func TestParse(t *testing.T) {
 				StationID: "LFR",
 				Start:     "0300",
 				Encoding:  "mp3",
+				Mode:      RecordModeLive,
+			},
+		},
+		Zenroku: Zenroku{
+			Cron:     zenrokuDefaultCronExpression,
+			Encoding: AudioFormatAAC,
+			Stations: Stations{
+				"LFR": {
+					ImageURL: "http://example.com/lfr.png",
+					Channel:  Channel{Author: "ニッポン放送"},
+				},
+				"lfr": {
+					ImageURL: "http://example.com/lfr.png",
+					Channel:  Channel{Author: "ニッポン放送"},
+				},
+			},
+			Retention: Retention{
+				MaxTotalBytes: 1 << 30,
+			},
+		},
+		Rules: []Rule{
+			{
+				Title: "ゲスト出演",
+				Cron:  ruleDefaultCronExpression,
+				Keywords: Keywords{
+					Performer: []string{"星野源"},
+				},
+				StationIDs: []string{"TBS", "LFR"},
+				Window: TimeWindow{
+					From: "2200",
+					To:   "0500",
+				},
+				Encoding: "aac",
+				Path:     "guest",
 			},
 		},
 	}
@@ -62,3 +109,229 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeWindow_Contains(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		window TimeWindow
+		hhmm   string
+		want   bool
+	}{
+		{window: TimeWindow{}, hhmm: "0300", want: true},
+		{window: TimeWindow{From: "0100", To: "0300"}, hhmm: "0100", want: true},
+		{window: TimeWindow{From: "0100", To: "0300"}, hhmm: "0300", want: false},
+		{window: TimeWindow{From: "2200", To: "0500"}, hhmm: "2330", want: true},
+		{window: TimeWindow{From: "2200", To: "0500"}, hhmm: "0400", want: true},
+		{window: TimeWindow{From: "2200", To: "0500"}, hhmm: "1200", want: false},
+		{window: TimeWindow{From: "1800"}, hhmm: "2359", want: true},
+		{window: TimeWindow{To: "0600"}, hhmm: "0700", want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.window.From+"-"+tt.window.To+"@"+tt.hhmm, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.window.Contains(tt.hhmm); got != tt.want {
+				t.Errorf("Contains() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_ChannelByFeedPath(t *testing.T) {
+	t.Parallel()
+	c := Config{
+		Programs: []Program{
+			{Path: "/ANN", ImageURL: "http://example.com/ann.png", Channel: Channel{Author: "ニッポン放送"}},
+		},
+		Zenroku: Zenroku{
+			Stations: Stations{
+				"tbs": {ImageURL: "http://example.com/tbs.png", Channel: Channel{Language: "ja"}},
+			},
+		},
+	}
+	tests := map[string]struct {
+		feedPath     string
+		wantChannel  Channel
+		wantImageURL string
+		wantOK       bool
+	}{
+		"program": {
+			feedPath:     "ann",
+			wantChannel:  Channel{Author: "ニッポン放送"},
+			wantImageURL: "http://example.com/ann.png",
+			wantOK:       true,
+		},
+		"zenroku station": {
+			feedPath:     "zenroku/tbs",
+			wantChannel:  Channel{Language: "ja"},
+			wantImageURL: "http://example.com/tbs.png",
+			wantOK:       true,
+		},
+		"unknown": {
+			feedPath: "unknown",
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			gotChannel, gotImageURL, gotOK := c.ChannelByFeedPath(tt.feedPath)
+			if gotChannel != tt.wantChannel || gotImageURL != tt.wantImageURL || gotOK != tt.wantOK {
+				t.Errorf(
+					"ChannelByFeedPath(%q) = (%+v, %q, %v), want (%+v, %q, %v)",
+					tt.feedPath, gotChannel, gotImageURL, gotOK, tt.wantChannel, tt.wantImageURL, tt.wantOK,
+				)
+			}
+		})
+	}
+}
+
+func TestNewProgramForBroadcast(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		from time.Time
+		want Program
+	}{
+		"late night": {
+			// NOTE: 水曜1時は火曜25:00
+			from: time.Date(2023, time.September, 27, 1, 0, 0, 0, timeutil.JST()),
+			want: Program{
+				Title:     "ANN",
+				Weekdays:  []timeutil.Weekday{timeutil.Weekday(time.Tuesday)},
+				StationID: "LFR",
+				Start:     "2500",
+				Encoding:  AudioFormatAAC,
+			},
+		},
+		"before midnight": {
+			from: time.Date(2023, time.September, 30, 23, 0, 0, 0, timeutil.JST()),
+			want: Program{
+				Title:     "ANN",
+				Weekdays:  []timeutil.Weekday{timeutil.Weekday(time.Saturday)},
+				StationID: "LFR",
+				Start:     "2300",
+				Encoding:  AudioFormatAAC,
+			},
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got := NewProgramForBroadcast("ANN", "", "LFR", tt.from)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewProgramForBroadcast() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgram_Schedule(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		program      Program
+		wantWeekdays []timeutil.Weekday
+		wantStart    string
+		wantErr      bool
+	}{
+		"calendar time": {
+			program:      Program{Weekdays: []timeutil.Weekday{timeutil.Weekday(time.Wednesday)}, Start: "0100"},
+			wantWeekdays: []timeutil.Weekday{timeutil.Weekday(time.Wednesday)},
+			wantStart:    "0100",
+		},
+		"broadcast time": {
+			program:      Program{Weekdays: []timeutil.Weekday{timeutil.Weekday(time.Tuesday), timeutil.Weekday(time.Saturday)}, Start: "25:00"},
+			wantWeekdays: []timeutil.Weekday{timeutil.Weekday(time.Wednesday), timeutil.Weekday(time.Sunday)},
+			wantStart:    "0100",
+		},
+		"out of range": {
+			program: Program{Weekdays: []timeutil.Weekday{timeutil.Weekday(time.Tuesday)}, Start: "2900"},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			weekdays, start, err := tt.program.Schedule()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Schedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(weekdays, tt.wantWeekdays) || start != tt.wantStart {
+				t.Errorf("Schedule() = %v %s, want %v %s", weekdays, start, tt.wantWeekdays, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestConfig_Profile(t *testing.T) {
+	t.Parallel()
+	c := Config{
+		Profiles: map[string]ffmpeg.Profile{
+			"podcast": {Codec: ffmpeg.CodecAAC, Bitrate: "96k", Loudnorm: true},
+			"mp3":     {Codec: ffmpeg.CodecMP3, Bitrate: "128k"},
+		},
+	}
+	tests := map[string]struct {
+		encoding string
+		wantExt  string
+		wantErr  bool
+	}{
+		"default":  {encoding: "", wantExt: "m4a"},
+		"builtin":  {encoding: AudioFormatOpus, wantExt: "opus"},
+		"custom":   {encoding: "podcast", wantExt: "m4a"},
+		"override": {encoding: AudioFormatMP3, wantExt: "mp3"},
+		"unknown":  {encoding: "wav", wantErr: true},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := c.Profile(tt.encoding)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Profile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Ext() != tt.wantExt {
+				t.Errorf("Profile().Ext() = %s, want %s", got.Ext(), tt.wantExt)
+			}
+		})
+	}
+	if got, _ := c.Profile(AudioFormatMP3); got.Bitrate != "128k" {
+		t.Errorf("Profile(%q) should prefer the configured profile: %+v", AudioFormatMP3, got)
+	}
+	if got, want := c.Encodings(), []string{"aac", "flac", "m4a", "mp3", "opus", "podcast"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Encodings() = %v, want %v", got, want)
+	}
+	if err := (Config{Programs: []Program{{Title: "ANN", Start: "0100", Encoding: "wav"}}}).Validate(); err == nil {
+		t.Errorf("Validate() should reject an unknown encoding")
+	}
+}
+
+func TestConfig_validateEncoding(t *testing.T) {
+	t.Parallel()
+	c := Config{
+		Profiles: map[string]ffmpeg.Profile{
+			"podcast": {Codec: ffmpeg.CodecAAC, Bitrate: "96k", Loudnorm: true},
+		},
+	}
+	loudnorm := ffmpeg.Pipeline{{Type: ffmpeg.StepLoudnorm}}
+	tests := map[string]struct {
+		encoding    string
+		postProcess ffmpeg.Pipeline
+		wantErr     bool
+	}{
+		"profile only":      {encoding: "podcast"},
+		"post_process only": {encoding: AudioFormatAAC, postProcess: loudnorm},
+		"both":              {encoding: "podcast", postProcess: loudnorm, wantErr: true},
+		"unknown":           {encoding: "wav", wantErr: true},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.validateEncoding(tt.encoding, tt.postProcess); (err != nil) != tt.wantErr {
+				t.Errorf("validateEncoding() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
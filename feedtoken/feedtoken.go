@@ -0,0 +1,159 @@
+// Package feedtoken はフィードごとの秘密のトークンを管理する。
+// ポッドキャストアプリはBasic認証を扱えないので、フィードと音声ファイルのURLにトークンを付けて認可する。
+package feedtoken
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+)
+
+// QueryParam はURLでトークンを渡すクエリパラメータの名前。
+const QueryParam = "token"
+
+// AllFeedPath は全てのエピソードを載せるフィードのパス。
+// このフィードのトークンは全ての音声ファイルにアクセスできる。
+const AllFeedPath = "all"
+
+const tokenBytes = 24
+
+// FeedToken はフィードのパスとそのトークン。
+type FeedToken struct {
+	FeedPath string `json:"feed_path"`
+	Token    string `json:"token"`
+}
+
+// Store はフィードのパスごとのトークンを保持する。
+// path が空の場合はファイルに保存しない。
+type Store struct {
+	mu     sync.RWMutex
+	path   string
+	tokens map[string]string
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:   path,
+		tokens: make(map[string]string),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Wrap(err, "failed to open feed token file")
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&s.tokens); err != nil {
+		return nil, errors.Wrap(err, "failed to decode feed token json")
+	}
+	return s, nil
+}
+
+// Token はフィードのトークンを返す。無ければ作る。
+func (s *Store) Token(feedPath string) (string, error) {
+	s.mu.RLock()
+	token, ok := s.tokens[feedPath]
+	s.mu.RUnlock()
+	if ok {
+		return token, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// NOTE: ロックを取り直している間に他で作られているかもしれない
+	if token, ok := s.tokens[feedPath]; ok {
+		return token, nil
+	}
+	return s.issue(feedPath)
+}
+
+// Rotate はフィードのトークンを作り直す。古いトークンは使えなくなる。
+func (s *Store) Rotate(feedPath string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issue(feedPath)
+}
+
+// Verify はトークンがフィードのものと一致するかを返す。
+func (s *Store) Verify(feedPath, token string) bool {
+	if token == "" {
+		return false
+	}
+	s.mu.RLock()
+	want, ok := s.tokens[feedPath]
+	s.mu.RUnlock()
+	return ok && subtle.ConstantTimeCompare([]byte(want), []byte(token)) == 1
+}
+
+// List はフィードのパス順にトークンを返す。
+func (s *Store) List() []FeedToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tokens := make([]FeedToken, 0, len(s.tokens))
+	for feedPath, token := range s.tokens {
+		tokens = append(tokens, FeedToken{FeedPath: feedPath, Token: token})
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].FeedPath < tokens[j].FeedPath
+	})
+	return tokens
+}
+
+func (s *Store) issue(feedPath string) (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	prev, hadPrev := s.tokens[feedPath]
+	s.tokens[feedPath] = token
+	if err := s.save(); err != nil {
+		// NOTE: 保存できなかったトークンは再起動すると使えなくなるので戻しておく
+		if hadPrev {
+			s.tokens[feedPath] = prev
+		} else {
+			delete(s.tokens, feedPath)
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	// NOTE: 書き込み途中で落ちてもトークンが壊れないように一時ファイルからリネームする
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".feed-tokens-*.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp feed token file")
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(s.tokens); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to encode feed token json")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temp feed token file")
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return errors.Wrap(err, "failed to rename feed token file")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package feedtoken
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "feed_tokens.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	token, err := s.Token("ann")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if again, _ := s.Token("ann"); again != token {
+		t.Errorf("Token() should return the same token: got = %s, want %s", again, token)
+	}
+	if !s.Verify("ann", token) {
+		t.Errorf("Verify() should accept the issued token")
+	}
+	if s.Verify("junk", token) {
+		t.Errorf("Verify() should reject a token of another feed")
+	}
+	if s.Verify("unknown", "") {
+		t.Errorf("Verify() should reject an empty token")
+	}
+
+	rotated, err := s.Rotate("ann")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if rotated == token || s.Verify("ann", token) {
+		t.Errorf("Rotate() should invalidate the previous token")
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if !reloaded.Verify("ann", rotated) {
+		t.Errorf("reloaded store should accept the rotated token")
+	}
+	if got := reloaded.List(); len(got) != 1 || got[0].FeedPath != "ann" {
+		t.Errorf("List() got = %v", got)
+	}
+}
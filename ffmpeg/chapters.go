@@ -0,0 +1,67 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Chapter はファイルの先頭からの位置で表したチャプター。
+// End が Start 以前の場合は次のチャプターの開始までとして扱う。
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// supportsChapters はコンテナにチャプターを書けるかを返す。
+// NOTE: mp3 はID3v2のCHAP、m4a はQuickTimeのチャプタートラックとして書かれる
+func supportsChapters(container string) bool {
+	switch container {
+	case ContainerM4A, ContainerMP3:
+		return true
+	default:
+		return false
+	}
+}
+
+// ffmetadataEscaper は FFMETADATA の値で特別な意味を持つ文字をエスケープする。
+var ffmetadataEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"=", `\=`,
+	";", `\;`,
+	"#", `\#`,
+	"\n", "\\\n",
+)
+
+// formatFFMetadata はチャプターを ffmpeg の FFMETADATA の形式で返す。
+func formatFFMetadata(chapters []Chapter) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, c := range chapters {
+		end := c.End
+		if end <= c.Start && i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		b.WriteString("[CHAPTER]\n")
+		b.WriteString("TIMEBASE=1/1000\n")
+		fmt.Fprintf(&b, "START=%d\n", c.Start.Milliseconds())
+		// NOTE: 最後のチャプターの終わりが分からない場合は ffmpeg にファイルの最後までとして扱わせる
+		if end > c.Start {
+			fmt.Fprintf(&b, "END=%d\n", end.Milliseconds())
+		}
+		fmt.Fprintf(&b, "title=%s\n", ffmetadataEscaper.Replace(c.Title))
+	}
+	return b.String()
+}
+
+// writeFFMetadata はチャプターを書いた FFMETADATA のファイルを path に作る。
+func writeFFMetadata(path string, chapters []Chapter) error {
+	if err := os.WriteFile(path, []byte(formatFFMetadata(chapters)), 0o644); err != nil {
+		return errors.Wrap(err, "failed to write ffmetadata file")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package ffmpeg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatFFMetadata(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		chapters []Chapter
+		want     string
+	}{
+		"end is filled by the next chapter": {
+			chapters: []Chapter{
+				{Title: "オープニング", Start: 0},
+				{Title: "曲 / アーティスト", Start: 90 * time.Second, End: 5 * time.Minute},
+			},
+			want: ";FFMETADATA1\n" +
+				"[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=90000\ntitle=オープニング\n" +
+				"[CHAPTER]\nTIMEBASE=1/1000\nSTART=90000\nEND=300000\ntitle=曲 / アーティスト\n",
+		},
+		"last end is unknown": {
+			chapters: []Chapter{
+				{Title: "a=b; #1", Start: 1500 * time.Millisecond},
+			},
+			want: ";FFMETADATA1\n" +
+				"[CHAPTER]\nTIMEBASE=1/1000\nSTART=1500\ntitle=a\\=b\\; \\#1\n",
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := formatFFMetadata(tt.chapters); got != tt.want {
+				t.Errorf("formatFFMetadata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
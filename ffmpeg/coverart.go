@@ -0,0 +1,58 @@
+package ffmpeg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+)
+
+// IsEmbeddableImage は input の画像をそのまま mp3 と m4a に埋め込めるかどうかを返す。
+// NOTE: 画像は再エンコードせずに埋め込むので、どちらのコンテナでも使える JPEG と PNG だけを埋め込める
+func IsEmbeddableImage(input string) (bool, error) {
+	f, err := os.Open(input)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open image")
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, errors.Wrap(err, "failed to read image")
+	}
+	switch http.DetectContentType(head[:n]) {
+	case "image/jpeg", "image/png":
+		return true, nil
+	}
+	return false, nil
+}
+
+// ConvertImageToJPEG は input の画像の最初のフレームを JPEG にして output に書き出す。
+func ConvertImageToJPEG(ctx context.Context, logger zerolog.Logger, input, output string) error {
+	f, err := newFfmpeg(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create ffmpeg command")
+	}
+
+	f.setArgs("-nostdin")
+	f.setInput(input)
+	f.setArgs(
+		"-frames:v", "1",
+		"-f", "image2",
+		"-c:v", "mjpeg",
+		"-y", // overwrite the output file without asking
+	)
+
+	logger.Debug().
+		Str("label", "ffmpeg").
+		Str("command", f.String()).
+		Msg("convert image to jpeg by ffmpeg")
+
+	if b, err := f.runWithOutput(output); err != nil {
+		return errors.Wrapf(err, "failed to run ffmpeg: %s", string(b))
+	}
+	return nil
+}
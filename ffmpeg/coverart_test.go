@@ -0,0 +1,38 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsEmbeddableImage(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content []byte
+		want    bool
+	}{
+		"jpeg": {content: []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00"), want: true},
+		"png":  {content: []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR"), want: true},
+		"gif":  {content: []byte("GIF89a\x01\x00\x01\x00"), want: false},
+		"webp": {content: []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), want: false},
+		"html": {content: []byte("<html><body>not found</body></html>"), want: false},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			input := filepath.Join(t.TempDir(), "cover")
+			if err := os.WriteFile(input, tt.content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := IsEmbeddableImage(input)
+			if err != nil {
+				t.Fatalf("IsEmbeddableImage() error = %+v", err)
+			}
+			if got != tt.want {
+				t.Errorf("IsEmbeddableImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/rs/zerolog"
@@ -46,6 +49,11 @@ func (f *ffmpeg) run(output string) error {
 	return f.Run()
 }
 
+func (f *ffmpeg) runWithOutput(output string) ([]byte, error) {
+	f.setArgs(output)
+	return f.CombinedOutput()
+}
+
 func (f *ffmpeg) start(output string) error {
 	f.setArgs(output)
 	return f.Start()
@@ -64,51 +72,11 @@ func (f *ffmpeg) stderrPipe() (io.ReadCloser, error) {
 }
 
 // ConcatAACFilesFromList concatenates files from the list of resources.
-func ConcatAACFilesFromList(ctx context.Context, logger zerolog.Logger, resourcesDir string) (string, error) {
-	files, err := os.ReadDir(resourcesDir)
-	if err != nil {
-		return "", err
-	}
-
-	allFilePaths := []string{}
-	for _, f := range files {
-		p := filepath.Join(resourcesDir, f.Name())
-		allFilePaths = append(allFilePaths, p)
-	}
-	concatedFile := filepath.Join(resourcesDir, "concated.aac")
-	if err := ConcatAACFilesAll(ctx, logger, allFilePaths, resourcesDir, concatedFile); err != nil {
-		return "", err
-	}
-
-	return concatedFile, nil
-}
-
-// ConcatAACFilesAll concatenate files of the same type.
-func ConcatAACFilesAll(ctx context.Context, logger zerolog.Logger, files []string, resourcesDir string, output string) error {
-	// input is a path to a file which lists all the aac files.
-	// it may include a lot of aac file and exceed max number of file descriptor.
-	oneConcatNum := 100
-	if len(files) > oneConcatNum {
-		reducedFiles := files[:oneConcatNum]
-		restFiles := files[oneConcatNum:]
-		// reducedFiles -> reducedFiles[0]
-		tmpOutputFile, err := os.CreateTemp(resourcesDir, "tmp-concatenated-*.aac")
-		if err != nil {
-			return errors.Wrap(err, "failed to create a temporary file")
-		}
-		defer os.Remove(tmpOutputFile.Name())
-
-		if err := ConcatAACFiles(ctx, logger, reducedFiles, resourcesDir, tmpOutputFile.Name()); err != nil {
-			return errors.Wrap(err, "failed to concatenate aac files")
-		}
-		if err := ConcatAACFilesAll(ctx, logger, append([]string{tmpOutputFile.Name()}, restFiles...), resourcesDir, output); err != nil {
-			return errors.Wrap(err, "failed to concatenate aac files")
-		}
-
-		return nil
-	} else {
-		return ConcatAACFiles(ctx, logger, files, resourcesDir, output)
-	}
+// The list file is created in the same directory as output.
+// NOTE: concat demuxer は入力を1つずつ開くので、全録の1日分のチャンクでも1つのリストで結合する。
+// 途中のファイルを作ると全体を何度もコピーすることになる
+func ConcatAACFilesFromList(ctx context.Context, logger zerolog.Logger, files []string, output string) error {
+	return ConcatAACFiles(ctx, logger, files, filepath.Dir(output), output)
 }
 
 func ConcatAACFiles(ctx context.Context, logger zerolog.Logger, input []string, resourcesDir string, output string) error {
@@ -144,12 +112,6 @@ func ConcatAACFiles(ctx context.Context, logger zerolog.Logger, input []string,
 		Str("command", f.String()).
 		Msg("concatenate aac files by ffmpeg")
 
-	defer func() {
-		for _, f := range input {
-			os.Remove(f)
-		}
-	}()
-
 	stdErrPipe, err := f.stderrPipe()
 	if err != nil {
 		return errors.Wrap(err, "failed to get stderr pipe")
@@ -176,3 +138,46 @@ func ConcatAACFiles(ctx context.Context, logger zerolog.Logger, input []string,
 
 	return nil
 }
+
+// hlsReconnectDelayMaxSeconds is the maximum delay before ffmpeg reconnects to the HLS stream.
+const hlsReconnectDelayMaxSeconds = 30
+
+// RecordHLS records the live HLS stream for the duration with the given HTTP headers.
+func RecordHLS(ctx context.Context, logger zerolog.Logger, input string, headers map[string]string, duration time.Duration, output string) error {
+	f, err := newFfmpeg(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create ffmpeg command")
+	}
+
+	var h strings.Builder
+	for k, v := range headers {
+		fmt.Fprintf(&h, "%s: %s\r\n", k, v)
+	}
+	f.setArgs(
+		"-nostdin",
+		"-loglevel", "error",
+		"-headers", h.String(),
+		// NOTE: 配信が一瞬途切れても録音を止めないように、繋ぎ直す
+		"-reconnect", "1",
+		"-reconnect_streamed", "1",
+		"-reconnect_delay_max", strconv.Itoa(hlsReconnectDelayMaxSeconds),
+	)
+	f.setInput(input)
+	f.setArgs(
+		"-t", strconv.FormatFloat(duration.Seconds(), 'f', 0, 64),
+		"-vn",
+		"-c", "copy",
+		"-y",
+	)
+
+	logger.Debug().
+		Str("label", "ffmpeg").
+		Str("input", input).
+		Dur("duration", duration).
+		Msg("record hls by ffmpeg")
+
+	if b, err := f.runWithOutput(output); err != nil {
+		return errors.Wrapf(err, "failed to run ffmpeg: %s", string(b))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package ffmpeg
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ProbeDuration returns the duration of the media file by ffprobe.
+func ProbeDuration(ctx context.Context, input string) (time.Duration, error) {
+	cmdPath, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find ffprobe")
+	}
+
+	out, err := exec.CommandContext(
+		ctx,
+		cmdPath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		input,
+	).Output()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to run ffprobe")
+	}
+
+	sec, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse duration: %s", string(out))
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
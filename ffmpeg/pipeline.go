@@ -0,0 +1,271 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// StepLoudnorm はEBU R128のラウドネスに揃える。
+	StepLoudnorm = "loudnorm"
+	// StepTrimSilence は先頭と末尾の無音を取り除く。
+	StepTrimSilence = "trim_silence"
+	// StepTrimToProgram は番組表の開始時刻から終了時刻までに切り詰める。
+	StepTrimToProgram = "trim_to_program"
+
+	defaultTargetLoudness   = -16
+	defaultTruePeak         = -1.5
+	defaultLoudnessRange    = 11
+	defaultSilenceThreshold = -50
+	defaultMinSilence       = 1
+)
+
+// Step は後処理の手順。Type によって使う項目が変わり、0 の項目はデフォルトの値を使う。
+type Step struct {
+	Type string `yaml:"type" json:"type"`
+
+	// TargetLoudness, TruePeak, LoudnessRange は loudnorm の I, TP, LRA。
+	TargetLoudness float64 `yaml:"target_lufs,omitempty" json:"target_lufs,omitempty"`
+	TruePeak       float64 `yaml:"true_peak,omitempty" json:"true_peak,omitempty"`
+	LoudnessRange  float64 `yaml:"loudness_range,omitempty" json:"loudness_range,omitempty"`
+
+	// SilenceThreshold より小さい音が MinSilence 秒以上続く部分を無音として扱う。
+	SilenceThreshold float64 `yaml:"silence_threshold_db,omitempty" json:"silence_threshold_db,omitempty"`
+	MinSilence       float64 `yaml:"min_silence_seconds,omitempty" json:"min_silence_seconds,omitempty"`
+}
+
+func (s Step) Validate() error {
+	switch s.Type {
+	case StepLoudnorm:
+		if s.TargetLoudness != 0 && (s.TargetLoudness < -70 || s.TargetLoudness > -5) {
+			return errors.Newf("target_lufs must be between -70 and -5: %v", s.TargetLoudness)
+		}
+		if s.TruePeak != 0 && (s.TruePeak < -9 || s.TruePeak > 0) {
+			return errors.Newf("true_peak must be between -9 and 0: %v", s.TruePeak)
+		}
+		if s.LoudnessRange != 0 && (s.LoudnessRange < 1 || s.LoudnessRange > 50) {
+			return errors.Newf("loudness_range must be between 1 and 50: %v", s.LoudnessRange)
+		}
+	case StepTrimSilence:
+		if s.SilenceThreshold > 0 {
+			return errors.Newf("silence_threshold_db must be negative: %v", s.SilenceThreshold)
+		}
+		if s.MinSilence < 0 {
+			return errors.Newf("min_silence_seconds must be positive: %v", s.MinSilence)
+		}
+	case StepTrimToProgram:
+	default:
+		return errors.Newf("unsupported post process step: %s", s.Type)
+	}
+	return nil
+}
+
+func (s Step) loudnormFilter() string {
+	return fmt.Sprintf(
+		"loudnorm=I=%s:TP=%s:LRA=%s",
+		formatFloat(orDefault(s.TargetLoudness, defaultTargetLoudness)),
+		formatFloat(orDefault(s.TruePeak, defaultTruePeak)),
+		formatFloat(orDefault(s.LoudnessRange, defaultLoudnessRange)),
+	)
+}
+
+func isLoudnormFilter(filter string) bool {
+	return strings.HasPrefix(filter, StepLoudnorm+"=")
+}
+
+func (s Step) silencedetectFilter() string {
+	return fmt.Sprintf(
+		"silencedetect=noise=%sdB:d=%s",
+		formatFloat(orDefault(s.SilenceThreshold, defaultSilenceThreshold)),
+		formatFloat(orDefault(s.MinSilence, defaultMinSilence)),
+	)
+}
+
+// Pipeline は録音したファイルを保存する前に順に適用する後処理。
+// NOTE: 切り詰める手順は他のフィルタの前にまとめて適用する
+type Pipeline []Step
+
+func (p Pipeline) Validate() error {
+	for i, s := range p {
+		if err := s.Validate(); err != nil {
+			return errors.Wrapf(err, "post_process[%d]", i)
+		}
+	}
+	return nil
+}
+
+// Has は stepType の手順が含まれているかを返す。
+func (p Pipeline) Has(stepType string) bool {
+	return slices.ContainsFunc(p, func(s Step) bool { return s.Type == stepType })
+}
+
+// Boundary は録音したファイルの中で番組が始まる位置と番組の長さ。Duration が 0 の場合は分からないものとして扱う。
+type Boundary struct {
+	Offset   time.Duration
+	Duration time.Duration
+}
+
+// Filters は input に後処理を適用する ffmpeg のフィルタと、input の中で残す範囲を返す。
+// 切り詰めない場合の範囲は Boundary{} になる。
+// 無音を取り除く場合は、無音の位置を調べるために ffmpeg を実行する。
+func (p Pipeline) Filters(ctx context.Context, logger zerolog.Logger, input string, b Boundary) ([]string, Boundary, error) {
+	if len(p) == 0 {
+		return nil, Boundary{}, nil
+	}
+
+	var (
+		start, end time.Duration
+		trimmed    bool
+		filters    []string
+	)
+	for _, s := range p {
+		switch s.Type {
+		case StepTrimToProgram, StepTrimSilence:
+			if !trimmed {
+				total, err := ProbeDuration(ctx, input)
+				if err != nil {
+					return nil, Boundary{}, errors.Wrap(err, "failed to probe duration for trimming")
+				}
+				end = total
+				trimmed = true
+			}
+			if s.Type == StepTrimToProgram {
+				start, end = intersect(start, end, b.Offset, b.Offset+b.Duration, b.Duration > 0)
+				continue
+			}
+			out, err := detectSilence(ctx, logger, input, start, end, s)
+			if err != nil {
+				return nil, Boundary{}, err
+			}
+			lead, trail := silenceBounds(parseSilences(out), end-start)
+			start, end = start+lead, start+trail
+		case StepLoudnorm:
+			filters = append(filters, s.loudnormFilter())
+		}
+	}
+	if trimmed {
+		if end <= start {
+			return nil, Boundary{}, errors.Newf("nothing left after trimming: start=%s, end=%s", start, end)
+		}
+		filters = append([]string{
+			fmt.Sprintf("atrim=start=%s:end=%s", formatSeconds(start), formatSeconds(end)),
+			"asetpts=PTS-STARTPTS",
+		}, filters...)
+		return filters, Boundary{Offset: start, Duration: end - start}, nil
+	}
+	return filters, Boundary{}, nil
+}
+
+func intersect(start, end, otherStart, otherEnd time.Duration, ok bool) (time.Duration, time.Duration) {
+	if !ok {
+		return start, end
+	}
+	return max(start, otherStart), min(end, otherEnd)
+}
+
+func detectSilence(ctx context.Context, logger zerolog.Logger, input string, start, end time.Duration, s Step) (string, error) {
+	f, err := newFfmpeg(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create ffmpeg command")
+	}
+	f.setArgs(
+		"-nostdin",
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(end-start),
+	)
+	f.setInput(input)
+	f.setArgs(
+		"-vn",
+		"-af", s.silencedetectFilter(),
+		"-f", "null",
+	)
+
+	logger.Debug().
+		Str("label", "ffmpeg").
+		Str("command", f.String()).
+		Msg("detect silence by ffmpeg")
+
+	b, err := f.runWithOutput("-")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to run ffmpeg: %s", string(b))
+	}
+	return string(b), nil
+}
+
+// silence は silencedetect が見つけた無音の範囲。ファイルの最後まで無音の場合は end が負になる。
+type silence struct {
+	start, end time.Duration
+}
+
+// parseSilences は silencedetect の出力から無音の範囲を取り出す。
+func parseSilences(out string) []silence {
+	var silences []silence
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if _, v, ok := strings.Cut(line, "silence_start: "); ok {
+			if d, ok := parseSeconds(v); ok {
+				silences = append(silences, silence{start: d, end: -1})
+			}
+			continue
+		}
+		if _, v, ok := strings.Cut(line, "silence_end: "); ok && len(silences) > 0 {
+			v, _, _ = strings.Cut(v, " ")
+			if d, ok := parseSeconds(v); ok {
+				silences[len(silences)-1].end = d
+			}
+		}
+	}
+	return silences
+}
+
+// silenceBounds は長さ length のファイルから先頭と末尾の無音を除いた範囲を返す。
+func silenceBounds(silences []silence, length time.Duration) (time.Duration, time.Duration) {
+	// NOTE: フレームの境界の分だけずれるので、少しの差は先頭・末尾とみなす
+	const tolerance = 100 * time.Millisecond
+
+	start, end := time.Duration(0), length
+	if len(silences) == 0 {
+		return start, end
+	}
+	if first := silences[0]; first.start <= tolerance && first.end >= 0 {
+		start = first.end
+	}
+	if last := silences[len(silences)-1]; last.end < 0 || last.end >= length-tolerance {
+		if last.start > start {
+			end = last.start
+		}
+	}
+	return start, end
+}
+
+func parseSeconds(s string) (time.Duration, bool) {
+	sec, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(sec * float64(time.Second)), true
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func orDefault(v, defaultValue float64) float64 {
+	if v == 0 {
+		return defaultValue
+	}
+	return v
+}
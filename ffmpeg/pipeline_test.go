@@ -0,0 +1,87 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSilenceBounds(t *testing.T) {
+	t.Parallel()
+	out := `[silencedetect @ 0x7f8] silence_start: 0
+[silencedetect @ 0x7f8] silence_end: 2.5 | silence_duration: 2.5
+[silencedetect @ 0x7f8] silence_start: 600.25
+[silencedetect @ 0x7f8] silence_end: 603 | silence_duration: 2.75
+size=N/A time=01:00:00.00 bitrate=N/A speed= 900x
+[silencedetect @ 0x7f8] silence_start: 3595.5
+`
+	silences := parseSilences(out)
+	want := []silence{
+		{start: 0, end: 2500 * time.Millisecond},
+		{start: 600250 * time.Millisecond, end: 603 * time.Second},
+		{start: 3595500 * time.Millisecond, end: -1},
+	}
+	if !reflect.DeepEqual(silences, want) {
+		t.Fatalf("parseSilences() = %+v, want %+v", silences, want)
+	}
+
+	tests := map[string]struct {
+		silences  []silence
+		wantStart time.Duration
+		wantEnd   time.Duration
+	}{
+		"leading and trailing": {
+			silences:  silences,
+			wantStart: 2500 * time.Millisecond,
+			wantEnd:   3595500 * time.Millisecond,
+		},
+		"only in the middle": {
+			silences:  silences[1:2],
+			wantStart: 0,
+			wantEnd:   time.Hour,
+		},
+		"trailing silence reported until the end": {
+			silences:  []silence{{start: 3590 * time.Second, end: time.Hour}},
+			wantStart: 0,
+			wantEnd:   3590 * time.Second,
+		},
+		"no silence": {
+			wantStart: 0,
+			wantEnd:   time.Hour,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			start, end := silenceBounds(tt.silences, time.Hour)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("silenceBounds() = (%s, %s), want (%s, %s)", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestPipeline_Validate(t *testing.T) {
+	t.Parallel()
+	valid := Pipeline{
+		{Type: StepTrimToProgram},
+		{Type: StepTrimSilence, SilenceThreshold: -60, MinSilence: 2},
+		{Type: StepLoudnorm, TargetLoudness: -19},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if got, want := valid[2].loudnormFilter(), "loudnorm=I=-19:TP=-1.5:LRA=11"; got != want {
+		t.Errorf("loudnormFilter() = %s, want %s", got, want)
+	}
+	for _, invalid := range []Pipeline{
+		{{Type: "reverb"}},
+		{{Type: StepLoudnorm, TargetLoudness: -80}},
+		{{Type: StepTrimSilence, SilenceThreshold: 10}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Validate() should reject %+v", invalid)
+		}
+	}
+}
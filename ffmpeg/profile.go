@@ -0,0 +1,188 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/timeutil"
+)
+
+const (
+	CodecCopy = "copy"
+	CodecAAC  = "aac"
+	CodecMP3  = "mp3"
+	CodecOpus = "opus"
+	CodecFLAC = "flac"
+
+	// ContainerADTS は変換前のradikoのチャンクと同じ .aac のファイル。
+	ContainerADTS = "aac"
+	ContainerM4A  = "m4a"
+	ContainerMP3  = "mp3"
+	ContainerOgg  = "opus"
+	ContainerFLAC = "flac"
+)
+
+// Profile は録音したAACのファイルをどの形式で保存するかを表す。
+// Container は保存するファイルの拡張子にもなる。空の項目はコーデックのデフォルトを使う。
+type Profile struct {
+	Codec      string `yaml:"codec" json:"codec"`
+	Container  string `yaml:"container,omitempty" json:"container,omitempty"`
+	Bitrate    string `yaml:"bitrate,omitempty" json:"bitrate,omitempty"`
+	SampleRate int    `yaml:"sample_rate,omitempty" json:"sample_rate,omitempty"`
+	Channels   int    `yaml:"channels,omitempty" json:"channels,omitempty"`
+	// Loudnorm はEBU R128のラウドネスに揃える。
+	Loudnorm bool `yaml:"loudnorm,omitempty" json:"loudnorm,omitempty"`
+}
+
+// defaultSampleRate はradikoの配信のサンプリング周波数。
+const defaultSampleRate = 48000
+
+// BuiltinProfiles は設定しなくても使えるプロファイル。
+// NOTE: "aac" はシークできるように、再エンコードせずに m4a に入れ直す
+var BuiltinProfiles = map[string]Profile{
+	"aac":  {Codec: CodecCopy, Container: ContainerM4A},
+	"m4a":  {Codec: CodecCopy, Container: ContainerM4A},
+	"mp3":  {Codec: CodecMP3, Channels: 2},
+	"opus": {Codec: CodecOpus, Bitrate: "64k"},
+	"flac": {Codec: CodecFLAC},
+}
+
+type codecSpec struct {
+	encoder          string
+	defaultContainer string
+	containers       []string
+}
+
+var codecSpecs = map[string]codecSpec{
+	CodecCopy: {encoder: "copy", defaultContainer: ContainerM4A, containers: []string{ContainerADTS, ContainerM4A}},
+	CodecAAC:  {encoder: "aac", defaultContainer: ContainerM4A, containers: []string{ContainerADTS, ContainerM4A}},
+	CodecMP3:  {encoder: "libmp3lame", defaultContainer: ContainerMP3, containers: []string{ContainerMP3}},
+	CodecOpus: {encoder: "libopus", defaultContainer: ContainerOgg, containers: []string{ContainerOgg}},
+	CodecFLAC: {encoder: "flac", defaultContainer: ContainerFLAC, containers: []string{ContainerFLAC}},
+}
+
+var containerFormats = map[string]string{
+	ContainerADTS: "adts",
+	ContainerM4A:  "ipod",
+	ContainerMP3:  "mp3",
+	ContainerOgg:  "ogg",
+	ContainerFLAC: "flac",
+}
+
+// Ext は保存するファイルの拡張子を "." 無しで返す。
+func (p Profile) Ext() string {
+	if p.Container != "" {
+		return p.Container
+	}
+	return codecSpecs[p.Codec].defaultContainer
+}
+
+// Validate はコーデックとコンテナの組み合わせを確かめる。
+func (p Profile) Validate() error {
+	spec, ok := codecSpecs[p.Codec]
+	if !ok {
+		return errors.Newf("unsupported codec: %s", p.Codec)
+	}
+	if !slices.Contains(spec.containers, p.Ext()) {
+		return errors.Newf("codec %s cannot be stored in %s", p.Codec, p.Ext())
+	}
+	if p.Codec == CodecCopy && (p.Bitrate != "" || p.SampleRate != 0 || p.Channels != 0 || p.Loudnorm) {
+		return errors.New("bitrate, sample_rate, channels and loudnorm require re-encoding, so codec must not be copy")
+	}
+	if p.SampleRate < 0 || p.Channels < 0 {
+		return errors.New("sample_rate and channels must be positive")
+	}
+	return nil
+}
+
+func (p Profile) args(filters ...string) []string {
+	var args []string
+	if p.Loudnorm {
+		filters = append(filters, Step{Type: StepLoudnorm}.loudnormFilter())
+	}
+	// NOTE: loudnorm は192kHzで出力するので、サンプリング周波数を指定しない場合は元のradikoと同じに戻す
+	if p.SampleRate == 0 && slices.ContainsFunc(filters, isLoudnormFilter) {
+		filters = append(filters, "aresample="+strconv.Itoa(defaultSampleRate))
+	}
+	encoder := codecSpecs[p.Codec].encoder
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+		// NOTE: フィルタを通すと再エンコードが必要になるので、コピーの場合は元と同じAACにする
+		if p.Codec == CodecCopy {
+			encoder = codecSpecs[CodecAAC].encoder
+		}
+	}
+	args = append(args, "-c:a", encoder)
+	switch {
+	case p.Bitrate != "":
+		args = append(args, "-b:a", p.Bitrate)
+	case p.Codec == CodecMP3:
+		// NOTE: ビットレートを指定しない場合はこれまで通りVBRの高音質にする
+		args = append(args, "-q:a", "2")
+	}
+	if p.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(p.SampleRate))
+	}
+	if p.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(p.Channels))
+	}
+	if p.Ext() == ContainerM4A {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, "-f", containerFormats[p.Ext()])
+}
+
+// Transcode は input に filters を適用して profile の形式に変換し、tags を埋め込んで output に書き出す。
+// コンテナに書けないタグは無視する。
+func Transcode(ctx context.Context, logger zerolog.Logger, input, output string, profile Profile, tags Tags, filters ...string) error {
+	if err := profile.Validate(); err != nil {
+		return errors.Wrap(err, "invalid profile")
+	}
+	f, err := newFfmpeg(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create ffmpeg command")
+	}
+
+	f.setArgs("-nostdin")
+	f.setInput(input)
+	if _, coverArt := supportsTags(profile.Ext()); !coverArt {
+		tags.CoverArt = ""
+	}
+	inputs := 1
+	if tags.CoverArt != "" {
+		f.setInput(tags.CoverArt)
+		inputs++
+	}
+	if len(tags.Chapters) > 0 && supportsChapters(profile.Ext()) {
+		chaptersFile := output + ".ffmetadata"
+		if err := writeFFMetadata(chaptersFile, tags.Chapters); err != nil {
+			return err
+		}
+		defer os.Remove(chaptersFile)
+		f.setArgs("-f", "ffmetadata")
+		f.setInput(chaptersFile)
+		f.setArgs("-map_chapters", strconv.Itoa(inputs))
+	}
+	if tags.CoverArt == "" {
+		// NOTE: 画像を埋め込む場合は tags で映像のストリームを指定する
+		f.setArgs("-vn")
+	}
+	f.setArgs(profile.args(filters...)...)
+	f.setArgs(tags.args(profile.Ext(), timeutil.JST())...)
+	f.setArgs("-y") // overwrite the output file without asking
+
+	logger.Debug().
+		Str("label", "ffmpeg").
+		Str("command", f.String()).
+		Msg("transcode by ffmpeg")
+
+	if b, err := f.runWithOutput(output); err != nil {
+		return errors.Wrapf(err, "failed to run ffmpeg: %s", string(b))
+	}
+	return nil
+}
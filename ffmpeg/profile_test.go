@@ -0,0 +1,68 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfile_args(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		profile Profile
+		want    []string
+		wantErr bool
+	}{
+		"remux to m4a": {
+			profile: BuiltinProfiles["aac"],
+			want:    []string{"-c:a", "copy", "-movflags", "+faststart", "-f", "ipod"},
+		},
+		"mp3 vbr": {
+			profile: BuiltinProfiles["mp3"],
+			want:    []string{"-c:a", "libmp3lame", "-q:a", "2", "-ac", "2", "-f", "mp3"},
+		},
+		"loudnorm without sample rate": {
+			profile: Profile{Codec: CodecFLAC, Loudnorm: true},
+			want: []string{
+				"-af", "loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000", "-c:a", "flac", "-f", "flac",
+			},
+		},
+		"opus with options": {
+			profile: Profile{Codec: CodecOpus, Bitrate: "48k", SampleRate: 48000, Channels: 1, Loudnorm: true},
+			want: []string{
+				"-af", "loudnorm=I=-16:TP=-1.5:LRA=11", "-c:a", "libopus", "-b:a", "48k",
+				"-ar", "48000", "-ac", "1", "-f", "ogg",
+			},
+		},
+		"adts": {
+			profile: Profile{Codec: CodecAAC, Container: ContainerADTS, Bitrate: "96k"},
+			want:    []string{"-c:a", "aac", "-b:a", "96k", "-f", "adts"},
+		},
+		"unknown codec": {
+			profile: Profile{Codec: "wav"},
+			wantErr: true,
+		},
+		"unsupported container": {
+			profile: Profile{Codec: CodecMP3, Container: ContainerM4A},
+			wantErr: true,
+		},
+		"copy with bitrate": {
+			profile: Profile{Codec: CodecCopy, Bitrate: "96k"},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if err := tt.profile.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := tt.profile.args(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// tagDateLayout はタグに書く日時の形式。ID3v2.4のTDRCはタイムゾーンを持てないので日本時間で書く。
+const tagDateLayout = "2006-01-02T15:04:05"
+
+// Tags はファイルに埋め込むタグ。mp3 はID3v2、m4a はMP4のatomとして書かれる。
+type Tags struct {
+	Title       string
+	Album       string
+	Artist      string
+	Description string
+	Date        time.Time
+	// CoverArt は埋め込む画像ファイルのパス。空の場合は埋め込まない。
+	CoverArt string
+	// Chapters はファイルに書くチャプター。mp3 と m4a にだけ書かれる。
+	Chapters []Chapter
+}
+
+func (t Tags) IsZero() bool {
+	return t.Title == "" &&
+		t.Album == "" &&
+		t.Artist == "" &&
+		t.Description == "" &&
+		t.Date.IsZero() &&
+		t.CoverArt == "" &&
+		len(t.Chapters) == 0
+}
+
+// supportsTags はコンテナにタグを書けるかを返す。
+// NOTE: ADTSにはタグを書く場所が無く、Oggは ffmpeg で画像を埋め込めない
+func supportsTags(container string) (text bool, coverArt bool) {
+	switch container {
+	case ContainerM4A, ContainerMP3, ContainerFLAC:
+		return true, true
+	case ContainerOgg:
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// args は画像を2番目の入力として、タグを書く ffmpeg の出力オプションを返す。
+func (t Tags) args(container string, loc *time.Location) []string {
+	text, coverArt := supportsTags(container)
+	if !text || t.IsZero() {
+		return nil
+	}
+	var args []string
+	if t.CoverArt != "" && coverArt {
+		args = append(args,
+			"-map", "0:a",
+			"-map", "1:v",
+			"-c:v", "copy",
+			"-disposition:v:0", "attached_pic",
+		)
+	}
+	metadata := []struct {
+		key, value string
+	}{
+		{"title", t.Title},
+		{"album", t.Album},
+		{"artist", t.Artist},
+		// NOTE: ID3v2 は comment を、MP4 は description を読むアプリが多いので両方に書く
+		{"comment", t.Description},
+		{"description", t.Description},
+	}
+	if !t.Date.IsZero() {
+		metadata = append(metadata, struct{ key, value string }{"date", t.Date.In(loc).Format(tagDateLayout)})
+	}
+	for _, m := range metadata {
+		if m.value == "" {
+			continue
+		}
+		args = append(args, "-metadata", m.key+"="+m.value)
+	}
+	return args
+}
+
+// ProbeTags は ffprobe でファイルに埋め込まれたタグとチャプターと長さを読む。
+// 日時は loc のタイムゾーンとして読む。
+func ProbeTags(ctx context.Context, input string, loc *time.Location) (Tags, time.Duration, error) {
+	cmdPath, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return Tags{}, 0, errors.Wrap(err, "failed to find ffprobe")
+	}
+
+	out, err := exec.CommandContext(
+		ctx,
+		cmdPath,
+		"-v", "error",
+		"-show_entries", "format=duration:format_tags",
+		"-show_chapters",
+		"-of", "json",
+		input,
+	).Output()
+	if err != nil {
+		return Tags{}, 0, errors.Wrap(err, "failed to run ffprobe")
+	}
+	return parseProbedTags(out, loc)
+}
+
+func parseProbedTags(out []byte, loc *time.Location) (Tags, time.Duration, error) {
+	var probed struct {
+		Format struct {
+			Duration string            `json:"duration"`
+			Tags     map[string]string `json:"tags"`
+		} `json:"format"`
+		Chapters []struct {
+			StartTime string            `json:"start_time"`
+			EndTime   string            `json:"end_time"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal(out, &probed); err != nil {
+		return Tags{}, 0, errors.Wrap(err, "failed to decode ffprobe json")
+	}
+
+	// NOTE: コンテナによってキーの大文字小文字が違うので揃える
+	tags := make(map[string]string, len(probed.Format.Tags))
+	for k, v := range probed.Format.Tags {
+		tags[strings.ToLower(k)] = v
+	}
+	t := Tags{
+		Title:       tags["title"],
+		Album:       tags["album"],
+		Artist:      tags["artist"],
+		Description: tags["description"],
+	}
+	if t.Description == "" {
+		t.Description = tags["comment"]
+	}
+	if date, err := time.ParseInLocation(tagDateLayout, tags["date"], loc); err == nil {
+		t.Date = date
+	}
+
+	for _, c := range probed.Chapters {
+		start, ok := parseSeconds(c.StartTime)
+		if !ok {
+			continue
+		}
+		end, _ := parseSeconds(c.EndTime)
+		t.Chapters = append(t.Chapters, Chapter{Title: c.Tags["title"], Start: start, End: end})
+	}
+
+	var duration time.Duration
+	if sec, err := strconv.ParseFloat(probed.Format.Duration, 64); err == nil {
+		duration = time.Duration(sec * float64(time.Second))
+	}
+	return t, duration, nil
+}
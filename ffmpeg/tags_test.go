@@ -0,0 +1,72 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/upamune/radicaster/timeutil"
+)
+
+func TestTags(t *testing.T) {
+	t.Parallel()
+	tags := Tags{
+		Title:       "オールナイトニッポン",
+		Album:       "ANN",
+		Artist:      "LFR",
+		Description: "深夜のラジオ番組",
+		Date:        time.Date(2023, 9, 27, 1, 0, 0, 0, timeutil.JST()),
+		CoverArt:    "cover",
+	}
+	metadata := []string{
+		"-metadata", "title=オールナイトニッポン",
+		"-metadata", "album=ANN",
+		"-metadata", "artist=LFR",
+		"-metadata", "comment=深夜のラジオ番組",
+		"-metadata", "description=深夜のラジオ番組",
+		"-metadata", "date=2023-09-27T01:00:00",
+	}
+	tests := map[string]struct {
+		container string
+		want      []string
+	}{
+		"m4a": {
+			container: ContainerM4A,
+			want:      append([]string{"-map", "0:a", "-map", "1:v", "-c:v", "copy", "-disposition:v:0", "attached_pic"}, metadata...),
+		},
+		"opus without cover art": {
+			container: ContainerOgg,
+			want:      metadata,
+		},
+		"adts": {
+			container: ContainerADTS,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := tags.args(tt.container, timeutil.JST()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	probed := []byte(`{"format": {"duration": "3600.5", "tags": {"TITLE": "オールナイトニッポン", "album": "ANN", "artist": "LFR", "comment": "深夜のラジオ番組", "date": "2023-09-27T01:00:00"}}, "chapters": [{"start_time": "0.000000", "end_time": "90.000000", "tags": {"title": "オープニング"}}]}`)
+	got, duration, err := parseProbedTags(probed, timeutil.JST())
+	if err != nil {
+		t.Fatalf("parseProbedTags() error = %v", err)
+	}
+	want := tags
+	want.CoverArt = ""
+	if got.Title != want.Title || got.Album != want.Album || got.Artist != want.Artist ||
+		got.Description != want.Description || !got.Date.Equal(want.Date) {
+		t.Errorf("parseProbedTags() = %+v, want %+v", got, want)
+	}
+	if want := []Chapter{{Title: "オープニング", End: 90 * time.Second}}; !reflect.DeepEqual(got.Chapters, want) {
+		t.Errorf("parseProbedTags() chapters = %+v, want %+v", got.Chapters, want)
+	}
+	if want := 3600500 * time.Millisecond; duration != want {
+		t.Errorf("parseProbedTags() duration = %s, want %s", duration, want)
+	}
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cockroachdb/errors"
+	"github.com/labstack/echo/v4"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/podcast"
+	"github.com/upamune/radicaster/storage"
+)
+
+// chaptersVersion は返すチャプターのJSONの形式のバージョン。
+// https://github.com/Podcastindex-org/podcast-namespace/blob/main/chapters/jsonChapters.md
+const chaptersVersion = "1.2.0"
+
+type chaptersResponse struct {
+	Version  string            `json:"version"`
+	Chapters []chapterResponse `json:"chapters"`
+}
+
+type chapterResponse struct {
+	StartTime float64 `json:"startTime"`
+	EndTime   float64 `json:"endTime,omitempty"`
+	Title     string  `json:"title"`
+}
+
+func newChaptersResponse(chapters []metadata.Chapter) chaptersResponse {
+	res := chaptersResponse{
+		Version:  chaptersVersion,
+		Chapters: make([]chapterResponse, 0, len(chapters)),
+	}
+	for _, c := range chapters {
+		res.Chapters = append(res.Chapters, chapterResponse{
+			StartTime: c.StartSeconds,
+			EndTime:   c.EndSeconds,
+			Title:     c.Title,
+		})
+	}
+	return res
+}
+
+// serveChapters は key の音声ファイルのチャプターを Podcasting 2.0 のJSONで返す。
+func serveChapters(c echo.Context, store storage.Storage, key string) error {
+	md, err := metadata.Read(c.Request().Context(), store, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return echo.ErrNotFound
+		}
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if len(md.Chapters) == 0 {
+		return echo.ErrNotFound
+	}
+	b, err := json.Marshal(newChaptersResponse(md.Chapters))
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return c.Blob(http.StatusOK, podcast.ChaptersMIMEType, b)
+}
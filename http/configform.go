@@ -0,0 +1,160 @@
+package http
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+// programForm は番組を編集するフォームの値を表す。
+type programForm struct {
+	Title     string   `form:"title"`
+	StationID string   `form:"station"`
+	AreaID    string   `form:"area"`
+	Weekdays  []string `form:"weekdays"`
+	Start     string   `form:"start"`
+	Cron      string   `form:"cron"`
+	Encoding  string   `form:"encoding"`
+	Mode      string   `form:"mode"`
+	ImageURL  string   `form:"image_url"`
+	Path      string   `form:"path"`
+}
+
+// formErrors はフォームの項目ごとのエラーを表す。全体のエラーは空文字のキーに入れる。
+type formErrors map[string]string
+
+func newProgramForm(p config.Program) programForm {
+	weekdays := make([]string, 0, len(p.Weekdays))
+	for _, wd := range p.Weekdays {
+		weekdays = append(weekdays, strconv.Itoa(int(wd)))
+	}
+	return programForm{
+		Title:     p.Title,
+		StationID: p.StationID,
+		AreaID:    p.AreaID,
+		Weekdays:  weekdays,
+		Start:     p.Start,
+		Cron:      p.Cron,
+		Encoding:  p.Encoding,
+		Mode:      p.Mode,
+		ImageURL:  p.ImageURL,
+		Path:      p.Path,
+	}
+}
+
+func (f programForm) validate(stations radiko.Stations, encodings []string) formErrors {
+	errs := make(formErrors)
+	if strings.TrimSpace(f.Title) == "" {
+		errs["title"] = "タイトルを入力してください"
+	}
+	if f.StationID == "" {
+		errs["station"] = "局を選んでください"
+	} else if len(stations) > 0 && !slices.ContainsFunc(stations, func(s radiko.Station) bool {
+		return strings.EqualFold(s.ID, f.StationID)
+	}) {
+		errs["station"] = "局が見つかりません: " + f.StationID
+	}
+	if len(f.Weekdays) == 0 {
+		errs["weekdays"] = "曜日を1つ以上選んでください"
+	}
+	for _, wd := range f.Weekdays {
+		if n, err := strconv.Atoi(wd); err != nil || n < int(time.Sunday) || n > int(time.Saturday) {
+			errs["weekdays"] = "曜日が不正です: " + wd
+		}
+	}
+	if _, _, err := timeutil.NormalizeBroadcastTime(0, f.Start); err != nil {
+		errs["start"] = "開始時刻は HHMM 形式(24:00から28:59も可)で入力してください"
+	}
+	// NOTE: cron を省略した番組は番組表の終了時刻から録音する
+	if f.Cron != "" {
+		if _, err := cron.ParseStandard(f.Cron); err != nil {
+			errs["cron"] = "cronの書式が不正です: " + err.Error()
+		}
+	}
+	if !slices.Contains(encodings, f.Encoding) {
+		errs["encoding"] = strings.Join(encodings, ", ") + " のいずれかを選んでください"
+	}
+	if f.Mode != "" && f.Mode != config.RecordModeTimeshift && f.Mode != config.RecordModeLive {
+		errs["mode"] = "timeshift か live を選んでください"
+	}
+	if strings.EqualFold(strings.Trim(f.Path, "/"), "all") {
+		errs["path"] = "pathに `all` は使用できません"
+	}
+	return errs
+}
+
+// apply はフォームの値で番組を更新する。フォームにない保持ポリシーなどはそのままにする。
+func (f programForm) apply(p config.Program) config.Program {
+	p.Title = strings.TrimSpace(f.Title)
+	p.StationID = f.StationID
+	p.AreaID = f.AreaID
+	p.Weekdays = p.Weekdays[:0:0]
+	for _, wd := range f.Weekdays {
+		n, _ := strconv.Atoi(wd)
+		p.Weekdays = append(p.Weekdays, timeutil.Weekday(n))
+	}
+	p.Start = f.Start
+	p.Cron = f.Cron
+	p.Encoding = f.Encoding
+	p.Mode = f.Mode
+	p.ImageURL = f.ImageURL
+	p.Path = f.Path
+	return p
+}
+
+type weekdayOption struct {
+	Value   string
+	Label   string
+	Checked bool
+}
+
+var weekdayLabels = [...]string{"日", "月", "火", "水", "木", "金", "土"}
+
+func (f programForm) WeekdayOptions() []weekdayOption {
+	options := make([]weekdayOption, 0, len(weekdayLabels))
+	for i, label := range weekdayLabels {
+		v := strconv.Itoa(i)
+		options = append(options, weekdayOption{
+			Value:   v,
+			Label:   label,
+			Checked: slices.Contains(f.Weekdays, v),
+		})
+	}
+	return options
+}
+
+// withProgram は index 番目の番組を差し替えた設定を返す。index が番組の数と同じなら追加する。
+// 今の設定のスライスを書き換えないようにコピーする。
+func withProgram(c config.Config, index int, p config.Program) config.Config {
+	programs := slices.Clone(c.Programs)
+	if index == len(programs) {
+		programs = append(programs, p)
+	} else {
+		programs[index] = p
+	}
+	c.Programs = programs
+	return c
+}
+
+func withoutProgram(c config.Config, index int) config.Config {
+	c.Programs = slices.Delete(slices.Clone(c.Programs), index, index+1)
+	return c
+}
+
+// withZenrokuStation は全録する局を有効・無効にした設定を返す。
+func withZenrokuStation(c config.Config, stationID string, enabled bool) config.Config {
+	ids := slices.DeleteFunc(slices.Clone(c.Zenroku.EnableStationIDs), func(id string) bool {
+		return strings.EqualFold(id, stationID)
+	})
+	if enabled {
+		ids = append(ids, stationID)
+	}
+	c.Zenroku.EnableStationIDs = ids
+	return c
+}
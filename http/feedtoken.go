@@ -0,0 +1,149 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/upamune/radicaster/feedtoken"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/storage"
+	"github.com/upamune/radicaster/user"
+)
+
+// feedAuth はフィードと音声ファイルを取得できるかを、ユーザーかクエリパラメータのトークンで確かめる。
+type feedAuth struct {
+	tokens *feedtoken.Store
+	users  *user.Store
+}
+
+// require はいずれかのフィードを聴けるリクエストだけを通す。
+func (a feedAuth) require(feedPaths func(c echo.Context) []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			paths := feedPaths(c)
+			// NOTE: Basic認証でログインしているユーザーは、そのユーザーが聴けるフィードだけを通す
+			u, ok := currentUser(c)
+			if !ok {
+				u, ok = a.basicAuthUser(c)
+			}
+			if ok {
+				if canAccessAnyFeed(u, paths) {
+					return next(c)
+				}
+				return c.String(http.StatusForbidden, "")
+			}
+
+			token := c.QueryParam(feedtoken.QueryParam)
+			if token == "" {
+				// NOTE: ユーザーを管理している場合は、ログインかトークンが無ければ通さない
+				if a.users != nil {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, `basic realm="Restricted"`)
+					return c.String(http.StatusUnauthorized, "")
+				}
+				// NOTE: フィードのトークンを使わない設定の場合は今まで通り通す
+				if a.tokens == nil {
+					return next(c)
+				}
+				return c.String(http.StatusNotFound, "")
+			}
+			if a.tokens != nil {
+				for _, feedPath := range paths {
+					if a.tokens.Verify(feedPath, token) {
+						return next(c)
+					}
+				}
+			}
+			if a.users != nil {
+				if u, ok := a.users.ByToken(token); ok && canAccessAnyFeed(u, paths) {
+					return next(c)
+				}
+			}
+			return c.String(http.StatusNotFound, "")
+		}
+	}
+}
+
+// basicAuthUser はBasic認証をスキップしたパスで、リクエストに付いている認証情報のユーザーを返す。
+func (a feedAuth) basicAuthUser(c echo.Context) (user.User, bool) {
+	if a.users == nil {
+		return user.User{}, false
+	}
+	username, password, ok := c.Request().BasicAuth()
+	if !ok {
+		return user.User{}, false
+	}
+	return a.users.Authenticate(username, password)
+}
+
+func canAccessAnyFeed(u user.User, feedPaths []string) bool {
+	for _, feedPath := range feedPaths {
+		if u.CanAccessFeed(feedPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// staticFileFeedPath は音声ファイル(またはそのメタデータ)が載るフィードのパスを返す。
+func staticFileFeedPath(ctx context.Context, store storage.Storage, key string) string {
+	md, err := metadata.Read(ctx, store, strings.TrimSuffix(key, ".json"))
+	if err != nil {
+		return ""
+	}
+	return md.FeedPath()
+}
+
+// programPathParam はURLのフィードのパスを、設定と同じく小文字にして返す。
+// NOTE: 大文字を含むURLでも、同じフィードとしてトークンとユーザーの権限を確かめる
+func programPathParam(c echo.Context) string {
+	return strings.ToLower(strings.Trim(c.Param("program_path"), "/"))
+}
+
+// feedURL はトークンを付けたフィードのURLのパスを返す。
+func feedURL(tokens *feedtoken.Store, p string) string {
+	p = strings.ToLower(strings.Trim(p, "/"))
+	if tokens == nil {
+		return feedPathURL(p)
+	}
+	token, err := tokens.Token(p)
+	if err != nil {
+		return feedPathURL(p)
+	}
+	return withTokenQuery(feedPathURL(p), token)
+}
+
+func feedPathURL(p string) string {
+	if p == "" {
+		return "/rss.xml"
+	}
+	return "/" + p + "/rss.xml"
+}
+
+func withTokenQuery(u, token string) string {
+	if token == "" {
+		return u
+	}
+	return u + "?" + url.Values{feedtoken.QueryParam: {token}}.Encode()
+}
+
+type feedTokenResponse struct {
+	FeedPath string `json:"feed_path"`
+	Token    string `json:"token"`
+	FeedURL  string `json:"feed_url"`
+}
+
+func listFeedTokens(tokens *feedtoken.Store) []feedTokenResponse {
+	list := tokens.List()
+	res := make([]feedTokenResponse, 0, len(list))
+	for _, t := range list {
+		res = append(res, feedTokenResponse{
+			FeedPath: t.FeedPath,
+			Token:    t.Token,
+			FeedURL:  feedURL(tokens, t.FeedPath),
+		})
+	}
+	return res
+}
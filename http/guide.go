@@ -0,0 +1,148 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/record"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+type guideStation struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// guideEntry は番組表の1つの放送を表す。
+type guideEntry struct {
+	StationID   string    `json:"station_id"`
+	Title       string    `json:"title"`
+	Performer   string    `json:"performer,omitempty"`
+	Description string    `json:"description,omitempty"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	// BroadcastStart は番組表での開始時刻(例: 25:00)。
+	BroadcastStart string `json:"broadcast_start"`
+	BroadcastEnd   string `json:"broadcast_end"`
+	// Recordable はタイムフリーで今すぐ録音できるかどうか。
+	Recordable bool `json:"recordable"`
+	// Subscribed は同じ曜日と時刻の番組が既に設定にあるかどうか。
+	Subscribed bool `json:"subscribed"`
+}
+
+// guideDay は番組表の1日(5時から翌日の5時まで)を表す。
+type guideDay struct {
+	Date     string       `json:"date"`
+	Weekday  string       `json:"weekday"`
+	Programs []guideEntry `json:"programs"`
+}
+
+type guideResponse struct {
+	AreaID    string         `json:"area_id,omitempty"`
+	StationID string         `json:"station_id"`
+	Stations  []guideStation `json:"stations"`
+	Days      []guideDay     `json:"days"`
+}
+
+func newGuideStations(stations radiko.Stations) []guideStation {
+	res := make([]guideStation, 0, len(stations))
+	for _, s := range stations {
+		res = append(res, guideStation{ID: s.ID, Name: s.Name})
+	}
+	return res
+}
+
+// newGuideDays は週間番組表を番組表の日ごとにまとめる。
+func newGuideDays(stationID string, stations radiko.Stations, programs []config.Program, now time.Time) []guideDay {
+	var days []guideDay
+	for _, station := range stations {
+		if !strings.EqualFold(station.ID, stationID) {
+			continue
+		}
+		for _, prog := range station.Progs.Progs {
+			prog := prog
+			from, to, err := record.ParseProgTime(&prog)
+			if err != nil {
+				continue
+			}
+			weekday, start := timeutil.BroadcastClock(from)
+			date := from.Add(-timeutil.BroadcastDayStartHour * time.Hour).Format(time.DateOnly)
+			if len(days) == 0 || days[len(days)-1].Date != date {
+				days = append(days, guideDay{Date: date, Weekday: weekday.String()})
+			}
+			day := &days[len(days)-1]
+			day.Programs = append(day.Programs, guideEntry{
+				StationID:      station.ID,
+				Title:          prog.Title,
+				Performer:      prog.Pfm,
+				Description:    prog.Desc,
+				From:           from,
+				To:             to,
+				BroadcastStart: start,
+				BroadcastEnd:   broadcastEnd(start, from, to),
+				Recordable:     record.CanRecordOnDemand(from, to, now),
+				Subscribed:     isSubscribed(programs, station.ID, from),
+			})
+		}
+	}
+	return days
+}
+
+// broadcastEnd は開始時刻の日を基準にした終了時刻を返す。
+// 翌日の5時に終わる番組は 29:00 になる。
+func broadcastEnd(start string, from, to time.Time) string {
+	var hour, minute int
+	fmt.Sscanf(start, "%d:%d", &hour, &minute)
+	end := hour*60 + minute + int(to.Sub(from).Minutes())
+	return fmt.Sprintf("%02d:%02d", end/60, end%60)
+}
+
+// isSubscribed は同じ局・曜日・開始時刻の番組が設定にあるかどうかを返す。
+func isSubscribed(programs []config.Program, stationID string, from time.Time) bool {
+	for _, p := range programs {
+		if !strings.EqualFold(p.StationID, stationID) {
+			continue
+		}
+		weekdays, start, err := p.Schedule()
+		if err != nil || start != from.Format("1504") {
+			continue
+		}
+		for _, wd := range weekdays {
+			if time.Weekday(wd) == from.Weekday() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// broadcastRequest は番組表の放送を録音・購読するリクエストを表す。
+type broadcastRequest struct {
+	StationID string `json:"station_id" form:"station_id"`
+	AreaID    string `json:"area_id" form:"area_id"`
+	Title     string `json:"title" form:"title"`
+	From      string `json:"from" form:"from"`
+	To        string `json:"to" form:"to"`
+}
+
+func (req broadcastRequest) times() (time.Time, time.Time, error) {
+	if req.StationID == "" {
+		return time.Time{}, time.Time{}, errors.New("station_id is required")
+	}
+	from, err := time.Parse(time.RFC3339, req.From)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrapf(err, "invalid from: %s", req.From)
+	}
+	to, err := time.Parse(time.RFC3339, req.To)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrapf(err, "invalid to: %s", req.To)
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, errors.Newf("to must be after from: from=%s, to=%s", req.From, req.To)
+	}
+	return from, to, nil
+}
@@ -9,6 +9,7 @@ import (
 	"html/template"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,9 +21,13 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/samber/lo"
 	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/feedtoken"
 	"github.com/upamune/radicaster/podcast"
 	"github.com/upamune/radicaster/radikoutil"
 	"github.com/upamune/radicaster/record"
+	"github.com/upamune/radicaster/storage"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/upamune/radicaster/user"
 	"github.com/yyoshiki41/go-radiko"
 )
 
@@ -34,22 +39,38 @@ func NewHTTPHandler(
 	version, revision string,
 	podcaster *podcast.Podcaster,
 	recorder *record.Recorder,
-	targetDir string,
+	store storage.Storage,
 	basicAuth string,
+	tokens *feedtoken.Store,
+	users *user.Store,
 ) (http.Handler, error) {
 	e := echo.New()
 	e.Use(middleware.Recover())
 	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(noCacheMiddleware)
-	if ss := strings.Split(basicAuth, ":"); len(ss) == 2 {
+	e.Use(sameOriginMiddleware)
+	ss := strings.Split(basicAuth, ":")
+	if users != nil || len(ss) == 2 {
 		e.Use(middleware.BasicAuthWithConfig(middleware.BasicAuthConfig{
 			Skipper: func(c echo.Context) bool {
 				path := c.Request().URL.Path
-				// NOTE: 静的ファイルの配信はベージック認証をスキップする
-				return strings.HasPrefix(path, "/static")
+				// NOTE: 静的ファイルとチャプターの配信はベージック認証をスキップする
+				if strings.HasPrefix(path, "/static") || strings.HasPrefix(path, "/chapters") {
+					return true
+				}
+				// NOTE: トークンを付けたフィードはトークンで認可する
+				return strings.HasSuffix(path, "/rss.xml") &&
+					(tokens != nil || c.QueryParam(feedtoken.QueryParam) != "")
 			},
 			Validator: func(username, password string, c echo.Context) (bool, error) {
+				if users != nil {
+					u, ok := users.Authenticate(username, password)
+					if ok {
+						c.Set(userContextKey, u)
+					}
+					return ok, nil
+				}
 				if subtle.ConstantTimeCompare([]byte(username), []byte(ss[0])) == 1 &&
 					subtle.ConstantTimeCompare([]byte(password), []byte(ss[1])) == 1 {
 					return true, nil
@@ -59,8 +80,13 @@ func NewHTTPHandler(
 			Realm: "Restricted",
 		}))
 	}
+	auth := feedAuth{tokens: tokens, users: users}
+	admin := requireAdmin(users)
 
 	e.GET("/", func(c echo.Context) error {
+		if u, ok := currentUser(c); ok && !u.IsAdmin() {
+			return c.Redirect(http.StatusFound, "/me")
+		}
 		return c.Redirect(http.StatusMovedPermanently, "/config")
 	})
 
@@ -69,15 +95,23 @@ func NewHTTPHandler(
 			return c.String(http.StatusInternalServerError, "")
 		}
 		return c.String(http.StatusOK, "")
-	})
+	}, admin)
+
+	e.POST("/rescan", func(c echo.Context) error {
+		if err := podcaster.Rescan(c.Request().Context()); err != nil {
+			return c.String(http.StatusInternalServerError, "")
+		}
+		return c.String(http.StatusOK, "")
+	}, admin)
 
 	e.GET("/rss.xml", func(c echo.Context) error {
 		return c.Blob(http.StatusOK, "application/xml", []byte(podcaster.GetDefaultFeed()))
-	})
+	}, auth.require(func(c echo.Context) []string {
+		return []string{""}
+	}))
 
 	e.GET("/zenroku/:program_path/rss.xml", func(c echo.Context) error {
-		programPath := c.Param("program_path")
-		p := path.Join("zenroku", programPath)
+		p := path.Join("zenroku", programPathParam(c))
 		feed, ok := podcaster.GetFeed(p)
 		if !ok {
 			return c.String(http.StatusNotFound, "")
@@ -87,11 +121,12 @@ func NewHTTPHandler(
 			"application/xml",
 			[]byte(feed),
 		)
-	})
+	}, auth.require(func(c echo.Context) []string {
+		return []string{path.Join("zenroku", programPathParam(c))}
+	}))
 
 	e.GET("/:program_path/rss.xml", func(c echo.Context) error {
-		programPath := c.Param("program_path")
-		feed, ok := podcaster.GetFeed(programPath)
+		feed, ok := podcaster.GetFeed(programPathParam(c))
 		if !ok {
 			return c.String(http.StatusNotFound, "")
 		}
@@ -100,9 +135,11 @@ func NewHTTPHandler(
 			"application/xml",
 			[]byte(feed),
 		)
-	})
+	}, auth.require(func(c echo.Context) []string {
+		return []string{programPathParam(c)}
+	}))
 
-	t, err := template.New("config.html.tmpl").
+	t, err := template.New("").
 		Funcs(template.FuncMap{
 			"inc": func(i int) int {
 				return i + 1
@@ -119,8 +156,38 @@ func NewHTTPHandler(
 				}
 				return false
 			},
+			"formatTime": func(v interface{}) string {
+				var t time.Time
+				switch v := v.(type) {
+				case time.Time:
+					t = v
+				case *time.Time:
+					if v != nil {
+						t = *v
+					}
+				}
+				if t.IsZero() {
+					return "-"
+				}
+				return t.In(timeutil.JST()).Format(time.DateTime)
+			},
+			"feedURL": func(p string) string {
+				return feedURL(tokens, p)
+			},
+			"formatBytes": func(b int64) string {
+				const unit = 1024
+				if b < unit {
+					return fmt.Sprintf("%d B", b)
+				}
+				div, exp := int64(unit), 0
+				for n := b / unit; n >= unit; n /= unit {
+					div *= unit
+					exp++
+				}
+				return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+			},
 		}).
-		ParseFS(views, "views/config.html.tmpl")
+		ParseFS(views, "views/*.html.tmpl")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse template")
 	}
@@ -128,6 +195,49 @@ func NewHTTPHandler(
 	radikoCache := ttlcache.New[string, radiko.Stations](
 		ttlcache.WithTTL[string, radiko.Stations](24 * time.Hour),
 	)
+	// NOTE: エリアごとに局が違うのでエリアごとにキャッシュする
+	getStations := func(ctx context.Context, areaID string) (radiko.Stations, error) {
+		radikoCacheKey := path.Join("stations", areaID)
+		if radikoCache.Has(radikoCacheKey) {
+			item := radikoCache.Get(radikoCacheKey)
+			logger.Debug().
+				Str("cache_key", radikoCacheKey).
+				Bool("is_expired", item.IsExpired()).
+				Time("expires_at", item.ExpiresAt()).
+				Msg("radiko cache hit")
+			return item.Value(), nil
+		}
+		logger.Debug().
+			Str("cache_key", radikoCacheKey).
+			Msg("radiko cache no-hit")
+		client, err := recorder.NewRadikoClient(ctx, radikoutil.WithAreaID(areaID))
+		if err != nil {
+			return nil, err
+		}
+		stations, err := client.GetStations(ctx, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		radikoCache.Set(radikoCacheKey, stations, 24*time.Hour)
+		return stations, nil
+	}
+	getWeeklyPrograms := func(ctx context.Context, areaID, stationID string) (radiko.Stations, error) {
+		radikoCacheKey := path.Join("weekly", areaID, stationID)
+		if item := radikoCache.Get(radikoCacheKey); item != nil {
+			return item.Value(), nil
+		}
+		client, err := recorder.NewRadikoClient(ctx, radikoutil.WithAreaID(areaID))
+		if err != nil {
+			return nil, err
+		}
+		stations, err := client.GetWeeklyPrograms(ctx, stationID)
+		if err != nil {
+			return nil, err
+		}
+		// NOTE: 番組表は差し替わることがあるので局の一覧よりも短くキャッシュする
+		radikoCache.Set(radikoCacheKey, stations, time.Hour)
+		return stations, nil
+	}
 	e.GET("/config", func(c echo.Context) error {
 		config := recorder.Config()
 
@@ -159,29 +269,9 @@ func NewHTTPHandler(
 		if config.Zenroku.Enable {
 			ctx := context.Background()
 
-			const radikoCacheKey = "stations"
-			var stations radiko.Stations
-			if radikoCache.Has(radikoCacheKey) {
-				item := radikoCache.Get(radikoCacheKey)
-				logger.Debug().
-					Str("cache_key", radikoCacheKey).
-					Bool("is_expired", item.IsExpired()).
-					Time("expires_at", item.ExpiresAt()).
-					Msg("radiko cache hit")
-				stations = item.Value()
-			} else {
-				logger.Debug().
-					Str("cache_key", radikoCacheKey).
-					Msg("radiko cache no-hit")
-				client, err := radikoutil.NewClient(ctx)
-				if err != nil {
-					return c.String(http.StatusInternalServerError, err.Error())
-				}
-				stations, err = client.GetStations(ctx, time.Now())
-				if err != nil {
-					return c.String(http.StatusInternalServerError, err.Error())
-				}
-				radikoCache.Set(radikoCacheKey, stations, 24*time.Hour)
+			stations, err := getStations(ctx, "")
+			if err != nil {
+				return c.String(http.StatusInternalServerError, err.Error())
 			}
 
 			enableStationIDMap := lo.Associate(config.Zenroku.EnableStationIDs, func(stationID string) (string, struct{}) {
@@ -203,10 +293,12 @@ func NewHTTPHandler(
 		}
 
 		var buf bytes.Buffer
-		if err := t.Execute(
+		if err := t.ExecuteTemplate(
 			&buf,
+			"config.html.tmpl",
 			map[string]interface{}{
 				"Programs":        config.Programs,
+				"Rules":           config.Rules,
 				"Zenroku":         config.Zenroku,
 				"ZenrokuStations": zenrokuStations,
 				"Version":         version,
@@ -216,7 +308,265 @@ func NewHTTPHandler(
 			return c.String(http.StatusInternalServerError, err.Error())
 		}
 		return c.HTML(http.StatusOK, buf.String())
-	})
+	}, admin)
+
+	renderProgramForm := func(c echo.Context, status int, action string, form programForm, errs formErrors) error {
+		// NOTE: 局の一覧が取れなくても、局IDを直接入力すれば編集できるようにする
+		stations, err := getStations(c.Request().Context(), "")
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to get stations for program form")
+		}
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(
+			&buf,
+			"program_form.html.tmpl",
+			map[string]interface{}{
+				"Action":    action,
+				"Form":      form,
+				"Errors":    errs,
+				"Stations":  stations,
+				"Encodings": recorder.Config().Encodings(),
+				"Version":   version,
+				"Revision":  revision,
+			},
+		); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.HTML(status, buf.String())
+	}
+
+	// NOTE: 番組の数と同じ index は追加として扱う
+	programIndex := func(c echo.Context) (int, bool) {
+		if c.Param("index") == "" {
+			return len(recorder.Config().Programs), true
+		}
+		i, err := strconv.Atoi(c.Param("index"))
+		if err != nil || i < 0 || i >= len(recorder.Config().Programs) {
+			return 0, false
+		}
+		return i, true
+	}
+
+	saveProgram := func(c echo.Context) error {
+		i, ok := programIndex(c)
+		if !ok {
+			return c.String(http.StatusNotFound, "")
+		}
+		action := c.Request().URL.Path
+
+		var form programForm
+		if err := c.Bind(&form); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		stations, err := getStations(c.Request().Context(), "")
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to get stations for validating program")
+		}
+		if errs := form.validate(stations, recorder.Config().Encodings()); len(errs) > 0 {
+			return renderProgramForm(c, http.StatusUnprocessableEntity, action, form, errs)
+		}
+
+		current := recorder.Config()
+		var base config.Program
+		if i < len(current.Programs) {
+			base = current.Programs[i]
+		}
+		updated := withProgram(current, i, form.apply(base))
+		if err := updated.Validate(); err != nil {
+			return renderProgramForm(c, http.StatusUnprocessableEntity, action, form, formErrors{"": err.Error()})
+		}
+		if _, err := recorder.RefreshConfig(updated); err != nil {
+			return renderProgramForm(c, http.StatusUnprocessableEntity, action, form, formErrors{"": err.Error()})
+		}
+		return c.Redirect(http.StatusSeeOther, "/config")
+	}
+
+	e.GET("/config/programs/new", func(c echo.Context) error {
+		form := newProgramForm(config.Program{Encoding: config.AudioFormatAAC})
+		return renderProgramForm(c, http.StatusOK, "/config/programs", form, nil)
+	}, admin)
+
+	e.GET("/config/programs/:index", func(c echo.Context) error {
+		i, ok := programIndex(c)
+		if !ok {
+			return c.String(http.StatusNotFound, "")
+		}
+		form := newProgramForm(recorder.Config().Programs[i])
+		return renderProgramForm(c, http.StatusOK, c.Request().URL.Path, form, nil)
+	}, admin)
+
+	e.POST("/config/programs", saveProgram, admin)
+	e.POST("/config/programs/:index", saveProgram, admin)
+
+	e.POST("/config/programs/:index/delete", func(c echo.Context) error {
+		i, ok := programIndex(c)
+		if !ok {
+			return c.String(http.StatusNotFound, "")
+		}
+		if _, err := recorder.RefreshConfig(withoutProgram(recorder.Config(), i)); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.Redirect(http.StatusSeeOther, "/config")
+	}, admin)
+
+	e.POST("/config/zenroku/stations/:station_id", func(c echo.Context) error {
+		enabled, err := strconv.ParseBool(c.FormValue("enabled"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, "enabled must be true or false")
+		}
+		updated := withZenrokuStation(recorder.Config(), c.Param("station_id"), enabled)
+		if _, err := recorder.RefreshConfig(updated); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.Redirect(http.StatusSeeOther, "/config")
+	}, admin)
+
+	e.GET("/guide", func(c echo.Context) error {
+		ctx := c.Request().Context()
+		areaID := c.QueryParam("area")
+		stations, err := getStations(ctx, areaID)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		stationID := c.QueryParam("station")
+		if stationID == "" && len(stations) > 0 {
+			stationID = stations[0].ID
+		}
+		res := guideResponse{
+			AreaID:    areaID,
+			StationID: stationID,
+			Stations:  newGuideStations(stations),
+		}
+		if stationID != "" {
+			weekly, err := getWeeklyPrograms(ctx, areaID, stationID)
+			if err != nil {
+				return c.String(http.StatusInternalServerError, err.Error())
+			}
+			res.Days = newGuideDays(stationID, weekly, recorder.Config().Programs, time.Now())
+		}
+
+		acceptHeader := c.Request().Header.Get("Accept")
+		if acceptHeader == "application/json" || acceptHeader == "json" {
+			return c.JSON(http.StatusOK, res)
+		}
+
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(
+			&buf,
+			"guide.html.tmpl",
+			map[string]interface{}{
+				"Guide":    res,
+				"Version":  version,
+				"Revision": revision,
+			},
+		); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.HTML(http.StatusOK, buf.String())
+	}, admin)
+
+	e.POST("/guide/record", func(c echo.Context) error {
+		var req broadcastRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		from, to, err := req.times()
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		jobID, err := recorder.EnqueueRecord(record.OnDemandRequest{
+			StationID: req.StationID,
+			AreaID:    req.AreaID,
+			From:      from,
+			To:        &to,
+			Title:     req.Title,
+		})
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+			return c.JSON(http.StatusAccepted, map[string]string{
+				"job_id":     jobID,
+				"status_url": path.Join("/jobs", jobID),
+			})
+		}
+		return c.Redirect(http.StatusSeeOther, "/jobs")
+	}, admin)
+
+	e.POST("/guide/subscribe", func(c echo.Context) error {
+		var req broadcastRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		from, _, err := req.times()
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		current := recorder.Config()
+		if isSubscribed(current.Programs, req.StationID, from) {
+			return c.String(http.StatusConflict, "the program is already subscribed")
+		}
+		program := config.NewProgramForBroadcast(req.Title, req.AreaID, req.StationID, from)
+		index := len(current.Programs)
+		updated := withProgram(current, index, program)
+		if err := updated.Validate(); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if _, err := recorder.RefreshConfig(updated); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+			return c.JSON(http.StatusCreated, program)
+		}
+		// NOTE: パスなどを続けて設定できるように編集画面に移る
+		return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/config/programs/%d", index))
+	}, admin)
+
+	e.GET("/jobs", func(c echo.Context) error {
+		jobs := recorder.Jobs()
+
+		acceptHeader := c.Request().Header.Get("Accept")
+		if acceptHeader == "application/json" || acceptHeader == "json" {
+			return c.JSON(http.StatusOK, jobs)
+		}
+
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(
+			&buf,
+			"jobs.html.tmpl",
+			map[string]interface{}{
+				"Jobs":     jobs,
+				"Version":  version,
+				"Revision": revision,
+			},
+		); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.HTML(http.StatusOK, buf.String())
+	}, admin)
+
+	e.GET("/jobs/:id", func(c echo.Context) error {
+		j, ok := recorder.Job(c.Param("id"))
+		if !ok {
+			return c.String(http.StatusNotFound, "")
+		}
+		return c.JSON(http.StatusOK, j)
+	}, admin)
+
+	e.POST("/record", func(c echo.Context) error {
+		var req record.OnDemandRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		jobID, err := recorder.EnqueueRecord(req)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusAccepted, map[string]string{
+			"job_id":     jobID,
+			"status_url": path.Join("/jobs", jobID),
+		})
+	}, admin)
 
 	e.PUT("/config", func(ctx echo.Context) error {
 		var c config.Config
@@ -256,9 +606,154 @@ func NewHTTPHandler(
 		}
 
 		return ctx.JSON(http.StatusOK, updatedConfig)
+	}, admin)
+
+	e.GET("/config/tokens", func(c echo.Context) error {
+		if tokens == nil {
+			return c.String(http.StatusNotFound, "feed tokens are disabled")
+		}
+		return c.JSON(http.StatusOK, listFeedTokens(tokens))
+	}, admin)
+
+	e.POST("/config/tokens/rotate", func(c echo.Context) error {
+		if tokens == nil {
+			return c.String(http.StatusNotFound, "feed tokens are disabled")
+		}
+		var req struct {
+			FeedPath string `json:"feed_path" form:"feed_path"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		feedPath := strings.ToLower(strings.Trim(req.FeedPath, "/"))
+		if _, err := tokens.Rotate(feedPath); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		// NOTE: エンクロージャのURLのトークンも新しくする
+		if err := podcaster.Sync(); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, listFeedTokens(tokens))
+	}, admin)
+
+	e.GET("/users/:name/rss.xml", func(c echo.Context) error {
+		if users == nil {
+			return c.String(http.StatusNotFound, "")
+		}
+		u, ok := users.Get(c.Param("name"))
+		if !ok {
+			return c.String(http.StatusNotFound, "")
+		}
+		// NOTE: 本人のトークンか、本人か管理者のBasic認証でだけ取得できる
+		if current, ok := currentUser(c); ok {
+			if current.Name != u.Name && !current.IsAdmin() {
+				return c.String(http.StatusForbidden, "")
+			}
+		} else if subtle.ConstantTimeCompare([]byte(c.QueryParam(feedtoken.QueryParam)), []byte(u.Token)) != 1 {
+			return c.String(http.StatusNotFound, "")
+		}
+		feed, err := podcaster.MergedFeed(fmt.Sprintf("Radicaster(%s)", u.Name), u.FeedPaths(), u.Token)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.Blob(http.StatusOK, "application/xml", []byte(feed))
 	})
 
-	e.Static("/static", targetDir)
+	e.GET("/me", func(c echo.Context) error {
+		u, ok := currentUser(c)
+		if !ok {
+			return c.String(http.StatusNotFound, "users are disabled")
+		}
+		res := newUserResponse(u)
+
+		acceptHeader := c.Request().Header.Get("Accept")
+		if acceptHeader == "application/json" || acceptHeader == "json" {
+			return c.JSON(http.StatusOK, res)
+		}
+
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(
+			&buf,
+			"me.html.tmpl",
+			map[string]interface{}{
+				"User":     res,
+				"Version":  version,
+				"Revision": revision,
+			},
+		); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.HTML(http.StatusOK, buf.String())
+	})
+
+	e.GET("/users", func(c echo.Context) error {
+		if users == nil {
+			return c.String(http.StatusNotFound, "users are disabled")
+		}
+		return c.JSON(http.StatusOK, listUsers(users))
+	}, admin)
+
+	e.PUT("/users/:name", func(c echo.Context) error {
+		if users == nil {
+			return c.String(http.StatusNotFound, "users are disabled")
+		}
+		var params user.Params
+		if err := c.Bind(&params); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		u, err := users.Put(c.Param("name"), params)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, newUserResponse(u))
+	}, admin)
+
+	e.DELETE("/users/:name", func(c echo.Context) error {
+		if users == nil {
+			return c.String(http.StatusNotFound, "users are disabled")
+		}
+		if err := users.Delete(c.Param("name")); err != nil {
+			if errors.Is(err, user.ErrNotFound) {
+				return c.String(http.StatusNotFound, err.Error())
+			}
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, admin)
+
+	e.POST("/users/:name/token/rotate", func(c echo.Context) error {
+		if users == nil {
+			return c.String(http.StatusNotFound, "users are disabled")
+		}
+		u, err := users.RotateToken(c.Param("name"))
+		if err != nil {
+			if errors.Is(err, user.ErrNotFound) {
+				return c.String(http.StatusNotFound, err.Error())
+			}
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, newUserResponse(u))
+	}, admin)
+
+	e.GET(
+		"/static/*",
+		func(c echo.Context) error {
+			return serveStaticFile(c, store, staticFileKey(c))
+		},
+		auth.require(func(c echo.Context) []string {
+			// NOTE: 全てのエピソードを載せるフィードのトークンでも聴けるようにする
+			return []string{staticFileFeedPath(c.Request().Context(), store, staticFileKey(c)), feedtoken.AllFeedPath}
+		}),
+	)
+	e.GET(
+		"/chapters/*",
+		func(c echo.Context) error {
+			return serveChapters(c, store, staticFileKey(c))
+		},
+		auth.require(func(c echo.Context) []string {
+			return []string{staticFileFeedPath(c.Request().Context(), store, staticFileKey(c)), feedtoken.AllFeedPath}
+		}),
+	)
 
 	return e, nil
 }
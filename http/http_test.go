@@ -0,0 +1,711 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/feedtoken"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/podcast"
+	"github.com/upamune/radicaster/radikoutil/radikotest"
+	"github.com/upamune/radicaster/record"
+	"github.com/upamune/radicaster/storage"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/upamune/radicaster/user"
+)
+
+// TestRecordToFeed は偽のradikoサーバーから録音して、フィードに載るまでを確認する。
+func TestRecordToFeed(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+
+	yesterday := time.Now().In(timeutil.JST()).AddDate(0, 0, -1)
+	from := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 1, 0, 0, 0, timeutil.JST())
+	prog := radikotest.NewProg("オールナイトニッポン", from, time.Minute)
+	prog.Pfm = "オードリー"
+	prog.URL = "https://www.allnightnippon.com/"
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:    "LFR",
+		Name:  "ニッポン放送",
+		Progs: []radikotest.Prog{prog},
+		Musics: []radikotest.Music{
+			{Title: "曲", Artist: "アーティスト", StartedAt: from.Add(20 * time.Second)},
+		},
+	})
+	defer srv.Close()
+
+	var (
+		logger    = zerolog.Nop()
+		targetDir = t.TempDir()
+		baseURL   = "http://radicaster.test"
+	)
+	now := time.Now()
+	store := storage.NewLocal(targetDir)
+	podcaster := podcast.NewPodcaster(logger, baseURL, store, "Radicaster", baseURL, "Radicaster", &now, "")
+	program := config.Program{
+		Title:     "ANN",
+		Cron:      "0 0 1 1 *",
+		Weekdays:  []timeutil.Weekday{timeutil.Weekday(yesterday.Weekday())},
+		StationID: "LFR",
+		Start:     "0100",
+		Encoding:  config.AudioFormatAAC,
+		Path:      "ann",
+		Channel: config.Channel{
+			Description: "深夜のラジオ番組",
+			Author:      "ニッポン放送",
+			OwnerEmail:  "owner@example.com",
+			Category:    "Comedy",
+			Language:    "ja",
+		},
+	}
+	recorder, err := record.NewRecorder(
+		logger, store, "", "", config.Config{Programs: []config.Program{program}}, "",
+		record.WithSyncer(podcaster),
+		record.WithRadikoEndpoint(srv.URL),
+		record.WithWorkDir(t.TempDir()),
+	)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %+v", err)
+	}
+	handler, err := NewHTTPHandler(logger, "test", "test", podcaster, recorder, store, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPHandler() error = %+v", err)
+	}
+
+	podcaster.SetConfigProvider(recorder)
+
+	if err := recorder.Record(program); err != nil {
+		t.Fatalf("Record() error = %+v", err)
+	}
+	if err := podcaster.Sync(); err != nil {
+		t.Fatalf("Sync() error = %+v", err)
+	}
+
+	episode := []string{
+		"オールナイトニッポン",
+		`<enclosure url="` + baseURL + "/static/",
+		"<link>https://www.allnightnippon.com/</link>",
+		"<itunes:author>オードリー</itunes:author>",
+		"<itunes:duration>",
+		`<podcast:chapters url="` + baseURL + "/chapters/",
+	}
+	channel := []string{
+		"<description>深夜のラジオ番組</description>",
+		"<itunes:author>ニッポン放送</itunes:author>",
+		"<itunes:email>owner@example.com</itunes:email>",
+		`<itunes:category text="Comedy">`,
+		"<language>ja</language>",
+		"<itunes:explicit>false</itunes:explicit>",
+	}
+	for path, wants := range map[string][]string{
+		"/all/rss.xml": episode,
+		"/ann/rss.xml": append(episode, channel...),
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d", path, rec.Code)
+		}
+		body, _ := io.ReadAll(rec.Body)
+		for _, want := range wants {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("GET %s: feed does not contain %q\n%s", path, want, body)
+			}
+		}
+	}
+}
+
+func TestFeedToken(t *testing.T) {
+	t.Parallel()
+	srv := radikotest.NewServer()
+	defer srv.Close()
+
+	var (
+		logger    = zerolog.Nop()
+		targetDir = t.TempDir()
+		baseURL   = "http://radicaster.test"
+	)
+	writeEpisode(t, targetDir, "ann")
+
+	tokens, err := feedtoken.NewStore(filepath.Join(t.TempDir(), "feed_tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	store := storage.NewLocal(targetDir)
+	podcaster := podcast.NewPodcaster(logger, baseURL, store, "Radicaster", baseURL, "Radicaster", &now, "", podcast.WithFeedTokens(tokens))
+	recorder, err := record.NewRecorder(logger, store, "", "", config.Config{}, "", record.WithRadikoEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %+v", err)
+	}
+	handler, err := NewHTTPHandler(logger, "test", "test", podcaster, recorder, store, "user:pass", tokens, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPHandler() error = %+v", err)
+	}
+	if err := podcaster.Rescan(context.Background()); err != nil {
+		t.Fatalf("Rescan() error = %+v", err)
+	}
+
+	annToken, err := tokens.Token("ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allToken, err := tokens.Token(feedtoken.AllFeedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	feed := get("/ann/rss.xml?token=" + annToken)
+	if feed.Code != http.StatusOK {
+		t.Fatalf("GET feed with token: status = %d", feed.Code)
+	}
+	if want := "/static/ann.mp3?token=" + annToken; !strings.Contains(feed.Body.String(), want) {
+		t.Errorf("feed does not contain %q\n%s", want, feed.Body.String())
+	}
+
+	tests := map[string]struct {
+		path string
+		want int
+	}{
+		"feed without token":         {path: "/ann/rss.xml", want: http.StatusNotFound},
+		"feed with other token":      {path: "/ann/rss.xml?token=" + allToken, want: http.StatusNotFound},
+		"all feed with token":        {path: "/all/rss.xml?token=" + allToken, want: http.StatusOK},
+		"uppercase feed with token":  {path: "/ANN/rss.xml?token=" + annToken, want: http.StatusOK},
+		"uppercase feed other token": {path: "/ANN/rss.xml?token=" + allToken, want: http.StatusNotFound},
+		"audio without token":        {path: "/static/ann.mp3", want: http.StatusNotFound},
+		"audio with feed token":      {path: "/static/ann.mp3?token=" + annToken, want: http.StatusOK},
+		"audio with all feed token":  {path: "/static/ann.mp3?token=" + allToken, want: http.StatusOK},
+	}
+	for name, tt := range tests {
+		if got := get(tt.path).Code; got != tt.want {
+			t.Errorf("%s: GET %s status = %d, want %d", name, tt.path, got, tt.want)
+		}
+	}
+
+	// NOTE: ローテーションすると古いトークンは使えなくなる
+	req := httptest.NewRequest(http.MethodPost, "/config/tokens/rotate", strings.NewReader(`{"feed_path":"ann"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("user", "pass")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /config/tokens/rotate: status = %d", rec.Code)
+	}
+	if got := get("/ann/rss.xml?token=" + annToken).Code; got != http.StatusNotFound {
+		t.Errorf("GET feed with rotated token: status = %d, want %d", got, http.StatusNotFound)
+	}
+	rotated, err := tokens.Token("ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get("/ann/rss.xml?token=" + rotated); got.Code != http.StatusOK || !strings.Contains(got.Body.String(), "token="+rotated) {
+		t.Errorf("GET feed with new token: status = %d\n%s", got.Code, got.Body.String())
+	}
+}
+
+func TestUsers(t *testing.T) {
+	t.Parallel()
+	srv := radikotest.NewServer()
+	defer srv.Close()
+
+	var (
+		logger    = zerolog.Nop()
+		targetDir = t.TempDir()
+		baseURL   = "http://radicaster.test"
+	)
+	writeEpisode(t, targetDir, "ann")
+	writeEpisode(t, targetDir, "junk")
+
+	users, err := user.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Put("admin", user.Params{Password: "admin", Role: user.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	kid, err := users.Put("kid", user.Params{Password: "kid", Role: user.RoleListener, Feeds: []string{"ann"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	store := storage.NewLocal(targetDir)
+	podcaster := podcast.NewPodcaster(logger, baseURL, store, "Radicaster", baseURL, "Radicaster", &now, "")
+	recorder, err := record.NewRecorder(logger, store, "", "", config.Config{}, "", record.WithRadikoEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %+v", err)
+	}
+	handler, err := NewHTTPHandler(logger, "test", "test", podcaster, recorder, store, "", nil, users)
+	if err != nil {
+		t.Fatalf("NewHTTPHandler() error = %+v", err)
+	}
+	if err := podcaster.Rescan(context.Background()); err != nil {
+		t.Fatalf("Rescan() error = %+v", err)
+	}
+
+	do := func(method, path, name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		if name != "" {
+			req.SetBasicAuth(name, name)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := map[string]struct {
+		method, path, user string
+		want               int
+	}{
+		"anonymous config":                {method: http.MethodGet, path: "/config", want: http.StatusUnauthorized},
+		"admin config":                    {method: http.MethodGet, path: "/config", user: "admin", want: http.StatusOK},
+		"listener config":                 {method: http.MethodGet, path: "/config", user: "kid", want: http.StatusForbidden},
+		"listener edits config":           {method: http.MethodPut, path: "/config", user: "kid", want: http.StatusForbidden},
+		"listener records":                {method: http.MethodPost, path: "/record", user: "kid", want: http.StatusForbidden},
+		"listener users":                  {method: http.MethodGet, path: "/users", user: "kid", want: http.StatusForbidden},
+		"listener allowed feed":           {method: http.MethodGet, path: "/ann/rss.xml", user: "kid", want: http.StatusOK},
+		"listener other feed":             {method: http.MethodGet, path: "/junk/rss.xml", user: "kid", want: http.StatusForbidden},
+		"listener allowed feed uppercase": {method: http.MethodGet, path: "/ANN/rss.xml", user: "kid", want: http.StatusOK},
+		"listener other feed uppercase":   {method: http.MethodGet, path: "/JUNK/rss.xml", user: "kid", want: http.StatusForbidden},
+		"listener all feed":               {method: http.MethodGet, path: "/all/rss.xml", user: "kid", want: http.StatusForbidden},
+		"listener allowed feed by token":  {method: http.MethodGet, path: "/ann/rss.xml?token=" + kid.Token, want: http.StatusOK},
+		"listener other feed by token":    {method: http.MethodGet, path: "/junk/rss.xml?token=" + kid.Token, want: http.StatusNotFound},
+		"listener allowed audio by token": {method: http.MethodGet, path: "/static/ann.mp3?token=" + kid.Token, want: http.StatusOK},
+		"listener other audio by token":   {method: http.MethodGet, path: "/static/junk.mp3?token=" + kid.Token, want: http.StatusNotFound},
+		"anonymous audio":                 {method: http.MethodGet, path: "/static/ann.mp3", want: http.StatusUnauthorized},
+		"anonymous metadata":              {method: http.MethodGet, path: "/static/ann.mp3.json", want: http.StatusUnauthorized},
+		"anonymous chapters":              {method: http.MethodGet, path: "/chapters/ann.mp3", want: http.StatusUnauthorized},
+		"listener allowed audio":          {method: http.MethodGet, path: "/static/ann.mp3", user: "kid", want: http.StatusOK},
+		"listener other audio":            {method: http.MethodGet, path: "/static/junk.mp3", user: "kid", want: http.StatusForbidden},
+		"admin audio":                     {method: http.MethodGet, path: "/static/junk.mp3", user: "admin", want: http.StatusOK},
+		"personal feed without token":     {method: http.MethodGet, path: "/users/kid/rss.xml", want: http.StatusUnauthorized},
+		"personal feed of other user":     {method: http.MethodGet, path: "/users/admin/rss.xml?token=" + kid.Token, want: http.StatusNotFound},
+		"listener me":                     {method: http.MethodGet, path: "/me", user: "kid", want: http.StatusOK},
+	}
+	for name, tt := range tests {
+		if got := do(tt.method, tt.path, tt.user).Code; got != tt.want {
+			t.Errorf("%s: %s %s status = %d, want %d", name, tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	personal := do(http.MethodGet, "/users/kid/rss.xml?token="+kid.Token, "")
+	if personal.Code != http.StatusOK {
+		t.Fatalf("GET personal feed: status = %d", personal.Code)
+	}
+	body := personal.Body.String()
+	if !strings.Contains(body, "/static/ann.mp3?token="+kid.Token) || strings.Contains(body, "junk.mp3") {
+		t.Errorf("personal feed should only contain allowed episodes with the user token\n%s", body)
+	}
+}
+
+func TestChapters(t *testing.T) {
+	t.Parallel()
+	var (
+		logger    = zerolog.Nop()
+		targetDir = t.TempDir()
+		baseURL   = "http://radicaster.test"
+	)
+	writeEpisode(t, targetDir, "ann")
+	if err := metadata.WriteByAudioFilePath(filepath.Join(targetDir, "ann.mp3"), metadata.EpisodeMetadata{
+		Title:       "ann",
+		PublishedAt: time.Now(),
+		Path:        "ann",
+		Chapters: []metadata.Chapter{
+			{Title: "オープニング", StartSeconds: 0, EndSeconds: 20},
+			{Title: "曲 / アーティスト", StartSeconds: 20, EndSeconds: 60},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	writeEpisode(t, targetDir, "junk")
+
+	now := time.Now()
+	store := storage.NewLocal(targetDir)
+	podcaster := podcast.NewPodcaster(logger, baseURL, store, "Radicaster", baseURL, "Radicaster", &now, "")
+	handler, err := NewHTTPHandler(logger, "test", "test", podcaster, nil, store, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPHandler() error = %+v", err)
+	}
+	if err := podcaster.Rescan(context.Background()); err != nil {
+		t.Fatalf("Rescan() error = %+v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	feed := get("/ann/rss.xml").Body.String()
+	for _, want := range []string{
+		`xmlns:podcast="https://podcastindex.org/namespace/1.0"`,
+		`<podcast:chapters url="` + baseURL + `/chapters/ann.mp3" type="application/json+chapters"></podcast:chapters>`,
+	} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("feed does not contain %q\n%s", want, feed)
+		}
+	}
+	if junk := get("/junk/rss.xml").Body.String(); strings.Contains(junk, "podcast:chapters") {
+		t.Errorf("feed without chapters contains podcast:chapters\n%s", junk)
+	}
+
+	rec := get("/chapters/ann.mp3")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET chapters: status = %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != podcast.ChaptersMIMEType {
+		t.Errorf("GET chapters: Content-Type = %s", got)
+	}
+	var chapters struct {
+		Version  string `json:"version"`
+		Chapters []struct {
+			StartTime float64 `json:"startTime"`
+			EndTime   float64 `json:"endTime"`
+			Title     string  `json:"title"`
+		} `json:"chapters"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&chapters); err != nil {
+		t.Fatal(err)
+	}
+	if chapters.Version != "1.2.0" || len(chapters.Chapters) != 2 ||
+		chapters.Chapters[1].StartTime != 20 || chapters.Chapters[1].Title != "曲 / アーティスト" {
+		t.Errorf("GET chapters = %+v", chapters)
+	}
+	if rec := get("/chapters/junk.mp3"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET chapters without chapters: status = %d", rec.Code)
+	}
+}
+
+// writeEpisode は feedPath のフィードに載るエピソードを targetDir に書く。
+func writeEpisode(t *testing.T, targetDir, feedPath string) {
+	t.Helper()
+	audioFile := filepath.Join(targetDir, feedPath+".mp3")
+	// NOTE: ID3タグの先頭だけ書いておけば音声ファイルとして扱われる
+	if err := os.WriteFile(audioFile, []byte("ID3\x03\x00\x00\x00\x00\x00\x00audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.WriteByAudioFilePath(audioFile, metadata.EpisodeMetadata{
+		Title:       feedPath,
+		PublishedAt: time.Now(),
+		Path:        feedPath,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgramEditor(t *testing.T) {
+	t.Parallel()
+	srv := radikotest.NewServer(radikotest.Station{ID: "LFR", Name: "ニッポン放送"})
+	defer srv.Close()
+
+	var (
+		logger     = zerolog.Nop()
+		targetDir  = t.TempDir()
+		configPath = filepath.Join(t.TempDir(), "radicaster.yaml")
+	)
+	now := time.Now()
+	store := storage.NewLocal(targetDir)
+	podcaster := podcast.NewPodcaster(logger, "http://radicaster.test", store, "Radicaster", "", "Radicaster", &now, "")
+	recorder, err := record.NewRecorder(logger, store, "", "", config.Config{}, configPath, record.WithRadikoEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %+v", err)
+	}
+	handler, err := NewHTTPHandler(logger, "test", "test", podcaster, recorder, store, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPHandler() error = %+v", err)
+	}
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	form := url.Values{
+		"title":    {"オールナイトニッポン"},
+		"station":  {"TBS"},
+		"start":    {"29:00"},
+		"cron":     {"0 5 * *"},
+		"encoding": {"aac"},
+		"path":     {"ann"},
+	}
+	rec := post("/config/programs", form)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST invalid program: status = %d", rec.Code)
+	}
+	for _, want := range []string{"局が見つかりません: TBS", "曜日を1つ以上選んでください", "HHMM 形式", "cronの書式が不正です"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("form does not show error %q", want)
+		}
+	}
+	if got := len(recorder.Config().Programs); got != 0 {
+		t.Errorf("invalid program should not be saved: programs = %d", got)
+	}
+
+	form.Set("station", "LFR")
+	form.Set("start", "0100")
+	form.Set("cron", "0 5 * * 2")
+	form["weekdays"] = []string{"1", "2"}
+	if rec := post("/config/programs", form); rec.Code != http.StatusSeeOther {
+		t.Fatalf("POST program: status = %d\n%s", rec.Code, rec.Body.String())
+	}
+	programs := recorder.Config().Programs
+	if len(programs) != 1 || programs[0].StationID != "LFR" || len(programs[0].Weekdays) != 2 || programs[0].Weekdays[1] != timeutil.Weekday(time.Tuesday) {
+		t.Fatalf("saved programs = %+v", programs)
+	}
+
+	form.Set("title", "オードリーのオールナイトニッポン")
+	if rec := post("/config/programs/0", form); rec.Code != http.StatusSeeOther {
+		t.Fatalf("POST edited program: status = %d\n%s", rec.Code, rec.Body.String())
+	}
+	if got := recorder.Config().Programs[0].Title; got != "オードリーのオールナイトニッポン" {
+		t.Errorf("edited title = %s", got)
+	}
+	saved, err := os.ReadFile(configPath)
+	if err != nil || !strings.Contains(string(saved), "オードリーのオールナイトニッポン") {
+		t.Errorf("config file is not updated: %s, %v", saved, err)
+	}
+
+	if rec := post("/config/zenroku/stations/LFR", url.Values{"enabled": {"true"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("POST zenroku station: status = %d", rec.Code)
+	}
+	if got := recorder.Config().Zenroku.EnableStationIDs; len(got) != 1 || got[0] != "LFR" {
+		t.Errorf("enabled zenroku stations = %v", got)
+	}
+
+	// NOTE: 他のサイトのフォームから送られたリクエストは、ブラウザがBasic認証の情報を付けていても拒否する
+	for name, headers := range map[string]map[string]string{
+		"cross-site":       {"Sec-Fetch-Site": "cross-site", "Origin": "http://evil.test"},
+		"other origin":     {"Origin": "http://evil.test"},
+		"same-site domain": {"Sec-Fetch-Site": "same-site"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/config/programs/0/delete", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: POST delete program: status = %d, want %d", name, rec.Code, http.StatusForbidden)
+		}
+	}
+	if got := len(recorder.Config().Programs); got != 1 {
+		t.Fatalf("programs after cross-site delete = %d", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/config/programs/0/delete", nil)
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	req.Header.Set("Origin", "http://"+req.Host)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("POST delete program: status = %d", rec.Code)
+	}
+	if got := len(recorder.Config().Programs); got != 0 {
+		t.Errorf("programs after delete = %d", got)
+	}
+	if rec := post("/config/programs/0/delete", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("POST delete unknown program: status = %d", rec.Code)
+	}
+}
+
+func TestOnDemandRecord(t *testing.T) {
+	t.Parallel()
+	// NOTE: 番組表に無い番組を指定して、受け付けたリクエストがすぐに失敗するようにする
+	srv := radikotest.NewServer(radikotest.Station{ID: "LFR", Name: "ニッポン放送"})
+	defer srv.Close()
+
+	logger := zerolog.Nop()
+	now := time.Now()
+	store := storage.NewLocal(t.TempDir())
+	podcaster := podcast.NewPodcaster(logger, "http://radicaster.test", store, "Radicaster", "", "Radicaster", &now, "")
+	recorder, err := record.NewRecorder(logger, store, "", "", config.Config{}, "", record.WithRadikoEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %+v", err)
+	}
+	handler, err := NewHTTPHandler(logger, "test", "test", podcaster, recorder, store, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPHandler() error = %+v", err)
+	}
+
+	tests := map[string]struct {
+		body string
+		want int
+	}{
+		"program start": {
+			body: fmt.Sprintf(`{"station_id":"LFR","from":%q}`, now.Add(-time.Hour).Format(time.RFC3339)),
+			want: http.StatusAccepted,
+		},
+		"out of the timeshift window": {
+			body: fmt.Sprintf(`{"station_id":"LFR","from":%q}`, now.AddDate(0, 0, -8).Format(time.RFC3339)),
+			want: http.StatusBadRequest,
+		},
+		"ends in the future": {
+			body: fmt.Sprintf(`{"station_id":"LFR","from":%q,"to":%q}`, now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339)),
+			want: http.StatusBadRequest,
+		},
+		"unknown encoding": {
+			body: fmt.Sprintf(`{"station_id":"LFR","from":%q,"encoding":"wav"}`, now.Add(-time.Hour).Format(time.RFC3339)),
+			want: http.StatusBadRequest,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodPost, "/record", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("POST /record: status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code != http.StatusAccepted {
+				return
+			}
+			var res map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if _, ok := recorder.Job(res["job_id"]); !ok {
+				t.Errorf("job %q is not found", res["job_id"])
+			}
+			if want := "/jobs/" + res["job_id"]; res["status_url"] != want {
+				t.Errorf("status_url = %q, want %q", res["status_url"], want)
+			}
+		})
+	}
+}
+
+func TestGuide(t *testing.T) {
+	t.Parallel()
+	today := time.Now().In(timeutil.JST())
+	// NOTE: 2日前の25:00からの番組と、明日の22:00からの番組
+	past := radikotest.NewProg("オールナイトニッポン", time.Date(today.Year(), today.Month(), today.Day()-1, 1, 0, 0, 0, timeutil.JST()), 2*time.Hour)
+	past.Pfm = "オードリー"
+	future := radikotest.NewProg("オールナイトニッポンGOLD", time.Date(today.Year(), today.Month(), today.Day()+1, 22, 0, 0, 0, timeutil.JST()), 2*time.Hour)
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:    "LFR",
+		Name:  "ニッポン放送",
+		Progs: []radikotest.Prog{past, future},
+	})
+	defer srv.Close()
+
+	var (
+		logger    = zerolog.Nop()
+		targetDir = t.TempDir()
+	)
+	now := time.Now()
+	store := storage.NewLocal(targetDir)
+	podcaster := podcast.NewPodcaster(logger, "http://radicaster.test", store, "Radicaster", "", "Radicaster", &now, "")
+	recorder, err := record.NewRecorder(logger, store, "", "", config.Config{}, "", record.WithRadikoEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %+v", err)
+	}
+	handler, err := NewHTTPHandler(logger, "test", "test", podcaster, recorder, store, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPHandler() error = %+v", err)
+	}
+
+	getGuide := func() guideResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/guide?station=LFR", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /guide: status = %d\n%s", rec.Code, rec.Body.String())
+		}
+		var res guideResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	entries := func(res guideResponse) map[string]guideEntry {
+		m := make(map[string]guideEntry)
+		for _, d := range res.Days {
+			for _, p := range d.Programs {
+				m[p.Title] = p
+			}
+		}
+		return m
+	}
+
+	guide := getGuide()
+	if len(guide.Stations) != 1 || guide.StationID != "LFR" {
+		t.Errorf("stations = %+v, station_id = %s", guide.Stations, guide.StationID)
+	}
+	got := entries(guide)
+	if p := got["オールナイトニッポン"]; p.BroadcastStart != "25:00" || p.BroadcastEnd != "27:00" || !p.Recordable || p.Performer != "オードリー" {
+		t.Errorf("past program = %+v", p)
+	}
+	if p := got["オールナイトニッポンGOLD"]; p.BroadcastStart != "22:00" || p.BroadcastEnd != "24:00" || p.Recordable {
+		t.Errorf("future program = %+v", p)
+	}
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	p := got["オールナイトニッポン"]
+	form := url.Values{
+		"station_id": {p.StationID},
+		"title":      {p.Title},
+		"from":       {p.From.Format(time.RFC3339)},
+		"to":         {p.To.Format(time.RFC3339)},
+	}
+	rec := post("/guide/subscribe", form)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/config/programs/0" {
+		t.Fatalf("POST /guide/subscribe: status = %d, location = %s", rec.Code, rec.Header().Get("Location"))
+	}
+	programs := recorder.Config().Programs
+	if len(programs) != 1 {
+		t.Fatalf("programs = %+v", programs)
+	}
+	// NOTE: 番組表の通り前日の 25:00 として保存され、録音する時は実際の曜日と時刻になる
+	weekdays, start, err := programs[0].Schedule()
+	if err != nil || programs[0].Start != "2500" || len(weekdays) != 1 || time.Weekday(weekdays[0]) != p.From.Weekday() || start != "0100" {
+		t.Errorf("subscribed program = %+v, want weekday %s", programs[0], p.From.Weekday())
+	}
+	if rec := post("/guide/subscribe", form); rec.Code != http.StatusConflict {
+		t.Errorf("POST /guide/subscribe twice: status = %d", rec.Code)
+	}
+	if !entries(getGuide())["オールナイトニッポン"].Subscribed {
+		t.Errorf("subscribed program should be marked in the guide")
+	}
+
+	form.Set("to", form.Get("from"))
+	if rec := post("/guide/record", form); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /guide/record with empty range: status = %d", rec.Code)
+	}
+}
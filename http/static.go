@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/cockroachdb/errors"
+	"github.com/labstack/echo/v4"
+	"github.com/upamune/radicaster/storage"
+)
+
+// staticFileKey は /static/* や /chapters/* のパスからストレージのキーを返す。
+func staticFileKey(c echo.Context) string {
+	name := c.Param("*")
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
+
+// serveStaticFile はストレージの音声ファイルとメタデータを配信する。
+// 署名付きURLを発行できるストレージの場合は、そのURLにリダイレクトしてストレージから直接配信する。
+func serveStaticFile(c echo.Context, store storage.Storage, key string) error {
+	ctx := c.Request().Context()
+	obj, err := store.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return echo.ErrNotFound
+		}
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if presigner, ok := store.(storage.Presigner); ok {
+		u, err := presigner.PresignedURL(ctx, obj.Key)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.Redirect(http.StatusFound, u)
+	}
+	f, err := store.Open(ctx, obj.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return echo.ErrNotFound
+		}
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	defer f.Close()
+	http.ServeContent(c.Response(), c.Request(), path.Base(obj.Key), obj.ModTime, f)
+	return nil
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/labstack/echo/v4"
+	"github.com/upamune/radicaster/user"
+)
+
+const userContextKey = "user"
+
+// currentUser はBasic認証でログインしているユーザーを返す。
+func currentUser(c echo.Context) (user.User, bool) {
+	u, ok := c.Get(userContextKey).(user.User)
+	return u, ok
+}
+
+// requireAdmin は管理者だけを通す。
+// ユーザーを管理していない場合は、Basic認証を通った人を管理者として扱う。
+func requireAdmin(users *user.Store) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if users == nil {
+				return next(c)
+			}
+			if u, ok := currentUser(c); ok && u.IsAdmin() {
+				return next(c)
+			}
+			return c.String(http.StatusForbidden, "")
+		}
+	}
+}
+
+type feedLink struct {
+	FeedPath string `json:"feed_path"`
+	FeedURL  string `json:"feed_url"`
+}
+
+type userResponse struct {
+	Name  string     `json:"name"`
+	Role  user.Role  `json:"role"`
+	Feeds []feedLink `json:"feeds"`
+	// PersonalFeedURL は聴けるフィードを全てまとめた個人用フィードのURL。
+	PersonalFeedURL string `json:"personal_feed_url"`
+}
+
+func newUserResponse(u user.User) userResponse {
+	feeds := make([]feedLink, 0, len(u.FeedPaths()))
+	for _, feedPath := range u.FeedPaths() {
+		feeds = append(feeds, feedLink{
+			FeedPath: feedPath,
+			FeedURL:  withTokenQuery(feedPathURL(feedPath), u.Token),
+		})
+	}
+	return userResponse{
+		Name:            u.Name,
+		Role:            u.Role,
+		Feeds:           feeds,
+		PersonalFeedURL: withTokenQuery(personalFeedURL(u.Name), u.Token),
+	}
+}
+
+func personalFeedURL(name string) string {
+	return path.Join("/users", name, "rss.xml")
+}
+
+func listUsers(users *user.Store) []userResponse {
+	list := users.List()
+	res := make([]userResponse, 0, len(list))
+	for _, u := range list {
+		res = append(res, newUserResponse(u))
+	}
+	return res
+}
@@ -0,0 +1,258 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// NOTE: 履歴ファイルが肥大化しないように、始まってから時間の経ったジョブは捨てる
+const defaultMaxAge = 30 * 24 * time.Hour
+
+// NOTE: 上書きしたジョブの行がこれ以上たまったらファイルを書き直す
+const minCompactLines = 100
+
+type Kind string
+
+const (
+	KindRecord         Kind = "record"
+	KindRecordAll      Kind = "record_all"
+	KindRecordByRule   Kind = "record_by_rule"
+	KindRecordOnDemand Kind = "record_on_demand"
+	KindPrune          Kind = "prune"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusSkipped   Status = "skipped"
+	StatusFailed    Status = "failed"
+)
+
+// Job はタスク(task_id)とサブタスク(sub_task_id)の実行結果を表す。
+type Job struct {
+	ID         string        `json:"id"`
+	ParentID   string        `json:"parent_id,omitempty"`
+	Kind       Kind          `json:"kind"`
+	Program    string        `json:"program,omitempty"`
+	StationID  string        `json:"station_id,omitempty"`
+	From       *time.Time    `json:"from,omitempty"`
+	Status     Status        `json:"status"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Output     string        `json:"output,omitempty"`
+	Bytes      int64         `json:"bytes,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// Store はジョブの履歴をファイルに保存する。
+// path が空の場合はメモリ上にだけ保持する。
+// NOTE: ジョブの状態が変わるたびに全体を書き直さないように、ファイルには1行に1件ずつ追記していく
+type Store struct {
+	mu     sync.RWMutex
+	path   string
+	maxAge time.Duration
+	jobs   []Job
+	// lines はファイルに書かれている行数
+	lines int
+}
+
+// entry はファイルに追記する1行を表す。後の行が同じIDの前の行を上書きする。
+type entry struct {
+	Job
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+type Option func(*Store)
+
+// WithMaxAge は履歴を残しておく期間を指定する。
+func WithMaxAge(d time.Duration) Option {
+	return func(s *Store) {
+		s.maxAge = d
+	}
+}
+
+func NewStore(path string, opts ...Option) (*Store, error) {
+	s := &Store{
+		path:   path,
+		maxAge: defaultMaxAge,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Wrap(err, "failed to open job history file")
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, errors.Wrap(err, "failed to decode job history json")
+		}
+		// NOTE: 以前はジョブの配列をまとめて保存していた
+		if raw[0] == '[' {
+			var jobs []Job
+			if err := json.Unmarshal(raw, &jobs); err != nil {
+				return nil, errors.Wrap(err, "failed to decode job history json")
+			}
+			for _, j := range jobs {
+				s.set(j)
+			}
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, errors.Wrap(err, "failed to decode job history json")
+		}
+		if e.Deleted {
+			s.delete(e.ID)
+		} else {
+			s.set(e.Job)
+		}
+	}
+	s.trim()
+	// NOTE: 起動時に上書きされた行や古いジョブを取り除いておく
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put は同じIDのジョブがあれば上書きし、無ければ追加する。
+func (s *Store) Put(j Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(j)
+	s.trim()
+	return s.append(entry{Job: j})
+}
+
+// Delete はジョブを履歴から取り除く。
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.delete(id) {
+		return nil
+	}
+	return s.append(entry{Job: Job{ID: id}, Deleted: true})
+}
+
+func (s *Store) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := slices.IndexFunc(s.jobs, func(job Job) bool { return job.ID == id })
+	if i < 0 {
+		return Job{}, false
+	}
+	return s.jobs[i], true
+}
+
+// List は新しい順にジョブを返す。
+func (s *Store) List() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := slices.Clone(s.jobs)
+	slices.Reverse(jobs)
+	return jobs
+}
+
+func (s *Store) set(j Job) {
+	if i := slices.IndexFunc(s.jobs, func(job Job) bool { return job.ID == j.ID }); i >= 0 {
+		s.jobs[i] = j
+	} else {
+		s.jobs = append(s.jobs, j)
+	}
+}
+
+func (s *Store) delete(id string) bool {
+	n := len(s.jobs)
+	s.jobs = slices.DeleteFunc(s.jobs, func(job Job) bool { return job.ID == id })
+	return len(s.jobs) < n
+}
+
+// trim は maxAge より前に始まったジョブを捨てる。
+// NOTE: ファイルに残った行は次に書き直すときに消える
+func (s *Store) trim() {
+	cutoff := time.Now().Add(-s.maxAge)
+	s.jobs = slices.DeleteFunc(s.jobs, func(job Job) bool {
+		return !job.StartedAt.IsZero() && job.StartedAt.Before(cutoff)
+	})
+}
+
+// append はファイルに1行追記し、不要な行がたまっていれば書き直す。
+func (s *Store) append(e entry) error {
+	if s.path == "" {
+		return nil
+	}
+	if s.lines >= 2*len(s.jobs)+minCompactLines {
+		return s.compact()
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode job history json")
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open job history file")
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to append job history")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close job history file")
+	}
+	s.lines++
+	return nil
+}
+
+// compact は今のジョブだけでファイルを書き直す。
+func (s *Store) compact() error {
+	// NOTE: 書き込み途中で落ちても履歴が壊れないように一時ファイルからリネームする
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".jobs-*.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp job history file")
+	}
+	defer os.Remove(f.Name())
+
+	enc := json.NewEncoder(f)
+	for _, j := range s.jobs {
+		if err := enc.Encode(entry{Job: j}); err != nil {
+			f.Close()
+			return errors.Wrap(err, "failed to encode job history json")
+		}
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temp job history file")
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return errors.Wrap(err, "failed to rename job history file")
+	}
+	s.lines = len(s.jobs)
+	return nil
+}
@@ -0,0 +1,105 @@
+package job
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	s, err := NewStore(path, WithMaxAge(24*time.Hour))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	old := now.Add(-25 * time.Hour)
+	for _, j := range []Job{
+		{ID: "1", Status: StatusRunning, StartedAt: now},
+		{ID: "2", Status: StatusRunning, StartedAt: now},
+		{ID: "1", Status: StatusSucceeded, StartedAt: now},
+		{ID: "3", Status: StatusFailed, StartedAt: old},
+		{ID: "4", Status: StatusRunning, StartedAt: now},
+	} {
+		if err := s.Put(j); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if err := s.Delete("2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	want := []Job{
+		{ID: "4", Status: StatusRunning, StartedAt: now},
+		{ID: "1", Status: StatusSucceeded, StartedAt: now},
+	}
+	if got := s.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() got = %v, want %v", got, want)
+	}
+	if _, ok := s.Get("3"); ok {
+		t.Errorf("Get() should not find a job older than maxAge")
+	}
+	// NOTE: 状態が変わるたびにファイル全体を書き直さず、1行ずつ追記する
+	if b, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if got := bytes.Count(b, []byte("\n")); got != 6 {
+		t.Errorf("lines = %d, want 6", got)
+	}
+
+	reloaded, err := NewStore(path, WithMaxAge(24*time.Hour))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if got := reloaded.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() after reload got = %v, want %v", got, want)
+	}
+	if b, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if got := bytes.Count(b, []byte("\n")); got != len(want) {
+		t.Errorf("lines after reload = %d, want %d", got, len(want))
+	}
+}
+
+func TestStore_compact(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	for i := 0; i < 3*minCompactLines; i++ {
+		if err := s.Put(Job{ID: "1", Status: StatusRunning, StartedAt: time.Now()}); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(b, []byte("\n")); got > minCompactLines+2 {
+		t.Errorf("lines = %d, should be compacted", got)
+	}
+}
+
+func TestNewStore_legacy(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	now := time.Now().UTC().Truncate(time.Second)
+	legacy := `[{"id":"1","kind":"record","status":"succeeded","started_at":"` + now.Format(time.RFC3339) + `","duration":0}]`
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	want := []Job{{ID: "1", Kind: KindRecord, Status: StatusSucceeded, StartedAt: now}}
+	if got := s.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() got = %v, want %v", got, want)
+	}
+}
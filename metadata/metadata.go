@@ -1,22 +1,74 @@
 package metadata
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/upamune/radicaster/ffmpeg"
+	"github.com/upamune/radicaster/storage"
+	"github.com/upamune/radicaster/timeutil"
 )
 
 type EpisodeMetadata struct {
-	Title        string    `json:"title"`
-	Description  string    `json:"description"`
-	PublishedAt  time.Time `json:"published_at"`
-	ImageURL     string    `json:"image_url"`
-	Path         string    `json:"path"`
-	PodcastTitle string    `json:"podcast_title"`
-	ZenrokuMode  bool      `json:"zenroku_mode"`
+	Title           string     `json:"title"`
+	Description     string     `json:"description"`
+	PublishedAt     time.Time  `json:"published_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds,omitempty"`
+	Performer       string     `json:"performer,omitempty"`
+	StationID       string     `json:"station_id,omitempty"`
+	ProgramURL      string     `json:"program_url,omitempty"`
+	ImageURL        string     `json:"image_url"`
+	Path            string     `json:"path"`
+	PodcastTitle    string     `json:"podcast_title"`
+	ZenrokuMode     bool       `json:"zenroku_mode"`
+	Chapters        []Chapter  `json:"chapters,omitempty"`
+}
+
+// Chapter は音声ファイルの先頭からの位置で表したチャプター。
+// EndSeconds が 0 の場合は次のチャプターかファイルの最後までとして扱う。
+type Chapter struct {
+	Title        string  `json:"title"`
+	StartSeconds float64 `json:"start_seconds"`
+	EndSeconds   float64 `json:"end_seconds,omitempty"`
+}
+
+func (c Chapter) Start() time.Duration {
+	return time.Duration(c.StartSeconds * float64(time.Second))
+}
+
+func (c Chapter) End() time.Duration {
+	return time.Duration(c.EndSeconds * float64(time.Second))
+}
+
+// Duration returns the length of the episode probed by ffprobe.
+// It falls back to the broadcast time when the file could not be probed.
+func (m EpisodeMetadata) Duration() time.Duration {
+	if m.DurationSeconds > 0 {
+		return time.Duration(m.DurationSeconds * float64(time.Second))
+	}
+	if m.EndedAt != nil && m.EndedAt.After(m.PublishedAt) {
+		return m.EndedAt.Sub(m.PublishedAt)
+	}
+	return 0
+}
+
+// FeedPath returns the podcast path that the episode belongs to.
+func (m EpisodeMetadata) FeedPath() string {
+	// NOTE: `/ann` のような設定を `ann` と同値にしてあげる
+	p := strings.ToLower(strings.TrimPrefix(m.Path, "/"))
+	if m.ZenrokuMode {
+		p = path.Join("zenroku", p)
+	}
+	return p
 }
 
 func buildMetadataPath(base string) string {
@@ -37,18 +89,135 @@ func WriteByAudioFilePath(basePath string, metadata EpisodeMetadata) error {
 	return nil
 }
 
+// ReadByAudioFilePath は音声ファイルのメタデータを読む。
+// メタデータのファイルが無い場合は音声ファイルに埋め込まれたタグから読む。
 func ReadByAudioFilePath(basePath string) (EpisodeMetadata, error) {
 	path := buildMetadataPath(basePath)
 
 	f, err := os.Open(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return readEmbeddedTags(context.Background(), basePath)
+		}
+		return EpisodeMetadata{}, errors.Wrap(err, "failed to read metadata file")
+	}
+	defer f.Close()
+
+	var meta EpisodeMetadata
+	if err := json.NewDecoder(f).Decode(&meta); err != nil {
+		return EpisodeMetadata{}, errors.Wrap(err, "failed to decode metadata json")
+	}
+	return meta, nil
+}
+
+func RemoveByAudioFilePath(basePath string) error {
+	path := buildMetadataPath(basePath)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove metadata file")
+	}
+	return nil
+}
+
+// Write は key の音声ファイルのメタデータを s に保存する。
+func Write(ctx context.Context, s storage.Storage, key string, metadata EpisodeMetadata) error {
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode metadata json")
+	}
+	if err := s.Put(ctx, buildMetadataPath(key), bytes.NewReader(b), int64(len(b))); err != nil {
+		return errors.Wrap(err, "failed to put metadata file")
+	}
+	return nil
+}
+
+// Read は key の音声ファイルのメタデータを s から読む。
+// ローカルのディレクトリの場合は、メタデータのファイルが無ければ音声ファイルに埋め込まれたタグから読む。
+func Read(ctx context.Context, s storage.Storage, key string) (EpisodeMetadata, error) {
+	f, err := s.Open(ctx, buildMetadataPath(key))
+	if err != nil {
+		if local, ok := s.(*storage.Local); ok && errors.Is(err, storage.ErrNotExist) {
+			return readEmbeddedTags(ctx, filepath.Join(local.Dir(), filepath.FromSlash(key)))
+		}
 		return EpisodeMetadata{}, errors.Wrap(err, "failed to read metadata file")
 	}
 	defer f.Close()
 
 	var meta EpisodeMetadata
-	if json.NewDecoder(f).Decode(&meta); err != nil {
+	if err := json.NewDecoder(f).Decode(&meta); err != nil {
 		return EpisodeMetadata{}, errors.Wrap(err, "failed to decode metadata json")
 	}
 	return meta, nil
 }
+
+// readEmbeddedTags は音声ファイルに埋め込まれたタグからメタデータを作る。
+// NOTE: タグにはフィードのパスが無いので、デフォルトのフィードに載る
+func readEmbeddedTags(ctx context.Context, audioFilePath string) (EpisodeMetadata, error) {
+	tags, duration, err := ffmpeg.ProbeTags(ctx, audioFilePath, timeutil.JST())
+	if err != nil {
+		return EpisodeMetadata{}, errors.Wrap(err, "failed to read embedded tags")
+	}
+	if tags.Title == "" || tags.Date.IsZero() {
+		return EpisodeMetadata{}, errors.New("embedded tags do not have title and date")
+	}
+	return EpisodeMetadata{
+		Title:           tags.Title,
+		Description:     tags.Description,
+		PublishedAt:     tags.Date,
+		DurationSeconds: duration.Seconds(),
+		StationID:       tags.Artist,
+		PodcastTitle:    tags.Album,
+		Chapters:        newChapters(tags.Chapters),
+	}, nil
+}
+
+// Tags は音声ファイルに埋め込むタグを返す。
+func (m EpisodeMetadata) Tags() ffmpeg.Tags {
+	return ffmpeg.Tags{
+		Title:       m.Title,
+		Album:       m.PodcastTitle,
+		Artist:      m.StationID,
+		Description: m.Description,
+		Date:        m.PublishedAt,
+		Chapters:    m.chapterTags(),
+	}
+}
+
+func (m EpisodeMetadata) chapterTags() []ffmpeg.Chapter {
+	if len(m.Chapters) == 0 {
+		return nil
+	}
+	chapters := make([]ffmpeg.Chapter, 0, len(m.Chapters))
+	for _, c := range m.Chapters {
+		chapters = append(chapters, ffmpeg.Chapter{Title: c.Title, Start: c.Start(), End: c.End()})
+	}
+	return chapters
+}
+
+func newChapters(chapters []ffmpeg.Chapter) []Chapter {
+	if len(chapters) == 0 {
+		return nil
+	}
+	res := make([]Chapter, 0, len(chapters))
+	for _, c := range chapters {
+		res = append(res, Chapter{Title: c.Title, StartSeconds: c.Start.Seconds(), EndSeconds: c.End.Seconds()})
+	}
+	return res
+}
+
+// Remove は key の音声ファイルのメタデータを s から削除する。
+func Remove(ctx context.Context, s storage.Storage, key string) error {
+	if err := s.Delete(ctx, buildMetadataPath(key)); err != nil {
+		return errors.Wrap(err, "failed to remove metadata file")
+	}
+	return nil
+}
+
+// IsMetadataKey は key がメタデータのファイルかを返す。
+func IsMetadataKey(key string) bool {
+	return strings.HasSuffix(key, ".json")
+}
+
+// AudioKey はメタデータのキーから音声ファイルのキーを返す。メタデータのキーでなければそのまま返す。
+func AudioKey(key string) string {
+	return strings.TrimSuffix(key, ".json")
+}
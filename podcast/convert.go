@@ -1,14 +1,31 @@
 package podcast
 
 import (
+	"bytes"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"net/url"
 	"path"
+	"strconv"
 	"time"
 
 	podcastpkg "github.com/eduncan911/podcast"
 )
 
+var extraMIMETypes = map[string]string{
+	".opus": "audio/ogg",
+	".flac": "audio/flac",
+}
+
+const (
+	itunesNamespace  = `xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"`
+	podcastNamespace = `xmlns:podcast="https://podcastindex.org/namespace/1.0"`
+
+	// ChaptersMIMEType は Podcasting 2.0 のチャプターのJSONの MIME タイプ。
+	ChaptersMIMEType = "application/json+chapters"
+)
+
 func encodeXML(w io.Writer, p *Podcast) error {
 	now := time.Now()
 	podcast := podcastpkg.New(
@@ -21,6 +38,20 @@ func encodeXML(w io.Writer, p *Podcast) error {
 	if p.ImageURL != "" {
 		podcast.AddImage(p.ImageURL)
 	}
+	podcast.AddSummary(p.Description)
+	if p.OwnerEmail != "" {
+		podcast.AddAuthor(p.Author, p.OwnerEmail)
+		podcast.IOwner = &podcastpkg.Author{Name: p.Author, Email: p.OwnerEmail}
+	}
+	if p.Author != "" {
+		podcast.IAuthor = p.Author
+	}
+	podcast.AddCategory(p.Category, nil)
+	if p.Language != "" {
+		podcast.Language = p.Language
+	}
+	podcast.IExplicit = strconv.FormatBool(p.Explicit)
+	chaptersURLs := make(map[string]string)
 	for _, e := range p.Episodes {
 		e := e
 		desc := e.Description
@@ -28,16 +59,24 @@ func encodeXML(w io.Writer, p *Podcast) error {
 			desc = "dummy description"
 		}
 		item := podcastpkg.Item{
+			GUID:        e.GUID,
 			Title:       e.Title,
 			Description: desc,
 			PubDate:     e.PublishedAt,
+			IAuthor:     e.Author,
+			// NOTE: 空の場合は AddItem でエンクロージャのURLになる
+			Link: e.Link,
+		}
+		if e.Duration > 0 {
+			item.AddDuration(int64(e.Duration.Round(time.Second).Seconds()))
 		}
 		if e.ImageURL != "" {
 			item.AddImage(e.ImageURL)
 		}
 
+		ext := enclosureExt(e.URL)
 		enclosureType := podcastpkg.M4A
-		if path.Ext(e.URL) == ".mp3" {
+		if ext == ".mp3" {
 			enclosureType = podcastpkg.MP3
 		}
 
@@ -50,7 +89,56 @@ func encodeXML(w io.Writer, p *Podcast) error {
 		if _, err := podcast.AddItem(item); err != nil {
 			return fmt.Errorf("failed to add item to podcast: %w", err)
 		}
+		// NOTE: podcastpkg には Opus と FLAC の種類が無いので、追加した後に MIME タイプを書き換える
+		if mimeType, ok := extraMIMETypes[ext]; ok {
+			podcast.Items[len(podcast.Items)-1].Enclosure.TypeFormatted = mimeType
+		}
+		if e.ChaptersURL != "" {
+			chaptersURLs[e.GUID] = e.ChaptersURL
+		}
 	}
 
-	return podcast.Encode(w)
+	if len(chaptersURLs) == 0 {
+		return podcast.Encode(w)
+	}
+	var buf bytes.Buffer
+	if err := podcast.Encode(&buf); err != nil {
+		return err
+	}
+	_, err := w.Write(addChapters(buf.Bytes(), chaptersURLs))
+	return err
+}
+
+// addChapters は書き出したフィードのエピソードに podcast:chapters を差し込む。
+// chaptersURLs はエピソードのGUIDからチャプターのURLへのマップ。
+// NOTE: podcastpkg には Podcasting 2.0 の要素を書く方法が無いので、GUIDの要素の後に差し込む
+func addChapters(feed []byte, chaptersURLs map[string]string) []byte {
+	feed = bytes.Replace(feed, []byte(itunesNamespace), []byte(itunesNamespace+" "+podcastNamespace), 1)
+	for guid, chaptersURL := range chaptersURLs {
+		guidElem := "<guid>" + escapeXML(guid) + "</guid>"
+		i := bytes.Index(feed, []byte(guidElem))
+		if i < 0 {
+			continue
+		}
+		// NOTE: GUIDの要素と同じインデントにする
+		indent := feed[bytes.LastIndexByte(feed[:i], '\n')+1 : i]
+		chapters := fmt.Sprintf("\n%s<podcast:chapters url=\"%s\" type=\"%s\"></podcast:chapters>", indent, escapeXML(chaptersURL), ChaptersMIMEType)
+		end := i + len(guidElem)
+		feed = append(feed[:end], append([]byte(chapters), feed[end:]...)...)
+	}
+	return feed
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// enclosureExt はトークンのクエリを除いたURLの拡張子を返す。
+func enclosureExt(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return path.Ext(u.Path)
+	}
+	return path.Ext(rawURL)
 }
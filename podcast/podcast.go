@@ -2,12 +2,11 @@ package podcast
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io/fs"
+	"io"
 	"net/url"
-	"os"
 	"path"
-	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
@@ -16,7 +15,11 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/h2non/filetype"
 	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/catalog"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/feedtoken"
 	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/storage"
 )
 
 type Podcast struct {
@@ -25,25 +28,61 @@ type Podcast struct {
 	Description string
 	PublishedAt *time.Time
 	ImageURL    string
+	Author      string
+	OwnerEmail  string
+	Category    string
+	Language    string
+	Explicit    bool
 
 	Episodes []Episode
 }
 
 type Episode struct {
+	// NOTE: トークンを付け替えてもアプリで別のエピソードにならないように、トークンを含まないURLにする
+	GUID          string
 	Title         string
 	Description   string
 	PublishedAt   *time.Time
+	Duration      time.Duration
+	Author        string
+	Link          string
 	URL           string
 	LengthInBytes int64
 	ImageURL      string
 	PodcastTitle  string
+	// ChaptersURL は Podcasting 2.0 のチャプターのJSONのURL。チャプターが無い場合は空になる。
+	ChaptersURL string
+}
+
+// ConfigProvider は番組ごとのチャンネル情報を引くための設定を返す。
+type ConfigProvider interface {
+	Config() config.Config
+}
+
+type Option func(p *Podcaster)
+
+// WithCatalog はエピソードの一覧を c に保存する。指定しない場合はメモリ上にだけ持つ。
+func WithCatalog(c *catalog.Store) Option {
+	return func(p *Podcaster) {
+		p.catalog = c
+	}
+}
+
+// WithFeedTokens はエンクロージャのURLにフィードのトークンを付ける。
+func WithFeedTokens(tokens *feedtoken.Store) Option {
+	return func(p *Podcaster) {
+		p.tokens = tokens
+	}
 }
 
 type Podcaster struct {
-	logger zerolog.Logger
+	logger         zerolog.Logger
+	configProvider ConfigProvider
+	tokens         *feedtoken.Store
+	catalog        *catalog.Store
 
-	baseURL   string
-	targetDir string
+	baseURL string
+	storage storage.Storage
 
 	title       string
 	link        string
@@ -51,24 +90,28 @@ type Podcaster struct {
 	publishedAt *time.Time
 	imageURL    string
 
+	syncMu  sync.Mutex
 	mu      *sync.RWMutex
 	feedMap map[string]string
+	// NOTE: 個人用フィードを組み立てるために、トークンを付ける前のエピソードを持っておく
+	episodeMap map[string][]Episode
 }
 
 func NewPodcaster(
 	logger zerolog.Logger,
 	baseURL string,
-	targetDir string,
+	store storage.Storage,
 	title string,
 	link string,
 	description string,
 	publishedAt *time.Time,
 	imageURL string,
+	opts ...Option,
 ) *Podcaster {
 	p := &Podcaster{
 		logger:      logger,
 		baseURL:     baseURL,
-		targetDir:   targetDir,
+		storage:     store,
 		title:       title,
 		link:        link,
 		description: description,
@@ -76,9 +119,23 @@ func NewPodcaster(
 		imageURL:    imageURL,
 		mu:          &sync.RWMutex{},
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.catalog == nil {
+		// NOTE: パスが空の場合はファイルを読まないのでエラーにならない
+		p.catalog, _ = catalog.NewStore("")
+	}
 	return p
 }
 
+// SetConfigProvider はフィードのチャンネル情報を引く設定を指定する。次の Sync から反映される。
+func (p *Podcaster) SetConfigProvider(cp ConfigProvider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.configProvider = cp
+}
+
 func (p *Podcaster) GetDefaultFeed() string {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -105,88 +162,198 @@ func sortEpisodesByPublishedAtDesc(episodes []Episode) {
 	})
 }
 
-func (p *Podcaster) Sync() error {
-	p.logger.Info().Msg("Podcaster.Sync started")
-	defer func() {
-		p.logger.Info().Msg("Podcaster.Sync ended")
-	}()
+// newEpisode はカタログのエピソードからフィードのエピソードを作る。
+func newEpisode(baseURL *url.URL, e catalog.Episode) Episode {
+	u := *baseURL
+	u.Path = path.Join(u.Path, "static", e.Key)
+
+	ep := Episode{
+		GUID:          u.String(),
+		Title:         e.Key,
+		URL:           u.String(),
+		LengthInBytes: e.Size,
+	}
+	if ss := strings.Split(path.Base(e.Key), "_"); len(ss) > 1 {
+		ep.Title = ss[0]
+		if startedAt, err := time.Parse("200601021504", strings.TrimSuffix(ss[1], path.Ext(ss[1]))); err == nil {
+			ep.PublishedAt = &startedAt
+		}
+	}
+	if ep.PublishedAt == nil {
+		now := time.Now()
+		ep.PublishedAt = &now
+	}
 
+	// NOTE: メタデータがあればそれで全て上書きする
+	if md := e.Metadata; md != nil {
+		ep.Title = md.Title
+		ep.Description = md.Description
+		ep.PublishedAt = &md.PublishedAt
+		ep.Duration = md.Duration()
+		ep.Author = md.Performer
+		ep.Link = md.ProgramURL
+		ep.ImageURL = md.ImageURL
+		ep.PodcastTitle = md.PodcastTitle
+		if len(md.Chapters) > 0 {
+			cu := *baseURL
+			cu.Path = path.Join(cu.Path, "chapters", e.Key)
+			ep.ChaptersURL = cu.String()
+		}
+	}
+	return ep
+}
+
+// Update は keys のファイルをストレージから読み直してカタログに反映し、そのエピソードが載るフィードだけを作り直す。
+// メタデータのキーの場合はその音声ファイルを読み直す。
+func (p *Podcaster) Update(ctx context.Context, keys ...string) error {
 	var (
-		allEpisodes         []Episode
-		pathGroupedEpisodes = make(map[string][]Episode)
+		affected    = make(map[string]struct{})
+		episodes    []catalog.Episode
+		deletedKeys []string
+		errs        []error
 	)
-	p.logger.Info().Str("target_dir", p.targetDir).Msg("filepath.Walk is starting")
-	if err := filepath.Walk(p.targetDir, func(fpath string, info fs.FileInfo, err error) error {
+	for _, key := range keys {
+		key = metadata.AudioKey(key)
+		ep, ok, err := p.loadEpisode(ctx, key)
 		if err != nil {
-			return err
+			// NOTE: 読めなかったファイルがあっても他のファイルは反映する
+			errs = append(errs, errors.Wrapf(err, "key=%s", key))
+			continue
 		}
-
-		if info.IsDir() {
-			return nil
+		if old, ok := p.catalog.Get(key); ok {
+			affected[old.FeedPath()] = struct{}{}
+		}
+		if !ok {
+			deletedKeys = append(deletedKeys, key)
+			continue
+		}
+		p.logger.Info().Str("path", key).Msg("update the episode in the catalog")
+		episodes = append(episodes, ep)
+		affected[ep.FeedPath()] = struct{}{}
+	}
+	// NOTE: キーごとに保存するとカタログ全体を何度も書き直すので、まとめて一度だけ保存する
+	if err := p.catalog.Update(episodes, deletedKeys); err != nil {
+		return errors.Join(append(errs, errors.Wrap(err, "failed to update the catalog"))...)
+	}
+	if len(affected) > 0 {
+		if err := p.syncFeeds(affected); err != nil {
+			errs = append(errs, err)
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		p.logger.Info().Str("path", fpath).Err(err).Msg("found a target file")
+// Rescan はストレージの全てのファイルを読み直してカタログを作り直し、フィードを作り直す。
+// カタログが壊れた場合やストレージを直接変更した場合に使う。
+func (p *Podcaster) Rescan(ctx context.Context) error {
+	p.logger.Info().Msg("Podcaster.Rescan started")
+	defer func() {
+		p.logger.Info().Msg("Podcaster.Rescan ended")
+	}()
+
+	objects, err := p.storage.List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list files")
+	}
+	var episodes []catalog.Episode
+	for _, obj := range objects {
+		p.logger.Info().Str("path", obj.Key).Msg("found a target file")
 
-		if !p.isAudioFile(fpath) {
+		if metadata.IsMetadataKey(obj.Key) || !p.isAudioFile(ctx, obj.Key) {
 			p.logger.Info().
-				Str("path", fpath).
+				Str("path", obj.Key).
 				Msg("skip because the file is not audio file")
-			return nil
+			continue
 		}
+		episodes = append(episodes, p.newCatalogEpisode(ctx, obj))
+	}
+	if err := p.catalog.Replace(episodes); err != nil {
+		return errors.Wrap(err, "failed to replace the catalog")
+	}
+	return p.Sync()
+}
 
-		baseName := filepath.Base(fpath)
-
-		u, err := url.Parse(p.baseURL)
-		if err != nil {
-			return fmt.Errorf("failed to parse baseURL(%s): %w", p.baseURL, err)
+// loadEpisode は key の音声ファイルをストレージから読む。ファイルが無いか音声ファイルでない場合は false を返す。
+func (p *Podcaster) loadEpisode(ctx context.Context, key string) (catalog.Episode, bool, error) {
+	if metadata.IsMetadataKey(key) {
+		return catalog.Episode{}, false, nil
+	}
+	obj, err := p.storage.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return catalog.Episode{}, false, nil
 		}
-		u.Path = path.Join(u.Path, "static", baseName)
+		return catalog.Episode{}, false, errors.Wrap(err, "failed to stat file")
+	}
+	if !p.isAudioFile(ctx, obj.Key) {
+		return catalog.Episode{}, false, nil
+	}
+	return p.newCatalogEpisode(ctx, obj), true, nil
+}
 
-		stat, err := os.Stat(fpath)
-		if err != nil {
-			return err
-		}
-		ep := Episode{
-			Title:         fpath,
-			URL:           u.String(),
-			LengthInBytes: stat.Size(),
-		}
-		if ss := strings.Split(baseName, "_"); len(ss) > 1 {
-			ep.Title = ss[0]
-			if startedAt, _ := time.Parse("200601021504", strings.TrimSuffix(ss[1], filepath.Ext(ss[1]))); err == nil {
-				ep.PublishedAt = &startedAt
-			}
-		}
-		if ep.PublishedAt == nil {
-			now := time.Now()
-			ep.PublishedAt = &now
-		}
+func (p *Podcaster) newCatalogEpisode(ctx context.Context, obj storage.Object) catalog.Episode {
+	ep := catalog.Episode{
+		Key:     obj.Key,
+		Size:    obj.Size,
+		ModTime: obj.ModTime,
+	}
+	// NOTE: メタデータはまだ書かれていないことがあるので、読めなくてもエピソードとしては載せる
+	if md, err := metadata.Read(ctx, p.storage, obj.Key); err == nil {
+		ep.Metadata = &md
+	}
+	return ep
+}
 
-		var podcastPath string
-		// NOTE: メタデータがあればそれで全て上書きする
-		if md, err := metadata.ReadByAudioFilePath(fpath); err == nil {
-			ep.Title = md.Title
-			ep.Description = md.Description
-			ep.PublishedAt = &md.PublishedAt
-			ep.ImageURL = md.ImageURL
-			ep.PodcastTitle = md.PodcastTitle
-
-			// NOTE: `/ann` のような設定を `ann` と同値にしてあげる
-			podcastPath = strings.ToLower(strings.TrimPrefix(md.Path, "/"))
-			if md.ZenrokuMode {
-				podcastPath = path.Join("zenroku", podcastPath)
-			}
-		}
+// Sync はカタログから全てのフィードを作り直す。ストレージは読まない。
+func (p *Podcaster) Sync() error {
+	p.logger.Info().Msg("Podcaster.Sync started")
+	defer func() {
+		p.logger.Info().Msg("Podcaster.Sync ended")
+	}()
+	return p.syncFeeds(nil)
+}
 
+// syncFeeds は feedPaths のフィードと全エピソードのフィードをカタログから作り直す。
+// feedPaths が nil の場合は全てのフィードを作り直す。
+func (p *Podcaster) syncFeeds(feedPaths map[string]struct{}) error {
+	// NOTE: 作り直したフィードを古いフィードに混ぜるので、同時に作り直さないようにする
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	var (
+		allEpisodes         []Episode
+		pathGroupedEpisodes = make(map[string][]Episode)
+	)
+	baseURL, err := url.Parse(p.baseURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse baseURL(%s): %w", p.baseURL, err)
+	}
+	for _, e := range p.catalog.List() {
+		ep := newEpisode(baseURL, e)
+		podcastPath := e.FeedPath()
 		allEpisodes = append(allEpisodes, ep)
 		pathGroupedEpisodes[podcastPath] = append(pathGroupedEpisodes[podcastPath], ep)
+	}
 
-		return nil
-	}); err != nil {
-		return err
+	var cfg config.Config
+	p.mu.RLock()
+	if p.configProvider != nil {
+		cfg = p.configProvider.Config()
 	}
+	p.mu.RUnlock()
 
 	feedMap := make(map[string]string)
+	if feedPaths != nil {
+		p.mu.RLock()
+		for path, feed := range p.feedMap {
+			feedMap[path] = feed
+		}
+		p.mu.RUnlock()
+		// NOTE: エピソードが無くなったフィードは消す
+		for path := range feedPaths {
+			delete(feedMap, path)
+		}
+	}
 
 	encodePodcastToXML := func(podcast *Podcast) (string, error) {
 		buf := bytes.NewBuffer(nil)
@@ -204,6 +371,9 @@ func (p *Podcaster) Sync() error {
 		}
 
 		sortEpisodesByPublishedAtDesc(episodes)
+		if _, ok := feedPaths[path]; feedPaths != nil && !ok {
+			continue
+		}
 		latestEpisode := episodes[0]
 
 		p.logger.Debug().
@@ -224,6 +394,20 @@ func (p *Podcaster) Sync() error {
 			PublishedAt: p.publishedAt,
 			ImageURL:    latestEpisode.ImageURL,
 		}
+		// NOTE: 番組(全録の場合は局)の設定があれば、チャンネルの情報はそちらを使う
+		if ch, imageURL, ok := cfg.ChannelByFeedPath(path); ok {
+			if ch.Description != "" {
+				podcast.Description = ch.Description
+			}
+			if imageURL != "" {
+				podcast.ImageURL = imageURL
+			}
+			podcast.Author = ch.Author
+			podcast.OwnerEmail = ch.OwnerEmail
+			podcast.Category = ch.Category
+			podcast.Language = ch.Language
+			podcast.Explicit = ch.Explicit
+		}
 
 		// NOTE: デフォルトパス(= "")の場合はデフォルト設定にする
 		if path == "" {
@@ -236,7 +420,11 @@ func (p *Podcaster) Sync() error {
 			}
 		}
 
-		podcast.Episodes = episodes
+		tokenized, err := p.tokenizeEpisodes(path, episodes)
+		if err != nil {
+			return errors.Wrapf(err, "path=%s", path)
+		}
+		podcast.Episodes = tokenized
 		feed, err := encodePodcastToXML(podcast)
 		if err != nil {
 			p.logger.Err(err).
@@ -248,6 +436,11 @@ func (p *Podcaster) Sync() error {
 	}
 
 	sortEpisodesByPublishedAtDesc(allEpisodes)
+	pathGroupedEpisodes[feedtoken.AllFeedPath] = allEpisodes
+	allEpisodes, err = p.tokenizeEpisodes(feedtoken.AllFeedPath, allEpisodes)
+	if err != nil {
+		return errors.Wrap(err, "all episodes")
+	}
 	feed, err := encodePodcastToXML(
 		&Podcast{
 			Title:       fmt.Sprintf("%s(ALL)", p.title),
@@ -266,15 +459,95 @@ func (p *Podcaster) Sync() error {
 
 	p.mu.Lock()
 	p.feedMap = feedMap
+	p.episodeMap = pathGroupedEpisodes
 	p.mu.Unlock()
 
 	return nil
 }
 
-func (p *Podcaster) isAudioFile(fpath string) bool {
-	f, err := os.Open(fpath)
+// MergedFeed は複数のフィードのエピソードをまとめたフィードを返す。
+// エンクロージャのURLには token を付ける。
+func (p *Podcaster) MergedFeed(title string, feedPaths []string, token string) (string, error) {
+	var episodes []Episode
+	p.mu.RLock()
+	if slices.Contains(feedPaths, feedtoken.AllFeedPath) {
+		episodes = append(episodes, p.episodeMap[feedtoken.AllFeedPath]...)
+	} else {
+		for _, feedPath := range feedPaths {
+			episodes = append(episodes, p.episodeMap[feedPath]...)
+		}
+	}
+	p.mu.RUnlock()
+
+	sortEpisodesByPublishedAtDesc(episodes)
+	episodes, err := withToken(episodes, token)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := encodeXML(buf, &Podcast{
+		Title:       title,
+		Link:        p.link,
+		Description: p.description,
+		PublishedAt: p.publishedAt,
+		ImageURL:    p.imageURL,
+		Episodes:    episodes,
+	}); err != nil {
+		return "", errors.Wrap(err, "failed to encodeXML")
+	}
+	return buf.String(), nil
+}
+
+// tokenizeEpisodes はフィードのトークンをエンクロージャのURLに付けたエピソードを返す。
+func (p *Podcaster) tokenizeEpisodes(feedPath string, episodes []Episode) ([]Episode, error) {
+	if p.tokens == nil {
+		return episodes, nil
+	}
+	token, err := p.tokens.Token(feedPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get feed token")
+	}
+	return withToken(episodes, token)
+}
+
+func withToken(episodes []Episode, token string) ([]Episode, error) {
+	if token == "" {
+		return episodes, nil
+	}
+	tokenized := make([]Episode, 0, len(episodes))
+	for _, ep := range episodes {
+		u, err := addToken(ep.URL, token)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse episode url: %s", ep.URL)
+		}
+		ep.URL = u
+		if ep.ChaptersURL != "" {
+			u, err := addToken(ep.ChaptersURL, token)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse chapters url: %s", ep.ChaptersURL)
+			}
+			ep.ChaptersURL = u
+		}
+		tokenized = append(tokenized, ep)
+	}
+	return tokenized, nil
+}
+
+func addToken(rawURL, token string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(feedtoken.QueryParam, token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (p *Podcaster) isAudioFile(ctx context.Context, key string) bool {
+	f, err := p.storage.Open(ctx, key)
 	if err != nil {
-		p.logger.Debug().Err(err).Str("path", fpath).
+		p.logger.Debug().Err(err).Str("path", key).
 			Msg("failed to open file for checking audio file")
 		return false
 	}
@@ -282,10 +555,11 @@ func (p *Podcaster) isAudioFile(fpath string) bool {
 
 	// NOTE: 音声ファイルかどうかの判別には先頭20バイトあれば足りる
 	head := make([]byte, 20)
-	if _, err := f.Read(head); err != nil {
-		p.logger.Debug().Err(err).Str("path", fpath).
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		p.logger.Debug().Err(err).Str("path", key).
 			Msg("failed to read first 20 bytes of the file for checking audio file")
 		return false
 	}
-	return filetype.IsAudio(head)
+	return filetype.IsAudio(head[:n])
 }
@@ -0,0 +1,79 @@
+package radikoutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/cockroachdb/errors"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+type liveStreamURLs struct {
+	XMLName xml.Name `xml:"urls"`
+	URLs    []struct {
+		AreaFree          bool   `xml:"areafree,attr"`
+		TimeFree          bool   `xml:"timefree,attr"`
+		PlaylistCreateURL string `xml:"playlist_create_url"`
+	} `xml:"url"`
+}
+
+// LivePlaylistM3U8 はライブのプレイリストのURIを返す。
+// プレイリストの取得には client.AuthToken() を X-Radiko-AuthToken ヘッダーに付ける必要がある。
+// areaFree はエリア外の局をエリアフリー(プレミアム会員)で聴く場合に指定する。
+func LivePlaylistM3U8(ctx context.Context, client *radiko.Client, stationID string, areaFree bool) (string, error) {
+	u := *client.URL
+	u.Path = path.Join(client.URL.Path, "v3/station/stream/pc_html5", stationID+".xml")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request stream urls")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Newf("status code is not 200: %d", resp.StatusCode)
+	}
+
+	var urls liveStreamURLs
+	if err := xml.NewDecoder(resp.Body).Decode(&urls); err != nil {
+		return "", errors.Wrap(err, "failed to decode stream urls")
+	}
+	for _, item := range urls.URLs {
+		if item.TimeFree || item.AreaFree != areaFree || item.PlaylistCreateURL == "" {
+			continue
+		}
+		lsid, err := newListenerSessionID()
+		if err != nil {
+			return "", err
+		}
+		playlist, err := url.Parse(item.PlaylistCreateURL)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to parse playlist url")
+		}
+		playlist.RawQuery = url.Values{
+			"station_id": {stationID},
+			"l":          {"15"},
+			"lsid":       {lsid},
+			"type":       {"b"},
+		}.Encode()
+		return playlist.String(), nil
+	}
+	return "", errors.Newf("live stream is not found: station_id=%s, areafree=%t", stationID, areaFree)
+}
+
+// newListenerSessionID はブラウザのプレイヤーと同じく、ランダムな32桁の16進数を返す。
+func newListenerSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate lsid")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,78 @@
+package radikoutil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+const musicAPITimeLayout = "2006-01-02T15:04:05"
+
+// Music は局で流れた曲。
+type Music struct {
+	Title     string
+	Artist    string
+	StartedAt time.Time
+}
+
+type noaResponse struct {
+	Data []struct {
+		Title              string `json:"title"`
+		ArtistName         string `json:"artist_name"`
+		DisplayedStartTime string `json:"displayed_start_time"`
+	} `json:"data"`
+}
+
+// Musics は from から to までに局で流れた曲を開始時刻の順に返す。
+// NOTE: 楽曲の情報は api.radiko.jp にあるので、エンドポイントを差し替えていない場合だけホストを変える
+func Musics(ctx context.Context, client *radiko.Client, stationID string, from, to time.Time) ([]Music, error) {
+	u := *client.URL
+	if u.Host == "radiko.jp" {
+		u.Host = "api.radiko.jp"
+	}
+	u.Path = path.Join(client.URL.Path, "music/api/v1/noas", stationID)
+	q := u.Query()
+	q.Set("start_time_gte", from.In(timeutil.JST()).Format(musicAPITimeLayout))
+	q.Set("end_time_lt", to.In(timeutil.JST()).Format(musicAPITimeLayout))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request musics")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Newf("status code is not 200: %d", resp.StatusCode)
+	}
+
+	var res noaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Wrap(err, "failed to decode musics")
+	}
+	musics := make([]Music, 0, len(res.Data))
+	for _, d := range res.Data {
+		startedAt, err := time.Parse(time.RFC3339, d.DisplayedStartTime)
+		if err != nil {
+			continue
+		}
+		if startedAt.Before(from) || !startedAt.Before(to) {
+			continue
+		}
+		musics = append(musics, Music{Title: d.Title, Artist: d.ArtistName, StartedAt: startedAt})
+	}
+	sort.SliceStable(musics, func(i, j int) bool {
+		return musics[i].StartedAt.Before(musics[j].StartedAt)
+	})
+	return musics, nil
+}
@@ -0,0 +1,43 @@
+package radikotest
+
+import "time"
+
+const (
+	aacSampleRate      = 44100
+	aacSamplesPerFrame = 1024
+	// NOTE: ADTSのサンプリング周波数のインデックスで、4が44.1kHz
+	aacSampleRateIndex = 4
+)
+
+// silentMonoFrame はAAC-LCのモノラルの無音の raw_data_block。
+// SCE(global_gain=160, max_sfb=0)とENDだけからなる。
+var silentMonoFrame = []byte{0x01, 0x40, 0x20, 0x07}
+
+// silentAAC は d の長さの無音のADTSストリームを返す。
+func silentAAC(d time.Duration) []byte {
+	frames := int(d.Seconds() * aacSampleRate / aacSamplesPerFrame)
+	frameLen := 7 + len(silentMonoFrame)
+	b := make([]byte, 0, frames*frameLen)
+	for i := 0; i < frames; i++ {
+		b = append(b, adtsHeader(frameLen)...)
+		b = append(b, silentMonoFrame...)
+	}
+	return b
+}
+
+// adtsHeader はCRCなしのAAC-LC、44.1kHz、モノラルのADTSヘッダーを返す。
+func adtsHeader(frameLen int) []byte {
+	const (
+		profile       = 1 // AAC-LC
+		channelConfig = 1
+	)
+	return []byte{
+		0xFF,
+		0xF1, // MPEG-4, layer 0, protection absent
+		byte((profile << 6) | (aacSampleRateIndex << 2) | (channelConfig >> 2)),
+		byte(((channelConfig & 0x3) << 6) | ((frameLen >> 11) & 0x3)),
+		byte((frameLen >> 3) & 0xFF),
+		byte(((frameLen & 0x7) << 5) | 0x1F),
+		0xFC, // buffer fullness 0x7FF, 1 raw data block
+	}
+}
@@ -0,0 +1,386 @@
+// Package radikotest はテスト用の偽のradikoサーバーを提供する。
+// 認証、エリア、番組表、楽曲、タイムフリーとライブのプレイリストと無音のAACのチャンクを返す。
+package radikotest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yyoshiki41/go-radiko"
+)
+
+const (
+	// AreaID は偽のサーバーが返す現在のエリアID。
+	AreaID = "JP13"
+	// AuthToken は auth1 で払い出す認証トークン。
+	AuthToken = "radikotest-auth-token"
+
+	// ChunkDuration はプレイリストの1チャンクあたりの長さ。
+	ChunkDuration = 5 * time.Second
+	// LiveDuration はライブのプレイリストが返す長さ。
+	// NOTE: テストが終わるように、ライブでも終わりのあるチャンクリストを返す
+	LiveDuration = 30 * time.Second
+
+	datetimeLayout = "20060102150405"
+	dateLayout     = "20060102"
+)
+
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// Prog は番組表の1番組。
+type Prog = radiko.Prog
+
+// Station は偽のサーバーが配信する局と番組表と流れた曲。
+type Station struct {
+	ID     string
+	Name   string
+	Progs  []Prog
+	Musics []Music
+}
+
+// Music は局で流れた曲。
+type Music struct {
+	Title     string
+	Artist    string
+	StartedAt time.Time
+}
+
+// Server は httptest.Server で動く偽のradikoサーバー。
+type Server struct {
+	*httptest.Server
+
+	mu       sync.RWMutex
+	stations []Station
+
+	chunkRequests atomic.Int64
+
+	// timeshiftStatuses は番組の開始時刻ごとに、タイムフリーのプレイリストの代わりに返すステータスコード。
+	timeshiftStatuses map[string]int
+}
+
+// NewServer は stations を配信する偽のradikoサーバーを起動する。
+// 使い終わったら Close する。
+func NewServer(stations ...Station) *Server {
+	installAreaTransportOnce.Do(installAreaTransport)
+	s := &Server{stations: stations}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/area", s.handleArea)
+	mux.HandleFunc("/v2/api/auth1", s.handleAuth1)
+	mux.HandleFunc("/v2/api/auth2", s.handleAuth2)
+	mux.HandleFunc("/v3/program/date/", s.handleProgramDate)
+	mux.HandleFunc("/v3/program/station/weekly/", s.handleProgramWeekly)
+	mux.HandleFunc("/v2/api/ts/playlist.m3u8", s.handleTimeshiftPlaylist)
+	mux.HandleFunc("/music/api/v1/noas/", s.handleMusics)
+	mux.HandleFunc("/v3/station/stream/pc_html5/", s.handleLiveStreamURLs)
+	mux.HandleFunc("/radikotest/live/playlist.m3u8", s.handleLivePlaylist)
+	mux.HandleFunc("/radikotest/chunklist.m3u8", s.handleChunklist)
+	mux.HandleFunc("/radikotest/chunk.aac", s.handleChunk)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+var installAreaTransportOnce sync.Once
+
+// installAreaTransport は radiko.New の中で呼ばれる radiko.AreaID のリクエストに、ネットワークに出ずに応答する。
+// NOTE: radiko.AreaID は http.Get で固定のURLを叩くので、テストのプロセスに限って http.DefaultClient を差し替える。
+// それ以外のリクエストは元のトランスポートに渡す
+func installAreaTransport() {
+	base := http.DefaultClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	http.DefaultClient.Transport = areaTransport{base: base}
+}
+
+type areaTransport struct {
+	base http.RoundTripper
+}
+
+func (t areaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "radiko.jp" || req.URL.Path != "/area" {
+		return t.base.RoundTrip(req)
+	}
+	rec := httptest.NewRecorder()
+	writeArea(rec)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// AddProgram は局の番組表に番組を追加する。局がなければ作る。
+func (s *Server) AddProgram(stationID string, prog Prog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.stations {
+		if s.stations[i].ID == stationID {
+			s.stations[i].Progs = append(s.stations[i].Progs, prog)
+			return
+		}
+	}
+	s.stations = append(s.stations, Station{ID: stationID, Name: stationID, Progs: []Prog{prog}})
+}
+
+// AddMusic は局で流れた曲を追加する。局がなければ作る。
+func (s *Server) AddMusic(stationID string, music Music) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.stations {
+		if s.stations[i].ID == stationID {
+			s.stations[i].Musics = append(s.stations[i].Musics, music)
+			return
+		}
+	}
+	s.stations = append(s.stations, Station{ID: stationID, Name: stationID, Musics: []Music{music}})
+}
+
+// SetTimeshiftStatus は stationID の from に始まる時間帯のタイムフリーのプレイリストを、status のエラーにする。
+// 404 でタイムフリーで配信されていない番組を、500 で一時的な障害を再現する。
+func (s *Server) SetTimeshiftStatus(stationID string, from time.Time, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timeshiftStatuses == nil {
+		s.timeshiftStatuses = make(map[string]int)
+	}
+	s.timeshiftStatuses[stationID+"/"+from.In(jst).Format(datetimeLayout)] = status
+}
+
+// ChunkRequests はこれまでにチャンクが取得された回数を返す。
+func (s *Server) ChunkRequests() int {
+	return int(s.chunkRequests.Load())
+}
+
+// NewProg は from から d の長さの番組を作る。
+func NewProg(title string, from time.Time, d time.Duration) Prog {
+	from = from.In(jst)
+	to := from.Add(d)
+	return radiko.Prog{
+		Ft:    from.Format(datetimeLayout),
+		To:    to.Format(datetimeLayout),
+		Ftl:   from.Format("1504"),
+		Tol:   to.Format("1504"),
+		Dur:   strconv.Itoa(int(d.Seconds())),
+		Title: title,
+	}
+}
+
+func (s *Server) handleArea(w http.ResponseWriter, _ *http.Request) {
+	writeArea(w)
+}
+
+func writeArea(w http.ResponseWriter) {
+	fmt.Fprintf(w, `document.write('<span class="%s">TOKYO JAPAN</span>');`, AreaID)
+}
+
+func (s *Server) handleAuth1(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("X-Radiko-AuthToken", AuthToken)
+	w.Header().Set("X-Radiko-KeyLength", "16")
+	w.Header().Set("X-Radiko-KeyOffset", "0")
+}
+
+func (s *Server) handleAuth2(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Radiko-AuthToken") != AuthToken {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprintf(w, "%s,東京都,tokyo Japan\r\n", AreaID)
+}
+
+// handleProgramDate は /v3/program/date/{YYYYMMDD}/{area_id}.xml を返す。
+// radikoの番組表は5時から翌日の5時までを1日として扱う。
+func (s *Server) handleProgramDate(w http.ResponseWriter, r *http.Request) {
+	date, err := time.ParseInLocation(dateLayout, strings.Split(strings.TrimPrefix(r.URL.Path, "/v3/program/date/"), "/")[0], jst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	start := date.Add(5 * time.Hour)
+	end := start.AddDate(0, 0, 1)
+	// NOTE: 本物の番組表と同じく、番組がなくても全ての局を返す
+	s.writeStations(w, date, func(Station) bool { return true }, func(from time.Time) bool {
+		return !from.Before(start) && from.Before(end)
+	})
+}
+
+// handleProgramWeekly は /v3/program/station/weekly/{station_id}.xml を返す。
+func (s *Server) handleProgramWeekly(w http.ResponseWriter, r *http.Request) {
+	stationID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v3/program/station/weekly/"), ".xml")
+	s.writeStations(w, time.Now().In(jst), func(st Station) bool {
+		return st.ID == stationID
+	}, func(time.Time) bool { return true })
+}
+
+// handleMusics は /music/api/v1/noas/{station_id} で start_time_gte から end_time_lt までに流れた曲を返す。
+func (s *Server) handleMusics(w http.ResponseWriter, r *http.Request) {
+	stationID := strings.TrimPrefix(r.URL.Path, "/music/api/v1/noas/")
+	from, err := time.ParseInLocation("2006-01-02T15:04:05", r.URL.Query().Get("start_time_gte"), jst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := time.ParseInLocation("2006-01-02T15:04:05", r.URL.Query().Get("end_time_lt"), jst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	type music struct {
+		Title              string `json:"title"`
+		ArtistName         string `json:"artist_name"`
+		DisplayedStartTime string `json:"displayed_start_time"`
+	}
+	res := struct {
+		Data []music `json:"data"`
+	}{Data: []music{}}
+	for _, st := range s.stations {
+		if st.ID != stationID {
+			continue
+		}
+		for _, m := range st.Musics {
+			if m.StartedAt.Before(from) || !m.StartedAt.Before(to) {
+				continue
+			}
+			res.Data = append(res.Data, music{
+				Title:              m.Title,
+				ArtistName:         m.Artist,
+				DisplayedStartTime: m.StartedAt.In(jst).Format(time.RFC3339),
+			})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+type stationsXML struct {
+	XMLName  xml.Name `xml:"radiko"`
+	Stations struct {
+		Stations []stationXML `xml:"station"`
+	} `xml:"stations"`
+}
+
+type stationXML struct {
+	ID    string `xml:"id,attr"`
+	Name  string `xml:"name"`
+	Progs struct {
+		Date  string `xml:"date"`
+		Progs []Prog `xml:"prog"`
+	} `xml:"progs"`
+}
+
+func (s *Server) writeStations(w http.ResponseWriter, date time.Time, includeStation func(st Station) bool, includeProg func(from time.Time) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var res stationsXML
+	for _, st := range s.stations {
+		if !includeStation(st) {
+			continue
+		}
+		sx := stationXML{ID: st.ID, Name: st.Name}
+		sx.Progs.Date = date.Format(dateLayout)
+		for _, prog := range st.Progs {
+			from, err := time.ParseInLocation(datetimeLayout, prog.Ft, jst)
+			if err != nil || !includeProg(from) {
+				continue
+			}
+			sx.Progs.Progs = append(sx.Progs.Progs, prog)
+		}
+		res.Stations.Stations = append(res.Stations.Stations, sx)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) handleTimeshiftPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Radiko-AuthToken") != AuthToken {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	from, err1 := time.ParseInLocation(datetimeLayout, q.Get("ft"), jst)
+	to, err2 := time.ParseInLocation(datetimeLayout, q.Get("to"), jst)
+	if err1 != nil || err2 != nil || !to.After(from) {
+		http.Error(w, "invalid ft or to", http.StatusBadRequest)
+		return
+	}
+	s.mu.RLock()
+	status, ok := s.timeshiftStatuses[q.Get("station_id")+"/"+q.Get("ft")]
+	s.mu.RUnlock()
+	if ok {
+		http.Error(w, "", status)
+		return
+	}
+	chunklist := s.URL + "/radikotest/chunklist.m3u8?" + url.Values{
+		"station_id": {q.Get("station_id")},
+		"ft":         {q.Get("ft")},
+		"to":         {q.Get("to")},
+	}.Encode()
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-STREAM-INF:BANDWIDTH=52973,CODECS=\"mp4a.40.5\"\n%s\n", chunklist)
+}
+
+// handleLiveStreamURLs は /v3/station/stream/pc_html5/{station_id}.xml を返す。
+func (s *Server) handleLiveStreamURLs(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `%s<urls>
+<url areafree="0" max_delay="100" timefree="0"><playlist_create_url>%s/radikotest/live/playlist.m3u8</playlist_create_url></url>
+<url areafree="1" max_delay="100" timefree="0"><playlist_create_url>%s/radikotest/live/playlist.m3u8</playlist_create_url></url>
+<url areafree="0" max_delay="100" timefree="1"><playlist_create_url>%s/v2/api/ts/playlist.m3u8</playlist_create_url></url>
+</urls>`, xml.Header, s.URL, s.URL, s.URL)
+}
+
+// handleLivePlaylist はライブのプレイリストとして、今から LiveDuration の長さのチャンクリストを返す。
+func (s *Server) handleLivePlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Radiko-AuthToken") != AuthToken {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	from := time.Now().In(jst)
+	chunklist := s.URL + "/radikotest/chunklist.m3u8?" + url.Values{
+		"station_id": {r.URL.Query().Get("station_id")},
+		"ft":         {from.Format(datetimeLayout)},
+		"to":         {from.Add(LiveDuration).Format(datetimeLayout)},
+	}.Encode()
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-STREAM-INF:BANDWIDTH=52973,CODECS=\"mp4a.40.5\"\n%s\n", chunklist)
+}
+
+func (s *Server) handleChunklist(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err1 := time.ParseInLocation(datetimeLayout, q.Get("ft"), jst)
+	to, err2 := time.ParseInLocation(datetimeLayout, q.Get("to"), jst)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid ft or to", http.StatusBadRequest)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:1\n", int(ChunkDuration.Seconds()))
+	for t := from; t.Before(to); t = t.Add(ChunkDuration) {
+		fmt.Fprintf(&b, "#EXTINF:%d,\n%s/radikotest/chunk.aac?%s\n",
+			int(ChunkDuration.Seconds()),
+			s.URL,
+			url.Values{"station_id": {q.Get("station_id")}, "t": {t.Format(datetimeLayout)}}.Encode(),
+		)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Write([]byte(b.String()))
+}
+
+func (s *Server) handleChunk(w http.ResponseWriter, _ *http.Request) {
+	s.chunkRequests.Add(1)
+	w.Header().Set("Content-Type", "audio/aac")
+	w.Write(silentAAC(ChunkDuration))
+}
@@ -2,20 +2,30 @@ package radikoutil
 
 import (
 	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/yyoshiki41/go-radiko"
+	"golang.org/x/net/html"
 )
 
 // https://github.com/uru2/rec_radiko_ts/blob/3b2f334cf05b6616ba6e87a1bee8d82e7d2df86c/rec_radiko_ts.sh#L10-L11
 const defaultAuthToken = "bcd151073c03b352e1ef2fd66c32209da9ca0afa"
 
+// httpTimeout は radiko.Client の http.Client のタイムアウト。go-radiko の既定値に合わせる。
+const httpTimeout = 120 * time.Second
+
 type Option func(o *options)
 
 type options struct {
 	areaID          string
 	isPremium       bool
 	email, password string
+	endpoint        *url.URL
 }
 
 func evaluateOptions(opts []Option) *options {
@@ -34,23 +44,44 @@ func WithPremium(email, password string) Option {
 	}
 }
 
+// WithAreaID は番組表を取得するエリアを指定する。プレミアム会員でエリア外の場合はログインする。
 func WithAreaID(areaID string) Option {
 	return func(o *options) {
 		o.areaID = areaID
 	}
 }
 
+// WithEndpoint はradikoのAPIのベースURLを差し替える。空文字の場合は本物のradikoを使う。
+// テストで偽のradikoサーバーに向けるために使う。
+// NOTE: radiko.New の中の radiko.AreaID だけは固定のURLを叩くので、テストでは radikotest が応答する
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) {
+		if endpoint == "" {
+			return
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return
+		}
+		o.endpoint = u
+	}
+}
+
 func NewClient(ctx context.Context, opts ...Option) (*radiko.Client, error) {
 	opt := evaluateOptions(opts)
-	c, err := radiko.New(defaultAuthToken)
+	c, err := newRadikoClient()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to construct a radiko Client")
 	}
+	if opt.endpoint != nil {
+		c.URL = opt.endpoint
+	}
 
-	currentAreaID, err := radiko.AreaID()
+	currentAreaID, err := AreaID(ctx, c)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get current areaID via API")
 	}
+	c.SetAreaID(currentAreaID)
 
 	// NOTE: プレミアム会員かつ、現在のエリア外の時はログインする
 	if opt.isPremium && !isCurrentAreaID(currentAreaID, opt.areaID) {
@@ -61,6 +92,9 @@ func NewClient(ctx context.Context, opts ...Option) (*radiko.Client, error) {
 		if status.StatusCode() != 200 {
 			return nil, errors.Errorf("failed to login to radiko: %d", status.StatusCode())
 		}
+	}
+	// NOTE: エリアIDは番組表を取得するエリアに使われるので、ログインしない場合も指定したエリアにする
+	if opt.areaID != "" {
 		c.SetAreaID(opt.areaID)
 	}
 
@@ -71,6 +105,19 @@ func NewClient(ctx context.Context, opts ...Option) (*radiko.Client, error) {
 	return c, nil
 }
 
+// newRadikoClientMu は radiko.New が読むパッケージ変数の http.Client を差し替える間のロック。
+var newRadikoClientMu sync.Mutex
+
+// newRadikoClient はクライアントごとに専用の http.Client を持つ radiko.Client を作る。
+// NOTE: radiko.New はパッケージ変数の http.Client を共有して Cookie Jar を上書きするので、
+// 作るたびに新しい http.Client を渡して、他のクライアントのログイン状態を壊さないようにする
+func newRadikoClient() (*radiko.Client, error) {
+	newRadikoClientMu.Lock()
+	defer newRadikoClientMu.Unlock()
+	radiko.SetHTTPClient(&http.Client{Timeout: httpTimeout})
+	return radiko.New(defaultAuthToken)
+}
+
 func isCurrentAreaID(currentAreaID, areaID string) bool {
 	// NOTE: 未指定の時はエリア内
 	if areaID == "" {
@@ -78,3 +125,38 @@ func isCurrentAreaID(currentAreaID, areaID string) bool {
 	}
 	return currentAreaID == areaID
 }
+
+// AreaID は現在のエリアIDを client のエンドポイントから取得する。
+// radiko.AreaID はURLが固定なので、エンドポイントを差し替えられるようにこちらを使う。
+func AreaID(ctx context.Context, client *radiko.Client) (string, error) {
+	u := *client.URL
+	u.Path = strings.TrimSuffix(client.URL.Path, "/") + "/area"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request area")
+	}
+	defer resp.Body.Close()
+
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse area")
+	}
+	return findAreaID(doc), nil
+}
+
+// findAreaID は `<span class="JP13">TOKYO JAPAN</span>` からエリアIDを取り出す。
+func findAreaID(n *html.Node) string {
+	if n.Type == html.ElementNode && n.Data == "span" && len(n.Attr) > 0 {
+		return n.Attr[0].Val
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if areaID := findAreaID(c); areaID != "" {
+			return areaID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package radikoutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/upamune/radicaster/radikoutil/radikotest"
+)
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+
+	srv := radikotest.NewServer()
+	defer srv.Close()
+
+	ctx := context.Background()
+	c1, err := NewClient(ctx, WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+	c2, err := NewClient(ctx, WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+
+	if got := c1.AreaID(); got != radikotest.AreaID {
+		t.Errorf("AreaID() = %q, want %q", got, radikotest.AreaID)
+	}
+	// NOTE: クライアントごとに http.Client を持つので、Cookie Jar も共有しない
+	if c1.Jar() == c2.Jar() {
+		t.Error("clients should not share a cookie jar")
+	}
+}
@@ -0,0 +1,64 @@
+package radikoutil
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/grafov/m3u8"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+const radikoDatetimeLayout = "20060102150405"
+
+// ErrTimeshiftUnavailable は権利の都合などで、その時間帯がタイムフリーで配信されていないことを表す。
+// NOTE: 何度やり直しても取得できないので、一時的なエラーと区別する
+var ErrTimeshiftUnavailable = errors.New("timeshift is not available")
+
+// TimeshiftPlaylistM3U8 は任意の時間帯のタイムフリーのプレイリストのURIを返す。
+// radiko.Client.TimeshiftPlaylistM3U8 は番組の開始時刻からしか取得できないので、
+// 番組をまたいだり途中から録音する場合はこちらを使う。
+func TimeshiftPlaylistM3U8(ctx context.Context, client *radiko.Client, stationID string, from, to time.Time) (string, error) {
+	u := *client.URL
+	u.Path = path.Join(client.URL.Path, "v2/api/ts/playlist.m3u8")
+	u.RawQuery = url.Values{
+		"station_id": {stationID},
+		"ft":         {from.In(timeutil.JST()).Format(radikoDatetimeLayout)},
+		"to":         {to.In(timeutil.JST()).Format(radikoDatetimeLayout)},
+		"l":          {"15"},
+	}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("pragma", "no-cache")
+	req.Header.Set("X-Radiko-AuthToken", client.AuthToken())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request playlist")
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusNotFound:
+		return "", errors.Wrapf(ErrTimeshiftUnavailable, "status code is not 200: %d", resp.StatusCode)
+	default:
+		return "", errors.Newf("status code is not 200: %d", resp.StatusCode)
+	}
+
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode m3u8")
+	}
+	p, ok := playlist.(*m3u8.MasterPlaylist)
+	if listType != m3u8.MASTER || !ok || len(p.Variants) == 0 || p.Variants[0] == nil {
+		return "", errors.New("invalid m3u8 format")
+	}
+	return p.Variants[0].URI, nil
+}
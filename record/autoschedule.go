@@ -0,0 +1,319 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/radikoutil"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+const (
+	// NOTE: 放送が終わってすぐはタイムフリーで聴けないことがあるので少し待つ
+	defaultRecordDelay = 10 * time.Minute
+	// NOTE: 延長などで番組表が変わったら録音する時刻をずらせるように、番組表を定期的に見直す
+	autoScheduleRefreshInterval = 30 * time.Minute
+	// NOTE: 前の番組の延長で開始が遅れた放送も、同じ名前ならこの時間までは同じ枠の放送とみなす
+	autoScheduleShiftTolerance = 3 * time.Hour
+)
+
+// WithRecordDelay は cron を省略した番組を、放送が終わってからどれだけ後に録音するかを指定する。
+func WithRecordDelay(d time.Duration) Option {
+	return func(r *Recorder) {
+		r.recordDelay = d
+	}
+}
+
+// broadcast は番組表で見つかった番組の1回の放送を表す。
+type broadcast struct {
+	program config.Program
+	// slot は設定の曜日と開始時刻の放送枠。延長で開始が遅れると from と異なる。
+	slot time.Time
+	from time.Time
+	to   time.Time
+}
+
+// key は放送枠ごとに一意なキーを返す。開始が遅れても同じ録音として扱えるように、放送枠の時刻を使う。
+func (b broadcast) key() string {
+	return fmt.Sprintf("%s/%s", autoProgramKey(b.program), b.slot.Format("200601021504"))
+}
+
+func autoProgramKey(p config.Program) string {
+	return fmt.Sprintf("%s/%s", p.StationID, p.Title)
+}
+
+// autoSchedules は cron を省略した番組の、放送ごとの録音のタイマーを持つ。
+type autoSchedules struct {
+	mu     sync.Mutex
+	timers map[string]*autoTimer
+}
+
+type autoTimer struct {
+	program string
+	runAt   time.Time
+	timer   *time.Timer
+}
+
+// set は放送 b の録音を runAt に実行するように設定する。同じ時刻で設定済みなら何もしない。
+func (s *autoSchedules) set(b broadcast, runAt time.Time, f func()) bool {
+	key := b.key()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timers == nil {
+		s.timers = make(map[string]*autoTimer)
+	}
+	if t, ok := s.timers[key]; ok {
+		if t.runAt.Equal(runAt) {
+			return false
+		}
+		t.timer.Stop()
+	}
+	s.timers[key] = &autoTimer{program: autoProgramKey(b.program), runAt: runAt, timer: time.AfterFunc(time.Until(runAt), f)}
+	return true
+}
+
+func (s *autoSchedules) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.timers, key)
+}
+
+// retain は keys に含まれない、まだ実行していない録音を取り消す。
+// keepPrograms の番組の録音は、番組表を確かめられなかったのでそのまま残す。
+func (s *autoSchedules) retain(keys, keepPrograms map[string]struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, t := range s.timers {
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		if _, ok := keepPrograms[t.program]; ok {
+			continue
+		}
+		t.timer.Stop()
+		delete(s.timers, key)
+	}
+}
+
+func (s *autoSchedules) stopAll() {
+	s.retain(nil, nil)
+}
+
+// runAt は設定済みの録音の時刻を返す。
+func (s *autoSchedules) runAt(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.timers[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return t.runAt, true
+}
+
+// scheduleAutoRecordings は cron を省略した番組の放送を番組表から探して、終了時刻の少し後に録音するように設定する。
+// 番組表が変わって終了時刻がずれた場合は、録音する時刻も設定し直す。
+func (r *Recorder) scheduleAutoRecordings() {
+	var programs []config.Program
+	for _, p := range r.Config().Programs {
+		if p.Cron == "" && !p.IsLive() {
+			programs = append(programs, p)
+		}
+	}
+	if len(programs) == 0 {
+		r.autoSchedules.stopAll()
+		return
+	}
+
+	ctx := context.Background()
+	// NOTE: 終わってから録音するまで待っている放送も見直す対象に含めないと、その録音が取り消されてしまう
+	since := time.Now().Add(-r.recordDelay)
+	broadcasts, failed, err := r.findBroadcasts(ctx, programs, since)
+	if err != nil {
+		// NOTE: 番組表が取れなかった番組は設定済みの録音を消さずに、取れた番組だけ設定し直す
+		r.logger.Error().Err(err).Msg("failed to find broadcasts for some programs without cron")
+	}
+
+	keys := make(map[string]struct{}, len(broadcasts))
+	for _, b := range broadcasts {
+		b := b
+		keys[b.key()] = struct{}{}
+		runAt := b.to.Add(r.recordDelay)
+		if r.autoSchedules.set(b, runAt, func() { r.runAutoRecording(b) }) {
+			r.logger.Info().
+				Str("program_title", b.program.Title).
+				Str("station_id", b.program.StationID).
+				Time("from", b.from).
+				Time("to", b.to).
+				Time("run_at", runAt).
+				Msg("scheduled recording from the program guide")
+		}
+	}
+	r.autoSchedules.retain(keys, failed)
+}
+
+// runAutoRecording は放送を録音する。番組表で終了時刻が延びていたら録音を後ろにずらす。
+func (r *Recorder) runAutoRecording(b broadcast) {
+	logger := r.logger.With().
+		Str("program_title", b.program.Title).
+		Str("station_id", b.program.StationID).
+		Time("from", b.from).
+		Logger()
+
+	if latest, _, err := r.findBroadcasts(context.Background(), []config.Program{b.program}, b.from); err != nil {
+		logger.Warn().Err(err).Msg("failed to check the program guide before recording")
+	} else {
+		for _, l := range latest {
+			if l.key() != b.key() || !l.to.After(b.to) {
+				continue
+			}
+			runAt := l.to.Add(r.recordDelay)
+			r.autoSchedules.set(l, runAt, func() { r.runAutoRecording(l) })
+			logger.Info().Time("to", l.to).Time("run_at", runAt).Msg("postponed recording because the broadcast was extended")
+			return
+		}
+	}
+	r.autoSchedules.remove(b.key())
+
+	// NOTE: この放送だけを録音するように、実際の曜日と時刻の番組にする
+	p := b.program
+	p.Weekdays = []timeutil.Weekday{timeutil.Weekday(b.from.Weekday())}
+	p.Start = b.from.Format("1504")
+	if err := r.Record(p); err != nil {
+		logger.Error().Err(err).Msg("failed to record the program scheduled from the program guide")
+	}
+}
+
+// findBroadcasts は番組の、since 以降に終わる放送を週間番組表から探す。
+// 番組表を取得できなかった番組は、エラーと一緒に failed で返す。
+func (r *Recorder) findBroadcasts(ctx context.Context, programs []config.Program, since time.Time) (broadcasts []broadcast, failed map[string]struct{}, err error) {
+	type guideKey struct{ areaID, stationID string }
+	guides := make(map[guideKey]radiko.Stations)
+
+	failed = make(map[string]struct{})
+	var errs []error
+	for _, p := range programs {
+		weekdays, start, err := p.Schedule()
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "program_title=%s", p.Title))
+			continue
+		}
+		key := guideKey{areaID: p.AreaID, stationID: p.StationID}
+		guide, ok := guides[key]
+		if !ok {
+			guide, err = r.weeklyPrograms(ctx, p.AreaID, p.StationID)
+			if err != nil {
+				failed[autoProgramKey(p)] = struct{}{}
+				errs = append(errs, errors.Wrapf(err, "station_id=%s", p.StationID))
+				continue
+			}
+			guides[key] = guide
+		}
+		for _, station := range guide {
+			if !strings.EqualFold(station.ID, p.StationID) {
+				continue
+			}
+			found, err := matchBroadcasts(p, weekdays, start, station.Progs.Progs, since)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			broadcasts = append(broadcasts, found...)
+		}
+	}
+	return broadcasts, failed, errors.Join(errs...)
+}
+
+// matchBroadcasts は番組表の progs から、設定の曜日と開始時刻の放送枠で放送される番組を探す。
+// 開始時刻が放送枠と同じ番組か、前の番組の延長で開始が遅れた同じ名前の番組を、その枠の放送とする。
+func matchBroadcasts(p config.Program, weekdays []timeutil.Weekday, start string, progs []radiko.Prog, since time.Time) ([]broadcast, error) {
+	clock, err := time.ParseInLocation("1504", start, timeutil.JST())
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid start: %s", start)
+	}
+	var (
+		errs    []error
+		matched = make(map[time.Time]broadcast)
+	)
+	for _, prog := range progs {
+		prog := prog
+		from, to, err := ParseProgTime(&prog)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !to.After(since) {
+			continue
+		}
+		slot, ok := broadcastSlot(from, clock, weekdays)
+		if !ok {
+			continue
+		}
+		shifted := !from.Equal(slot)
+		if shifted && !sameProgramTitle(prog.Title, p.Title) {
+			continue
+		}
+		// NOTE: 同じ枠に複数の番組が当てはまる場合は、時刻通りの番組か最も早く始まる番組にする
+		if m, ok := matched[slot]; ok && (m.from.Equal(slot) || !from.Before(m.from)) {
+			continue
+		}
+		matched[slot] = broadcast{program: p, slot: slot, from: from, to: to}
+	}
+	broadcasts := make([]broadcast, 0, len(matched))
+	for _, b := range matched {
+		broadcasts = append(broadcasts, b)
+	}
+	sort.Slice(broadcasts, func(i, j int) bool {
+		return broadcasts[i].slot.Before(broadcasts[j].slot)
+	})
+	return broadcasts, errors.Join(errs...)
+}
+
+// broadcastSlot は from に始まる放送が属する放送枠の時刻を返す。
+// 放送枠は clock の時刻で weekdays のいずれかの曜日にあり、from はその枠から autoScheduleShiftTolerance 以内に始まる。
+func broadcastSlot(from, clock time.Time, weekdays []timeutil.Weekday) (time.Time, bool) {
+	for _, day := range []int{0, -1} {
+		d := from.AddDate(0, 0, day)
+		slot := time.Date(d.Year(), d.Month(), d.Day(), clock.Hour(), clock.Minute(), 0, 0, timeutil.JST())
+		if from.Before(slot) || from.Sub(slot) > autoScheduleShiftTolerance {
+			continue
+		}
+		for _, wd := range weekdays {
+			if time.Weekday(wd) == slot.Weekday() {
+				return slot, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// sameProgramTitle は番組表の番組名が設定の番組名と同じ番組を指すかを返す。
+// NOTE: 番組表の番組名には回ごとのサブタイトルが付くことがあるので、含まれていれば同じとみなす
+func sameProgramTitle(guideTitle, title string) bool {
+	guideTitle, title = strings.TrimSpace(guideTitle), strings.TrimSpace(title)
+	if guideTitle == "" || title == "" {
+		return false
+	}
+	return strings.Contains(guideTitle, title) || strings.Contains(title, guideTitle)
+}
+
+func (r *Recorder) weeklyPrograms(ctx context.Context, areaID, stationID string) (radiko.Stations, error) {
+	client, err := r.NewRadikoClient(
+		ctx,
+		radikoutil.WithAreaID(areaID),
+		radikoutil.WithPremium(r.radikoEmail, r.radikoPassword),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create radiko client")
+	}
+	stations, err := client.GetWeeklyPrograms(ctx, stationID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get weekly programs")
+	}
+	return stations, nil
+}
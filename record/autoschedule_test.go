@@ -0,0 +1,110 @@
+package record
+
+import (
+	"testing"
+	"time"
+
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/radikoutil/radikotest"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+func TestMatchBroadcasts(t *testing.T) {
+	t.Parallel()
+	// NOTE: 2023-10-03 は火曜日
+	slot := time.Date(2023, 10, 3, 18, 0, 0, 0, timeutil.JST())
+	p := config.Program{
+		Title:     "ナイター後の番組",
+		Weekdays:  []timeutil.Weekday{timeutil.Weekday(time.Tuesday)},
+		StationID: "LFR",
+		Start:     "1800",
+	}
+	tests := map[string]struct {
+		progs    []radiko.Prog
+		wantFrom []time.Time
+	}{
+		"on time": {
+			progs: []radiko.Prog{
+				radikotest.NewProg("ナイター後の番組", slot, time.Hour),
+			},
+			wantFrom: []time.Time{slot},
+		},
+		"on time with another title": {
+			progs: []radiko.Prog{
+				radikotest.NewProg("特別番組", slot, time.Hour),
+			},
+			wantFrom: []time.Time{slot},
+		},
+		"shifted by an overrun": {
+			progs: []radiko.Prog{
+				radikotest.NewProg("ナイター中継", slot.Add(-3*time.Hour), 3*time.Hour+40*time.Minute),
+				radikotest.NewProg("ナイター後の番組 第10回", slot.Add(40*time.Minute), time.Hour),
+				radikotest.NewProg("次の番組", slot.Add(100*time.Minute), time.Hour),
+			},
+			wantFrom: []time.Time{slot.Add(40 * time.Minute)},
+		},
+		"shifted program with another title": {
+			progs: []radiko.Prog{
+				radikotest.NewProg("次の番組", slot.Add(40*time.Minute), time.Hour),
+			},
+		},
+		"shifted too far": {
+			progs: []radiko.Prog{
+				radikotest.NewProg("ナイター後の番組", slot.Add(autoScheduleShiftTolerance+time.Minute), time.Hour),
+			},
+		},
+		"other weekday": {
+			progs: []radiko.Prog{
+				radikotest.NewProg("ナイター後の番組", slot.AddDate(0, 0, 1), time.Hour),
+			},
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			weekdays, start, err := p.Schedule()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := matchBroadcasts(p, weekdays, start, tt.progs, slot.Add(-24*time.Hour))
+			if err != nil {
+				t.Fatalf("matchBroadcasts() error = %+v", err)
+			}
+			if len(got) != len(tt.wantFrom) {
+				t.Fatalf("matchBroadcasts() = %+v, want from %v", got, tt.wantFrom)
+			}
+			for i, b := range got {
+				if !b.slot.Equal(slot) || !b.from.Equal(tt.wantFrom[i]) {
+					t.Errorf("matchBroadcasts()[%d] slot = %v, from = %v, want slot %v, from %v", i, b.slot, b.from, slot, tt.wantFrom[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAutoSchedules_retain(t *testing.T) {
+	t.Parallel()
+	slot := time.Now().Add(time.Hour)
+	var (
+		found   = broadcast{program: config.Program{StationID: "LFR", Title: "見つかった番組"}, slot: slot}
+		failed  = broadcast{program: config.Program{StationID: "TBS", Title: "番組表が取れなかった番組"}, slot: slot}
+		removed = broadcast{program: config.Program{StationID: "QRR", Title: "番組表から消えた番組"}, slot: slot}
+	)
+	var s autoSchedules
+	t.Cleanup(s.stopAll)
+	for _, b := range []broadcast{found, failed, removed} {
+		s.set(b, slot.Add(time.Hour), func() {})
+	}
+
+	s.retain(
+		map[string]struct{}{found.key(): {}},
+		map[string]struct{}{autoProgramKey(failed.program): {}},
+	)
+	for key, want := range map[string]bool{found.key(): true, failed.key(): true, removed.key(): false} {
+		if _, ok := s.runAt(key); ok != want {
+			t.Errorf("%s is scheduled = %v, want %v", key, ok, want)
+		}
+	}
+}
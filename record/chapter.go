@@ -0,0 +1,141 @@
+package record
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/radikoutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+// programChapters は番組表のコーナーと番組の中で流れた曲を、番組の開始からの位置で表したチャプターにして返す。
+// 最初のチャプターまでは番組名のチャプターにする。コーナーも曲も無ければチャプターは作らない。
+func programChapters(ctx context.Context, logger zerolog.Logger, client *radiko.Client, stationID string, program *radiko.Prog, from, to time.Time) []metadata.Chapter {
+	chapters := append(segmentChapters(program, from, to), musicChapters(ctx, logger, client, stationID, from, to)...)
+	if len(chapters) == 0 {
+		return nil
+	}
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].StartSeconds < chapters[j].StartSeconds
+	})
+	if chapters[0].StartSeconds > 0 {
+		chapters = append([]metadata.Chapter{{Title: program.Title}}, chapters...)
+	}
+	return chapters
+}
+
+// musicChapters は番組の中で流れた曲を、番組の開始からの位置で表したチャプターにして返す。
+// NOTE: 曲の情報が取れなくても録音は保存する
+func musicChapters(ctx context.Context, logger zerolog.Logger, client *radiko.Client, stationID string, from, to time.Time) []metadata.Chapter {
+	musics, err := radikoutil.Musics(ctx, client, stationID, from, to)
+	if err != nil {
+		logger.Warn().Err(err).Str("station_id", stationID).Msg("failed to get musics for chapters")
+		return nil
+	}
+	chapters := make([]metadata.Chapter, 0, len(musics))
+	for _, m := range musics {
+		chapters = append(chapters, metadata.Chapter{
+			Title:        musicChapterTitle(m),
+			StartSeconds: m.StartedAt.Sub(from).Seconds(),
+		})
+	}
+	return chapters
+}
+
+var (
+	// segmentLineRegexp は番組表の説明の `■22:30頃 コーナー名` のような、時刻で始まる行にマッチする。
+	segmentLineRegexp = regexp.MustCompile(`^[^0-9]{0,3}?([0-9]{1,2}):([0-9]{2})\s*(?:頃|ごろ)?\s*[〜~-]?\s*(.+)$`)
+	// segmentTagRegexp は説明の中のHTMLのタグにマッチする。
+	segmentTagRegexp = regexp.MustCompile(`<[^>]*>`)
+	// segmentLineBreakRegexp は説明の中の改行になるHTMLのタグにマッチする。
+	segmentLineBreakRegexp = regexp.MustCompile(`(?i)<br\s*/?>|</(?:p|li|div)>`)
+	segmentReplacer        = strings.NewReplacer(
+		"０", "0", "１", "1", "２", "2", "３", "3", "４", "4",
+		"５", "5", "６", "6", "７", "7", "８", "8", "９", "9",
+		"：", ":", "～", "〜",
+	)
+)
+
+// segmentChapters は番組表の説明に書かれた時刻付きのコーナーを、番組の開始からの位置で表したチャプターにして返す。
+// NOTE: radikoの番組表にはコーナーの構造化された情報が無いので、desc と info の時刻で始まる行をコーナーとみなす
+func segmentChapters(program *radiko.Prog, from, to time.Time) []metadata.Chapter {
+	var chapters []metadata.Chapter
+	for _, text := range []string{program.Desc, program.Info} {
+		text = segmentLineBreakRegexp.ReplaceAllString(text, "\n")
+		text = html.UnescapeString(segmentTagRegexp.ReplaceAllString(text, ""))
+		for _, line := range strings.Split(segmentReplacer.Replace(text), "\n") {
+			m := segmentLineRegexp.FindStringSubmatch(strings.TrimSpace(line))
+			if m == nil {
+				continue
+			}
+			hour, _ := strconv.Atoi(m[1])
+			minute, _ := strconv.Atoi(m[2])
+			startedAt, ok := segmentStartedAt(hour, minute, from, to)
+			if !ok {
+				continue
+			}
+			chapters = append(chapters, metadata.Chapter{
+				Title:        strings.TrimSpace(m[3]),
+				StartSeconds: startedAt.Sub(from).Seconds(),
+			})
+		}
+	}
+	return chapters
+}
+
+// segmentStartedAt は番組表の時刻を、番組の放送中の時刻に直す。
+// NOTE: 深夜の番組は 25:00 のように24時以降で書かれることも、1:00 のように書かれることもあるので、前後の日も試す
+func segmentStartedAt(hour, minute int, from, to time.Time) (time.Time, bool) {
+	if minute >= 60 {
+		return time.Time{}, false
+	}
+	base := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for _, day := range []int{-1, 0, 1} {
+		t := base.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+		if !t.Before(from) && t.Before(to) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func musicChapterTitle(m radikoutil.Music) string {
+	if m.Artist == "" {
+		return m.Title
+	}
+	return m.Title + " / " + m.Artist
+}
+
+// fitChapters は番組の開始からの位置で表したチャプターを、番組の開始が offset にある長さ length のファイルの位置に直す。
+// ファイルの先頭より前に始まるチャプターは先頭から始まるものとし、ファイルの後に始まるチャプターは捨てる。
+// length が 0 の場合は長さが分からないものとして、最後のチャプターの終わりを決めない。
+func fitChapters(chapters []metadata.Chapter, offset, length time.Duration) []metadata.Chapter {
+	var fitted []metadata.Chapter
+	for _, c := range chapters {
+		start := max(c.Start()+offset, 0)
+		if length > 0 && start >= length {
+			break
+		}
+		// NOTE: 先頭より前に始まったチャプターは、先頭で流れているものだけを残す。
+		// 録音できなかった番組の印のように、同じ位置に並ぶチャプターは残す
+		if n := len(fitted); n > 0 && c.Start()+offset <= 0 && fitted[n-1].StartSeconds >= start.Seconds() {
+			fitted = fitted[:n-1]
+		}
+		fitted = append(fitted, metadata.Chapter{Title: c.Title, StartSeconds: start.Seconds()})
+	}
+	for i := range fitted {
+		if i+1 < len(fitted) {
+			fitted[i].EndSeconds = fitted[i+1].StartSeconds
+		} else if length > 0 {
+			fitted[i].EndSeconds = length.Seconds()
+		}
+	}
+	return fitted
+}
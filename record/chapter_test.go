@@ -0,0 +1,131 @@
+package record
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/upamune/radicaster/metadata"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+func TestFitChapters(t *testing.T) {
+	t.Parallel()
+	chapters := []metadata.Chapter{
+		{Title: "オープニング", StartSeconds: 0},
+		{Title: "曲1 / アーティスト", StartSeconds: 60},
+		{Title: "曲2 / アーティスト", StartSeconds: 300},
+	}
+	tests := map[string]struct {
+		// chapters を省略すると共通のチャプターを使う。
+		chapters       []metadata.Chapter
+		offset, length time.Duration
+		want           []metadata.Chapter
+	}{
+		"as is": {
+			length: 10 * time.Minute,
+			want: []metadata.Chapter{
+				{Title: "オープニング", StartSeconds: 0, EndSeconds: 60},
+				{Title: "曲1 / アーティスト", StartSeconds: 60, EndSeconds: 300},
+				{Title: "曲2 / アーティスト", StartSeconds: 300, EndSeconds: 600},
+			},
+		},
+		"recorded before the program": {
+			offset: 30 * time.Second,
+			length: 10 * time.Minute,
+			want: []metadata.Chapter{
+				{Title: "オープニング", StartSeconds: 30, EndSeconds: 90},
+				{Title: "曲1 / アーティスト", StartSeconds: 90, EndSeconds: 330},
+				{Title: "曲2 / アーティスト", StartSeconds: 330, EndSeconds: 600},
+			},
+		},
+		"leading part is trimmed": {
+			offset: -90 * time.Second,
+			length: 200 * time.Second,
+			want: []metadata.Chapter{
+				{Title: "曲1 / アーティスト", StartSeconds: 0, EndSeconds: 200},
+			},
+		},
+		"marker at the same position": {
+			chapters: []metadata.Chapter{
+				{Title: "朝の番組", StartSeconds: 0},
+				{Title: "中継 (タイムフリー対象外のため録音なし)", StartSeconds: 30},
+				{Title: "昼の番組", StartSeconds: 30},
+			},
+			length: time.Minute,
+			want: []metadata.Chapter{
+				{Title: "朝の番組", StartSeconds: 0, EndSeconds: 30},
+				{Title: "中継 (タイムフリー対象外のため録音なし)", StartSeconds: 30, EndSeconds: 30},
+				{Title: "昼の番組", StartSeconds: 30, EndSeconds: 60},
+			},
+		},
+		"unknown length": {
+			want: []metadata.Chapter{
+				{Title: "オープニング", StartSeconds: 0, EndSeconds: 60},
+				{Title: "曲1 / アーティスト", StartSeconds: 60, EndSeconds: 300},
+				{Title: "曲2 / アーティスト", StartSeconds: 300},
+			},
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			in := chapters
+			if tt.chapters != nil {
+				in = tt.chapters
+			}
+			if got := fitChapters(in, tt.offset, tt.length); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fitChapters() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegmentChapters(t *testing.T) {
+	t.Parallel()
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	tests := map[string]struct {
+		from time.Time
+		d    time.Duration
+		desc string
+		info string
+		want []metadata.Chapter
+	}{
+		"corners in desc and info": {
+			from: time.Date(2023, 10, 1, 22, 0, 0, 0, jst),
+			d:    2 * time.Hour,
+			desc: "番組の説明<br />■22:30頃　ゲストのコーナー<br>■２３：１５～ リクエスト",
+			info: `<p>23:50 エンディング &amp; お知らせ</p><p>21:00 放送前の時刻は捨てる</p>`,
+			want: []metadata.Chapter{
+				{Title: "ゲストのコーナー", StartSeconds: 1800},
+				{Title: "リクエスト", StartSeconds: 4500},
+				{Title: "エンディング & お知らせ", StartSeconds: 6600},
+			},
+		},
+		"late night notation": {
+			from: time.Date(2023, 10, 2, 1, 0, 0, 0, jst),
+			d:    2 * time.Hour,
+			desc: "25:30 コーナーA\n2:15 コーナーB",
+			want: []metadata.Chapter{
+				{Title: "コーナーA", StartSeconds: 1800},
+				{Title: "コーナーB", StartSeconds: 4500},
+			},
+		},
+		"no corners": {
+			from: time.Date(2023, 10, 1, 22, 0, 0, 0, jst),
+			d:    time.Hour,
+			desc: "2023年のゲストは山田さん",
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			prog := &radiko.Prog{Title: "番組", Desc: tt.desc, Info: tt.info}
+			if got := segmentChapters(prog, tt.from, tt.from.Add(tt.d)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("segmentChapters() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package record
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/ffmpeg"
+)
+
+const (
+	// coverArtFileName は作業ディレクトリに保存する埋め込み用の画像のファイル名。
+	coverArtFileName = "cover"
+	// convertedCoverArtFileName はそのまま埋め込めない画像を JPEG にしたファイル名。
+	convertedCoverArtFileName = "cover.jpg"
+)
+
+// prepareCoverArt は埋め込むための画像をダウンロードして、埋め込める形式にしたファイルのパスを返す。
+// NOTE: WebP や GIF のような画像はコンテナに入れられず変換が失敗するので、JPEG にする
+func (r *Recorder) prepareCoverArt(ctx context.Context, logger zerolog.Logger, w *recordingWorkDir, imageURL string) (string, error) {
+	converted := filepath.Join(w.dir, convertedCoverArtFileName)
+	if _, err := os.Stat(converted); err == nil {
+		return converted, nil
+	}
+	downloaded, err := r.downloadCoverArt(ctx, w, imageURL)
+	if err != nil {
+		return "", err
+	}
+	ok, err := ffmpeg.IsEmbeddableImage(downloaded)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return downloaded, nil
+	}
+	partial := filepath.Join(w.dir, "cover.part.jpg")
+	if err := ffmpeg.ConvertImageToJPEG(ctx, logger, downloaded, partial); err != nil {
+		return "", errors.Wrap(err, "failed to convert image")
+	}
+	if err := os.Rename(partial, converted); err != nil {
+		return "", errors.Wrap(err, "failed to rename image file")
+	}
+	return converted, nil
+}
+
+// downloadCoverArt は埋め込むための画像を作業ディレクトリにダウンロードして、そのパスを返す。
+// 既にダウンロードしていればそれを使う。
+func (r *Recorder) downloadCoverArt(ctx context.Context, w *recordingWorkDir, imageURL string) (string, error) {
+	output := filepath.Join(w.dir, coverArtFileName)
+	if _, err := os.Stat(output); err == nil {
+		return output, nil
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create request")
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get image")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Newf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	// NOTE: 途中で中断しても壊れた画像を埋め込まないように、書き終わってからリネームする
+	partial := output + ".part"
+	f, err := os.Create(partial)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create image file")
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", errors.Wrap(err, "failed to write image file")
+	}
+	if err := os.Rename(partial, output); err != nil {
+		return "", errors.Wrap(err, "failed to rename image file")
+	}
+	return output, nil
+}
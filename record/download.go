@@ -0,0 +1,149 @@
+package record
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/sourcegraph/conc/pool"
+	"golang.org/x/time/rate"
+)
+
+const defaultMaxDownloadConns = 16
+
+// WithDownloadLimit は全ての録音で共有するチャンク取得の同時接続数と秒間リクエスト数の上限を指定する。
+// requestsPerSecond が 0 以下の場合はリクエスト数を制限しない。
+func WithDownloadLimit(maxConns int, requestsPerSecond float64) Option {
+	return func(r *Recorder) {
+		r.downloadLimiter = newDownloadLimiter(maxConns, requestsPerSecond)
+	}
+}
+
+// downloadLimiter は同時に開くコネクションとファイルの数、およびリクエストの頻度を制限する。
+type downloadLimiter struct {
+	sem     chan struct{}
+	limiter *rate.Limiter
+}
+
+func newDownloadLimiter(maxConns int, requestsPerSecond float64) *downloadLimiter {
+	if maxConns <= 0 {
+		maxConns = defaultMaxDownloadConns
+	}
+	limit := rate.Inf
+	if requestsPerSecond > 0 {
+		limit = rate.Limit(requestsPerSecond)
+	}
+	return &downloadLimiter{
+		sem:     make(chan struct{}, maxConns),
+		limiter: rate.NewLimiter(limit, maxConns),
+	}
+}
+
+func (l *downloadLimiter) maxConns() int {
+	return cap(l.sem)
+}
+
+func (l *downloadLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *downloadLimiter) release() {
+	<-l.sem
+}
+
+// rateLimitedTransport はリトライも含めた全てのリクエストを downloadLimiter の頻度に合わせる。
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+func applyDownloadLimiter(c *retryablehttp.Client, l *downloadLimiter) {
+	base := c.HTTPClient.Transport
+	if t, ok := base.(*http.Transport); ok {
+		t.MaxConnsPerHost = l.maxConns()
+		t.MaxIdleConnsPerHost = l.maxConns()
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.HTTPClient.Transport = &rateLimitedTransport{
+		base:    base,
+		limiter: l.limiter,
+	}
+}
+
+func (r *Recorder) bulkDownload(ctx context.Context, urls []string, w *recordingWorkDir) error {
+	// NOTE: ゴルーチンもコネクション数以上に立てても待つだけなので合わせておく
+	p := pool.New().WithErrors().WithMaxGoroutines(r.downloadLimiter.maxConns())
+
+	for i, url := range urls {
+		i, url := i, url
+		// NOTE: 前回の録音で取得済みのチャンクは取り直さない
+		if w.isDownloaded(i, url) {
+			continue
+		}
+		p.Go(func() error {
+			if err := r.download(ctx, url, w.chunkPath(i, url)); err != nil {
+				return errors.Wrapf(err, "failed to download %d", i)
+			}
+			if err := w.markDownloaded(url); err != nil {
+				return errors.Wrapf(err, "failed to mark %d as downloaded", i)
+			}
+			return nil
+		})
+	}
+	if err := p.Wait(); err != nil {
+		return errors.Wrap(err, "failed to download aac files")
+	}
+	return nil
+}
+
+func (r *Recorder) download(ctx context.Context, link, output string) error {
+	// NOTE: 全ての録音で共有しているので、局ごとに並列に録音してもファイルディスクリプタを使い切らない
+	if err := r.downloadLimiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer r.downloadLimiter.release()
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// NOTE: 途中で中断しても壊れたチャンクが残らないように、書き終わってからリネームする
+	partial := output + ".part"
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, output)
+}
@@ -0,0 +1,89 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/storage"
+)
+
+func TestRecorder_bulkDownload(t *testing.T) {
+	t.Parallel()
+	const maxConns = 3
+	var current, peak, requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		n := current.Add(1)
+		defer current.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	r, err := NewRecorder(
+		zerolog.Nop(), storage.NewLocal(t.TempDir()), "", "", config.Config{}, "",
+		WithDownloadLimit(maxConns, 0),
+	)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	var urls []string
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("%s/chunk%02d.aac", srv.URL, i))
+	}
+	dir := t.TempDir()
+	w, err := r.openRecordingWorkDir(dir)
+	if err != nil {
+		t.Fatalf("openRecordingWorkDir() error = %v", err)
+	}
+	if err := r.bulkDownload(context.Background(), urls, w); err != nil {
+		t.Fatalf("bulkDownload() error = %v", err)
+	}
+	w.close()
+
+	if got := peak.Load(); got > maxConns {
+		t.Errorf("peak concurrent connections = %d, want <= %d", got, maxConns)
+	}
+	files, err := os.ReadDir(w.chunksDir())
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if got, want := len(files), len(urls); got != want {
+		t.Errorf("downloaded files = %d, want %d", got, want)
+	}
+
+	// NOTE: 1つだけチャンクを消して再開すると、消したチャンクだけを取り直す
+	if err := os.Remove(w.chunkPath(5, urls[5])); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	before := requests.Load()
+	w, err = r.openRecordingWorkDir(dir)
+	if err != nil {
+		t.Fatalf("openRecordingWorkDir() error = %v", err)
+	}
+	defer w.close()
+	if got, want := w.downloadedCount(), len(urls); got != want {
+		t.Errorf("downloadedCount() = %d, want %d", got, want)
+	}
+	if err := r.bulkDownload(context.Background(), urls, w); err != nil {
+		t.Fatalf("bulkDownload() error = %v", err)
+	}
+	if got := requests.Load() - before; got != 1 {
+		t.Errorf("requests on resume = %d, want 1", got)
+	}
+}
@@ -0,0 +1,57 @@
+package record
+
+import (
+	"time"
+
+	"github.com/upamune/radicaster/job"
+)
+
+// WithJobStore はタスクとサブタスクの実行履歴を保存する Store を指定する。
+func WithJobStore(s *job.Store) Option {
+	return func(r *Recorder) {
+		r.jobs = s
+	}
+}
+
+func (r *Recorder) Jobs() []job.Job {
+	return r.jobs.List()
+}
+
+func (r *Recorder) Job(id string) (job.Job, bool) {
+	return r.jobs.Get(id)
+}
+
+func (r *Recorder) startJob(j job.Job) *job.Job {
+	j.Status = job.StatusRunning
+	j.StartedAt = time.Now()
+	r.putJob(j)
+	return &j
+}
+
+func (r *Recorder) finishJob(j *job.Job, err error) {
+	finishedAt := time.Now()
+	j.FinishedAt = &finishedAt
+	j.Duration = finishedAt.Sub(j.StartedAt)
+	switch {
+	case err != nil:
+		j.Status = job.StatusFailed
+		j.Error = err.Error()
+	case j.Status == job.StatusRunning:
+		j.Status = job.StatusSucceeded
+	}
+	// NOTE: 番組表を見直すたびに録音済みの番組がスキップとして履歴に積み上がらないようにする
+	if j.Status == job.StatusSkipped && j.ParentID != "" {
+		if err := r.jobs.Delete(j.ID); err != nil {
+			r.logger.Error().Err(err).Str("job_id", j.ID).Msg("failed to delete job history")
+		}
+		return
+	}
+	r.putJob(*j)
+}
+
+func (r *Recorder) putJob(j job.Job) {
+	if err := r.jobs.Put(j); err != nil {
+		// NOTE: 履歴の保存に失敗しても録音自体は続ける
+		r.logger.Error().Err(err).Str("job_id", j.ID).Msg("failed to save job history")
+	}
+}
@@ -0,0 +1,39 @@
+package record
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/job"
+)
+
+func TestRecorder_finishJob(t *testing.T) {
+	t.Parallel()
+	jobs, err := job.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %+v", err)
+	}
+	r := &Recorder{logger: zerolog.Nop(), jobs: jobs}
+
+	tests := map[string]struct {
+		parentID string
+		status   job.Status
+		wantKept bool
+	}{
+		"succeeded sub task": {parentID: "parent", status: job.StatusRunning, wantKept: true},
+		"skipped sub task":   {parentID: "parent", status: job.StatusSkipped, wantKept: false},
+		"skipped task":       {status: job.StatusSkipped, wantKept: true},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			j := r.startJob(job.Job{ID: name, ParentID: tt.parentID})
+			j.Status = tt.status
+			r.finishJob(j, nil)
+			if _, ok := r.Job(name); ok != tt.wantKept {
+				t.Errorf("job is kept = %v, want %v", ok, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/xid"
+	"github.com/samber/lo"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/ffmpeg"
+	"github.com/upamune/radicaster/job"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/radikoutil"
+	"github.com/upamune/radicaster/timeutil"
+)
+
+const (
+	// NOTE: 時報や番組の切り替わりで頭と終わりが欠けないように、前後に余裕を持って録音する
+	livePadding = time.Minute
+	// NOTE: ffmpeg が止まらなくなった時のために、録音する長さより少し長いタイムアウトにする
+	liveTimeoutMargin = 5 * time.Minute
+	// NOTE: 配信が途切れて録音が短くなったかを判断する時に許す差
+	liveShortTolerance = time.Minute
+
+	liveRecordedFileName = "live.aac"
+)
+
+// isShortRecording は録音した長さ recorded が放送の長さ want より明らかに短いかを返す。
+func isShortRecording(recorded, want time.Duration) bool {
+	return recorded < want-liveShortTolerance
+}
+
+// liveCronExpressions は番組の開始時刻の livePadding 前に録音を始める cron の式を返す。
+func liveCronExpressions(p config.Program) ([]string, error) {
+	weekdays, start, err := p.Schedule()
+	if err != nil {
+		return nil, err
+	}
+	clock, err := time.ParseInLocation("1504", start, timeutil.JST())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse start time: %s", start)
+	}
+	exprs := make([]string, 0, len(weekdays))
+	for _, wd := range lo.Uniq(weekdays) {
+		// NOTE: 0時の番組は前日の23:59に始めるので、曜日も含めて時刻を計算する
+		// 2023年1月1日は日曜日
+		at := time.Date(2023, time.January, 1+int(wd), clock.Hour(), clock.Minute(), 0, 0, timeutil.JST()).Add(-livePadding)
+		exprs = append(exprs, fmt.Sprintf("%d %d * * %d", at.Minute(), at.Hour(), at.Weekday()))
+	}
+	return exprs, nil
+}
+
+// nearestStart は now に一番近い start ("HHMM") の時刻を返す。
+// 開始前に呼ばれた場合は当日(0時をまたぐ場合は翌日)の、放送中に呼ばれた場合は放送中の回の開始時刻になる。
+func nearestStart(now time.Time, start string) (time.Time, error) {
+	now = now.In(timeutil.JST())
+	clock, err := time.ParseInLocation("1504", start, timeutil.JST())
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to parse start time: %s", start)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, timeutil.JST())
+	return lo.MinBy([]time.Time{today.AddDate(0, 0, -1), today, today.AddDate(0, 0, 1)}, func(a, b time.Time) bool {
+		return a.Sub(now).Abs() < b.Sub(now).Abs()
+	}), nil
+}
+
+// startLiveRecording はライブの録音を別の goroutine で始める。
+// NOTE: gocron は止める時に実行中のジョブを待つので、数時間かかるライブの録音で設定の更新が止まらないようにする
+func (r *Recorder) startLiveRecording(p config.Program) {
+	go func() {
+		if err := r.RecordLive(p); err != nil {
+			r.logger.Error().Err(err).Str("program_title", p.Title).Msg("failed to record live")
+		}
+	}()
+}
+
+// RecordLive は番組の一番近い回をライブで録音する。
+// 開始前に呼ばれた場合は開始時刻の livePadding 前まで待ち、終了時刻の livePadding 後まで録音する。
+func (r *Recorder) RecordLive(p config.Program) (err error) {
+	var (
+		taskStartedTime = time.Now()
+		taskID          = xid.New().String()
+		logger          = r.logger.With().
+				Str("task_id", taskID).
+				Str("mode", config.RecordModeLive).
+				Logger()
+	)
+
+	logger.Info().
+		Time("task_started_time", taskStartedTime).
+		Msg("record live task started")
+	j := r.startJob(job.Job{
+		ID:        taskID,
+		Kind:      job.KindRecord,
+		Program:   p.Title,
+		StationID: p.StationID,
+	})
+	defer func() {
+		r.finishJob(j, err)
+		taskFinishedTime := time.Now()
+		logger := logger.With().
+			Time("task_started_time", taskStartedTime).
+			Time("task_finished_time", taskFinishedTime).
+			Dur("task_duration", taskFinishedTime.Sub(taskStartedTime)).Logger()
+
+		if err != nil {
+			logger.Error().Err(err).Msg("record live task finished with an error")
+			return
+		}
+		logger.Info().Msg("record live task finished")
+	}()
+
+	_, start, err := p.Schedule()
+	if err != nil {
+		return errors.Wrap(err, "invalid schedule")
+	}
+	from, err := nearestStart(time.Now(), start)
+	if err != nil {
+		return err
+	}
+	j.From = &from
+
+	ctx := context.Background()
+	// NOTE: Radikoのクライアントは毎回初期化しないと、認証エラーになってしまう
+	client, err := r.NewRadikoClient(
+		ctx,
+		radikoutil.WithAreaID(p.AreaID),
+		radikoutil.WithPremium(r.radikoEmail, r.radikoPassword),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to create radiko client")
+	}
+	program, err := client.GetProgramByStartTime(ctx, p.StationID, from)
+	if err != nil {
+		return errors.Wrapf(
+			err,
+			"failed to get program: station_id=%s, from=%s",
+			p.StationID,
+			from.Format("2006-01-02 15:04:05"),
+		)
+	}
+	_, to, err := ParseProgTime(program)
+	if err != nil {
+		return err
+	}
+	logger = logger.With().
+		Time("from", from).
+		Time("to", to).
+		Str("program_title", program.Title).
+		Logger()
+	logger.Info().Msg("program found")
+
+	profile, err := r.Config().Profile(p.Encoding)
+	if err != nil {
+		return err
+	}
+	output := recordingFileName(program.Title, from, false, profile.Ext())
+	if skipped, err := r.skipIfRecorded(ctx, logger, j, output); err != nil || skipped {
+		return err
+	}
+
+	if d := time.Until(from.Add(-livePadding)); d > 0 {
+		logger.Info().Dur("wait", d).Msg("waiting for the broadcast to start")
+		time.Sleep(d)
+	}
+	duration := time.Until(to.Add(livePadding))
+	if duration <= 0 {
+		return errors.Newf("the broadcast has already ended: to=%s", to.Format("2006-01-02 15:04:05"))
+	}
+
+	// NOTE: エリアを指定したプレミアム会員はエリア外の局も聴けるようにエリアフリーの配信を使う
+	areaFree := r.radikoEmail != "" && p.AreaID != ""
+	uri, err := radikoutil.LivePlaylistM3U8(ctx, client, p.StationID, areaFree)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get live m3u8: %s %s", p.StationID, p.Title)
+	}
+
+	workDirPath := r.workDirPath(p.StationID, from)
+	unlock := r.lockWorkDir(workDirPath)
+	defer unlock()
+	w, err := r.openRecordingWorkDir(workDirPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open work dir")
+	}
+	defer w.close()
+
+	recorded := filepath.Join(w.dir, liveRecordedFileName)
+	logger.Info().Dur("duration", duration).Msg("start recording live stream")
+	startedAt := time.Now()
+	recordCtx, cancel := context.WithTimeout(ctx, duration+liveTimeoutMargin)
+	defer cancel()
+	if err := ffmpeg.RecordHLS(
+		recordCtx,
+		logger,
+		uri,
+		map[string]string{"X-Radiko-AuthToken": client.AuthToken()},
+		duration,
+		recorded,
+	); err != nil {
+		return errors.Wrap(err, "failed to record live stream")
+	}
+	logger.Info().Msg("finish recording live stream")
+
+	// NOTE: ffmpeg は配信が途切れても正常に終わることがあるので、録音できた長さを確かめる
+	if recordedDuration, err := ffmpeg.ProbeDuration(ctx, recorded); err != nil {
+		logger.Warn().Err(err).Msg("failed to probe duration of the live recording")
+	} else if want := to.Sub(from) - max(startedAt.Sub(from), 0); isShortRecording(recordedDuration, want) {
+		return errors.Newf("the live recording is too short: recorded=%s, want=%s", recordedDuration, want)
+	}
+
+	// NOTE: 開始時刻より前から録音しているので、その分だけずらして番組の範囲にする
+	boundary := ffmpeg.Boundary{Offset: max(from.Sub(startedAt), 0), Duration: to.Sub(from)}
+	chapters := programChapters(ctx, logger, client, p.StationID, program, from, to)
+	return r.saveRecording(ctx, logger, j, w, recorded, output, profile, p.PostProcess, boundary, metadata.EpisodeMetadata{
+		Title:        program.Title,
+		Description:  program.Desc,
+		PublishedAt:  from,
+		EndedAt:      &to,
+		Performer:    program.Pfm,
+		StationID:    p.StationID,
+		ProgramURL:   program.URL,
+		ImageURL:     p.ImageURL,
+		Path:         p.Path,
+		PodcastTitle: p.Title,
+		Chapters:     chapters,
+	})
+}
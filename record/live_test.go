@@ -0,0 +1,163 @@
+package record
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/radikoutil/radikotest"
+	"github.com/upamune/radicaster/storage"
+	"github.com/upamune/radicaster/timeutil"
+)
+
+func TestLiveCronExpressions(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		weekdays []timeutil.Weekday
+		start    string
+		want     []string
+	}{
+		"evening": {
+			weekdays: []timeutil.Weekday{timeutil.Weekday(time.Monday), timeutil.Weekday(time.Friday)},
+			start:    "2200",
+			want:     []string{"59 21 * * 1", "59 21 * * 5"},
+		},
+		"midnight starts on the previous day": {
+			weekdays: []timeutil.Weekday{timeutil.Weekday(time.Sunday)},
+			start:    "0000",
+			want:     []string{"59 23 * * 6"},
+		},
+		"broadcast day time": {
+			// NOTE: 火曜25:00は水曜1:00なので、水曜0:59に始める
+			weekdays: []timeutil.Weekday{timeutil.Weekday(time.Tuesday)},
+			start:    "2500",
+			want:     []string{"59 0 * * 3"},
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := liveCronExpressions(config.Program{Weekdays: tt.weekdays, Start: tt.start, Mode: config.RecordModeLive})
+			if err != nil {
+				t.Fatalf("%+v\n", errors.WithStack(err))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("liveCronExpressions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearestStart(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		now   time.Time
+		start string
+		want  time.Time
+	}{
+		"before the broadcast": {
+			now:   time.Date(2023, time.September, 26, 21, 59, 0, 0, timeutil.JST()),
+			start: "2200",
+			want:  time.Date(2023, time.September, 26, 22, 0, 0, 0, timeutil.JST()),
+		},
+		"during the broadcast": {
+			now:   time.Date(2023, time.September, 26, 22, 30, 0, 0, timeutil.JST()),
+			start: "2200",
+			want:  time.Date(2023, time.September, 26, 22, 0, 0, 0, timeutil.JST()),
+		},
+		"before midnight": {
+			now:   time.Date(2023, time.September, 26, 23, 59, 0, 0, timeutil.JST()),
+			start: "0000",
+			want:  time.Date(2023, time.September, 27, 0, 0, 0, 0, timeutil.JST()),
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := nearestStart(tt.now, tt.start)
+			if err != nil {
+				t.Fatalf("%+v\n", errors.WithStack(err))
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nearestStart() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsShortRecording(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		recorded time.Duration
+		want     bool
+	}{
+		"whole broadcast":  {recorded: time.Hour + 2*livePadding},
+		"a little short":   {recorded: time.Hour - liveShortTolerance},
+		"stopped halfway":  {recorded: 30 * time.Minute, want: true},
+		"nothing recorded": {recorded: 0, want: true},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := isShortRecording(tt.recorded, time.Hour); got != tt.want {
+				t.Errorf("isShortRecording() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecorder_RecordLive(t *testing.T) {
+	t.Parallel()
+	skipIfNoFFmpeg(t)
+
+	from := time.Now().In(timeutil.JST()).Truncate(time.Minute)
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:   "LFR",
+		Name: "ニッポン放送",
+		// NOTE: ライブの配信は LiveDuration で終わるので、録音が短すぎると判断されない長さにする
+		Progs: []radikotest.Prog{radikotest.NewProg("ライブの番組", from, time.Minute)},
+	})
+	defer srv.Close()
+
+	targetDir := t.TempDir()
+	r, err := NewRecorder(zerolog.Nop(), storage.NewLocal(targetDir), "", "", config.Config{}, "", WithRadikoEndpoint(srv.URL), WithWorkDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+
+	if err := r.RecordLive(config.Program{
+		Title:     "ライブの番組",
+		Weekdays:  []timeutil.Weekday{timeutil.Weekday(from.Weekday())},
+		StationID: "LFR",
+		Start:     from.Format("1504"),
+		Encoding:  config.AudioFormatAAC,
+		Mode:      config.RecordModeLive,
+		Path:      "live",
+	}); err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+
+	output := filepath.Join(targetDir, "ライブの番組_"+from.Format("2006年01月02日")+"_normal.m4a")
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("output is not found: %v", err)
+	}
+	md, err := metadata.ReadByAudioFilePath(output)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if got, want := md.FeedPath(), "live"; got != want {
+		t.Errorf("FeedPath() = %s, want %s", got, want)
+	}
+	if got, want := md.PublishedAt, from; !got.Equal(want) {
+		t.Errorf("PublishedAt = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/xid"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/job"
+	"github.com/upamune/radicaster/radikoutil"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+const (
+	onDemandQueueSize = 100
+	// NOTE: タイムフリーで遡れるのは1週間前まで
+	timeshiftWindow = 7 * 24 * time.Hour
+)
+
+// OnDemandRequest は任意の局と時間帯を1回だけ録音するリクエストを表す。
+// To を省略した場合は From に始まる番組の終了時刻までを録音する。
+type OnDemandRequest struct {
+	StationID string     `json:"station_id" form:"station_id"`
+	AreaID    string     `json:"area_id,omitempty" form:"area_id"`
+	From      time.Time  `json:"from" form:"from"`
+	To        *time.Time `json:"to,omitempty" form:"to"`
+	Title     string     `json:"title,omitempty" form:"title"`
+	Encoding  string     `json:"encoding,omitempty" form:"encoding"`
+	ImageURL  string     `json:"image_url,omitempty" form:"image_url"`
+	Path      string     `json:"path,omitempty" form:"path"`
+}
+
+type onDemandTask struct {
+	job *job.Job
+	req OnDemandRequest
+}
+
+// CanRecordOnDemand は放送がタイムフリーで今すぐ録音できるかどうかを返す。
+func CanRecordOnDemand(from, to, now time.Time) bool {
+	return !to.After(now) && now.Sub(from) <= timeshiftWindow
+}
+
+func (req OnDemandRequest) validate(now time.Time) error {
+	if req.StationID == "" {
+		return errors.New("station_id is required")
+	}
+	if req.From.IsZero() {
+		return errors.New("from is required")
+	}
+	if now.Sub(req.From) > timeshiftWindow {
+		return errors.Newf("from is out of the timeshift window: %s", req.From)
+	}
+	if req.To != nil {
+		if !req.To.After(req.From) {
+			return errors.Newf("to must be after from: from=%s, to=%s", req.From, *req.To)
+		}
+		if req.To.After(now) {
+			return errors.Newf("to must be in the past: %s", *req.To)
+		}
+	}
+	return nil
+}
+
+// EnqueueRecord はリクエストを検証してキューに積み、進捗を確認するためのジョブIDを返す。
+func (r *Recorder) EnqueueRecord(req OnDemandRequest) (string, error) {
+	if err := req.validate(time.Now()); err != nil {
+		return "", errors.Wrap(err, "invalid request")
+	}
+	if _, err := r.Config().Profile(req.Encoding); err != nil {
+		return "", errors.Wrap(err, "invalid request")
+	}
+	if req.Encoding == "" {
+		req.Encoding = config.AudioFormatAAC
+	}
+
+	from := req.From.In(timeutil.JST())
+	req.From = from
+	j := &job.Job{
+		ID:        xid.New().String(),
+		Kind:      job.KindRecordOnDemand,
+		Program:   req.Title,
+		StationID: req.StationID,
+		From:      &from,
+		Status:    job.StatusQueued,
+		StartedAt: time.Now(),
+	}
+	r.putJob(*j)
+	select {
+	case r.onDemandQueue <- onDemandTask{job: j, req: req}:
+	default:
+		err := errors.New("on-demand queue is full")
+		r.finishJob(j, err)
+		return "", err
+	}
+	return j.ID, nil
+}
+
+func (r *Recorder) runOnDemandWorker() {
+	for task := range r.onDemandQueue {
+		task := task
+		_ = r.recordOnDemand(task.job, task.req)
+	}
+}
+
+func (r *Recorder) recordOnDemand(j *job.Job, req OnDemandRequest) (err error) {
+	var (
+		taskStartedTime = time.Now()
+		logger          = r.logger.With().
+				Str("task_id", j.ID).
+				Str("station_id", req.StationID).
+				Time("from", req.From).
+				Logger()
+	)
+	logger.Info().
+		Time("task_started_time", taskStartedTime).
+		Msg("record_on_demand task started")
+	j.Status = job.StatusRunning
+	j.StartedAt = taskStartedTime
+	r.putJob(*j)
+	defer func() {
+		r.finishJob(j, err)
+		taskFinishedTime := time.Now()
+		logger := logger.With().
+			Time("task_started_time", taskStartedTime).
+			Time("task_finished_time", taskFinishedTime).
+			Dur("task_duration", taskFinishedTime.Sub(taskStartedTime)).Logger()
+
+		if err != nil {
+			logger.Error().Err(err).Msg("record_on_demand task finished with an error")
+			return
+		}
+		logger.Info().Msg("record_on_demand task finished")
+	}()
+
+	ctx := context.Background()
+
+	// NOTE: Radikoのクライアントは毎回初期化しないと、認証エラーになってしまう
+	client, err := r.NewRadikoClient(
+		ctx,
+		radikoutil.WithAreaID(req.AreaID),
+		radikoutil.WithPremium(r.radikoEmail, r.radikoPassword),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to create radiko client")
+	}
+
+	program, err := client.GetProgramByStartTime(ctx, req.StationID, req.From)
+	switch {
+	case err == nil:
+		// NOTE: 番組表の情報を元にしつつ、指定された時間帯で上書きする
+		if req.To != nil {
+			prog := *program
+			prog.To = req.To.In(timeutil.JST()).Format("20060102150405")
+			program = &prog
+		}
+	case errors.Is(err, radiko.ErrProgramNotFound) && req.To != nil:
+		program = &radiko.Prog{
+			Ft:    req.From.Format("20060102150405"),
+			To:    req.To.In(timeutil.JST()).Format("20060102150405"),
+			Title: fmt.Sprintf("%s %s", req.StationID, req.From.Format("2006-01-02 15:04")),
+		}
+	default:
+		return errors.Wrapf(
+			err,
+			"failed to get program: station_id=%s, from=%s",
+			req.StationID,
+			req.From.Format("2006-01-02 15:04:05"),
+		)
+	}
+	if req.Title != "" {
+		program.Title = req.Title
+	}
+	j.Program = program.Title
+
+	podcastTitle := req.Title
+	if podcastTitle == "" {
+		podcastTitle = program.Title
+	}
+	return r.recordByStartTime(
+		ctx,
+		logger,
+		client,
+		j,
+		false,
+		// NOTE: 番組表と違う時間帯を指定できるので、時間帯でプレイリストを取得する
+		true,
+		program,
+		podcastTitle, req.ImageURL, req.StationID, req.Encoding, req.Path,
+		nil,
+		req.From,
+	)
+}
@@ -0,0 +1,106 @@
+package record
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/job"
+	"github.com/upamune/radicaster/timeutil"
+)
+
+func TestOnDemandRequest_validate(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2023, 10, 8, 12, 0, 0, 0, timeutil.JST())
+	at := func(d time.Duration) *time.Time {
+		v := now.Add(d)
+		return &v
+	}
+	tests := map[string]struct {
+		req     OnDemandRequest
+		wantErr bool
+	}{
+		"program start": {
+			req: OnDemandRequest{StationID: "LFR", From: now.Add(-time.Hour)},
+		},
+		"time range": {
+			req: OnDemandRequest{StationID: "LFR", From: now.Add(-2 * time.Hour), To: at(-time.Hour)},
+		},
+		"end of the timeshift window": {
+			req: OnDemandRequest{StationID: "LFR", From: now.Add(-timeshiftWindow), To: at(-timeshiftWindow + time.Hour)},
+		},
+		"ends now": {
+			req: OnDemandRequest{StationID: "LFR", From: now.Add(-time.Hour), To: at(0)},
+		},
+		"no station": {
+			req:     OnDemandRequest{From: now.Add(-time.Hour)},
+			wantErr: true,
+		},
+		"no from": {
+			req:     OnDemandRequest{StationID: "LFR"},
+			wantErr: true,
+		},
+		"out of the timeshift window": {
+			req:     OnDemandRequest{StationID: "LFR", From: now.Add(-timeshiftWindow - time.Minute), To: at(-timeshiftWindow + time.Hour)},
+			wantErr: true,
+		},
+		"ends in the future": {
+			req:     OnDemandRequest{StationID: "LFR", From: now.Add(-time.Hour), To: at(time.Minute)},
+			wantErr: true,
+		},
+		"ends before from": {
+			req:     OnDemandRequest{StationID: "LFR", From: now.Add(-time.Hour), To: at(-2 * time.Hour)},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if err := tt.req.validate(now); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecorder_EnqueueRecord(t *testing.T) {
+	t.Parallel()
+
+	jobs, err := job.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %+v", err)
+	}
+	// NOTE: ワーカーを動かさずにキューが埋まった状態を作る
+	r := &Recorder{
+		logger:        zerolog.Nop(),
+		jobs:          jobs,
+		onDemandQueue: make(chan onDemandTask, 1),
+	}
+	req := OnDemandRequest{StationID: "LFR", From: time.Now().Add(-time.Hour)}
+
+	queued, err := r.EnqueueRecord(req)
+	if err != nil {
+		t.Fatalf("EnqueueRecord() error = %+v", err)
+	}
+	if j, ok := r.Job(queued); !ok || j.Status != job.StatusQueued {
+		t.Errorf("queued job = %+v, %v", j, ok)
+	}
+
+	if _, err := r.EnqueueRecord(req); err == nil {
+		t.Fatal("EnqueueRecord() should fail when the queue is full")
+	}
+	var failed int
+	for _, j := range r.Jobs() {
+		if j.Status == job.StatusFailed {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("failed jobs = %d, want 1", failed)
+	}
+
+	if _, err := r.EnqueueRecord(OnDemandRequest{StationID: "LFR", From: req.From, Encoding: "wav"}); err == nil {
+		t.Error("EnqueueRecord() should reject an unknown encoding")
+	}
+}
@@ -3,7 +3,6 @@ package record
 import (
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -21,18 +20,52 @@ import (
 	"github.com/sourcegraph/conc/pool"
 	"github.com/upamune/radicaster/config"
 	"github.com/upamune/radicaster/ffmpeg"
+	"github.com/upamune/radicaster/job"
 	"github.com/upamune/radicaster/metadata"
 	"github.com/upamune/radicaster/radikoutil"
+	"github.com/upamune/radicaster/storage"
 	"github.com/upamune/radicaster/timeutil"
 	"github.com/yyoshiki41/go-radiko"
-	"github.com/yyoshiki41/radigo"
 )
 
+// Syncer はエピソードの追加・削除をフィードに反映する。
+type Syncer interface {
+	Sync() error
+	// Update は keys のファイルをエピソードの一覧に反映してからフィードを作り直す。
+	Update(ctx context.Context, keys ...string) error
+}
+
+type Option func(r *Recorder)
+
+// WithSyncer は保持ポリシーでエピソードを削除した後や設定を更新した後に呼ぶ Syncer を指定する。
+func WithSyncer(s Syncer) Option {
+	return func(r *Recorder) {
+		r.syncer = s
+	}
+}
+
+// WithRadikoEndpoint はradikoのAPIのベースURLを差し替える。
+func WithRadikoEndpoint(endpoint string) Option {
+	return func(r *Recorder) {
+		r.radikoEndpoint = endpoint
+	}
+}
+
 type Recorder struct {
 	httpClient *retryablehttp.Client
 	logger     zerolog.Logger
+	syncer     Syncer
+	jobs       *job.Store
+
+	onDemandQueue   chan onDemandTask
+	downloadLimiter *downloadLimiter
+	workDir         string
+	workDirLocks    workDirLocks
 
-	targetDir string
+	storage storage.Storage
+
+	recordDelay   time.Duration
+	autoSchedules autoSchedules
 
 	scheduler struct {
 		sync.RWMutex
@@ -40,6 +73,7 @@ type Recorder struct {
 	}
 
 	radikoEmail, radikoPassword string
+	radikoEndpoint              string
 	configFilePath              string
 	config                      struct {
 		sync.RWMutex
@@ -50,10 +84,11 @@ type Recorder struct {
 
 func NewRecorder(
 	logger zerolog.Logger,
-	targetDir string,
+	store storage.Storage,
 	radikoEmail, radikoPassword string,
 	initConfig config.Config,
 	configFilePath string,
+	opts ...Option,
 ) (*Recorder, error) {
 	httpClient := retryablehttp.NewClient()
 	httpClient.Logger = nil
@@ -76,18 +111,43 @@ func NewRecorder(
 	r := &Recorder{
 		httpClient:     httpClient,
 		logger:         logger,
-		targetDir:      targetDir,
+		storage:        store,
 		radikoEmail:    radikoEmail,
 		radikoPassword: radikoPassword,
 		configFilePath: configFilePath,
+		onDemandQueue:  make(chan onDemandTask, onDemandQueueSize),
+		recordDelay:    defaultRecordDelay,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.workDir == "" {
+		r.workDir = filepath.Join(os.TempDir(), "radicaster")
+	}
+	if r.downloadLimiter == nil {
+		r.downloadLimiter = newDownloadLimiter(defaultMaxDownloadConns, 0)
+	}
+	applyDownloadLimiter(r.httpClient, r.downloadLimiter)
+	if r.jobs == nil {
+		jobs, err := job.NewStore("")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create job store")
+		}
+		r.jobs = jobs
 	}
 	if _, err := r.refreshConfig(initConfig); err != nil {
 		return nil, errors.Wrap(err, "failed to init config")
 	}
+	go r.runOnDemandWorker()
 
 	return r, nil
 }
 
+// NewRadikoClient は WithRadikoEndpoint で指定したエンドポイントを使うradikoのクライアントを作る。
+func (r *Recorder) NewRadikoClient(ctx context.Context, opts ...radikoutil.Option) (*radiko.Client, error) {
+	return radikoutil.NewClient(ctx, append([]radikoutil.Option{radikoutil.WithEndpoint(r.radikoEndpoint)}, opts...)...)
+}
+
 func createEnableStationIDMap(ids []string) map[string]struct{} {
 	m := make(map[string]struct{}, len(ids))
 	for _, id := range ids {
@@ -100,8 +160,9 @@ func createEnableStationIDMap(ids []string) map[string]struct{} {
 func (r *Recorder) RecordAll() (err error) {
 	var (
 		taskStartedTime = time.Now()
+		taskID          = xid.New().String()
 		logger          = r.logger.With().
-				Str("task_id", xid.New().String()).
+				Str("task_id", taskID).
 				Bool("zenroku_mode", true).
 				Logger()
 
@@ -115,7 +176,9 @@ func (r *Recorder) RecordAll() (err error) {
 		Time("task_started_time", taskStartedTime).
 		Time("zenroku_target_date", targetDate).
 		Msg("record_all task started")
+	j := r.startJob(job.Job{ID: taskID, Kind: job.KindRecordAll})
 	defer func() {
+		r.finishJob(j, err)
 		taskFinishedTime := time.Now()
 		logger := logger.With().
 			Time("task_started_time", taskStartedTime).
@@ -136,19 +199,23 @@ func (r *Recorder) RecordAll() (err error) {
 	ctx := context.Background()
 
 	// NOTE: Radikoのクライアントは毎回初期化しないと、認証エラーになってしまう
-	client, err := radikoutil.NewClient(ctx)
+	client, err := r.NewRadikoClient(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to create radiko client")
 	}
 
+	enabledStationIDMap := lo.Associate(zenrokuConfig.EnableStationIDs, func(stationID string) (string, struct{}) {
+		return strings.ToLower(stationID), struct{}{}
+	})
+	if zenrokuConfig.IsDayMode() {
+		return r.recordAllDays(ctx, logger, client, taskID, targetDate, zenrokuConfig, enabledStationIDMap)
+	}
+
 	stations, err := client.GetStations(ctx, targetDate)
 	if err != nil {
 		return errors.Wrap(err, "failed to get stations")
 	}
 
-	enabledStationIDMap := lo.Associate(zenrokuConfig.EnableStationIDs, func(stationID string) (string, struct{}) {
-		return strings.ToLower(stationID), struct{}{}
-	})
 	pool := pool.New().WithErrors()
 	for _, station := range stations {
 		station := station
@@ -178,19 +245,33 @@ func (r *Recorder) RecordAll() (err error) {
 					continue
 				}
 				stationID := strings.ToLower(station.ID)
-				if err := r.recordByStartTime(
+				subTaskID := xid.New().String()
+				subJob := r.startJob(job.Job{
+					ID:        subTaskID,
+					ParentID:  taskID,
+					Kind:      job.KindRecordAll,
+					Program:   prog.Title,
+					StationID: station.ID,
+					From:      &from,
+				})
+				err = r.recordByStartTime(
 					ctx,
-					logger,
+					logger.With().Str("sub_task_id", subTaskID).Logger(),
 					client,
+					subJob,
 					true,
+					false,
 					&prog,
 					station.Name,
 					zenrokuConfig.Stations[stationID].ImageURL,
 					station.ID,
 					zenrokuConfig.Encoding,
 					stationID,
+					zenrokuConfig.PostProcess,
 					from,
-				); err != nil {
+				)
+				r.finishJob(subJob, err)
+				if err != nil {
 					errs = append(errs, err)
 					continue
 				}
@@ -208,13 +289,21 @@ func (r *Recorder) RecordAll() (err error) {
 func (r *Recorder) Record(p config.Program) (err error) {
 	var (
 		taskStartedTime = time.Now()
-		logger          = r.logger.With().Str("task_id", xid.New().String()).Logger()
+		taskID          = xid.New().String()
+		logger          = r.logger.With().Str("task_id", taskID).Logger()
 	)
 
 	logger.Info().
 		Time("task_started_time", taskStartedTime).
 		Msg("record task started")
+	j := r.startJob(job.Job{
+		ID:        taskID,
+		Kind:      job.KindRecord,
+		Program:   p.Title,
+		StationID: p.StationID,
+	})
 	defer func() {
+		r.finishJob(j, err)
 		taskFinishedTime := time.Now()
 		logger := logger.With().
 			Time("task_started_time", taskStartedTime).
@@ -227,13 +316,20 @@ func (r *Recorder) Record(p config.Program) (err error) {
 		}
 		logger.Info().Msg("record task finished")
 	}()
+	// NOTE: 25:00 のような番組表の時刻は、実際の曜日と時刻に直してから録音する
+	weekdays, start, err := p.Schedule()
+	if err != nil {
+		return errors.Wrap(err, "invalid schedule")
+	}
+	p.Start = start
+
 	ctx := context.Background()
 	now := time.Now().In(timeutil.JST())
 	pl := pool.New().WithErrors().WithMaxGoroutines(1)
-	for _, weekday := range lo.Uniq(p.Weekdays) {
+	for _, weekday := range lo.Uniq(weekdays) {
 		weekday := weekday
 		pl.Go(func() error {
-			if err := r.record(ctx, logger, now, weekday, p); err != nil {
+			if err := r.record(ctx, logger, taskID, now, weekday, p); err != nil {
 				return errors.Wrap(err, "failed to record")
 			}
 			return nil
@@ -246,8 +342,19 @@ func (r *Recorder) Record(p config.Program) (err error) {
 	return nil
 }
 
-func (r *Recorder) record(ctx context.Context, logger zerolog.Logger, now time.Time, weekday timeutil.Weekday, p config.Program) error {
-	logger = logger.With().Str("weekday", weekday.String()).Str("sub_task_id", xid.New().String()).Logger()
+func (r *Recorder) record(ctx context.Context, logger zerolog.Logger, taskID string, now time.Time, weekday timeutil.Weekday, p config.Program) (err error) {
+	subTaskID := xid.New().String()
+	logger = logger.With().Str("weekday", weekday.String()).Str("sub_task_id", subTaskID).Logger()
+	j := r.startJob(job.Job{
+		ID:        subTaskID,
+		ParentID:  taskID,
+		Kind:      job.KindRecord,
+		Program:   p.Title,
+		StationID: p.StationID,
+	})
+	defer func() {
+		r.finishJob(j, err)
+	}()
 
 	targetDay, err := timeutil.LastSpecifiedWeekday(weekday, now)
 	if err != nil {
@@ -262,9 +369,10 @@ func (r *Recorder) record(ctx context.Context, logger zerolog.Logger, now time.T
 	if err != nil {
 		return errors.Wrap(err, "failed to parse start time")
 	}
+	j.From = &from
 
 	// NOTE: Radikoのクライアントは毎回初期化しないと、認証エラーになってしまう
-	client, err := radikoutil.NewClient(
+	client, err := r.NewRadikoClient(
 		ctx,
 		radikoutil.WithAreaID(p.AreaID),
 		radikoutil.WithPremium(r.radikoEmail, r.radikoPassword),
@@ -287,9 +395,12 @@ func (r *Recorder) record(ctx context.Context, logger zerolog.Logger, now time.T
 		ctx,
 		logger,
 		client,
+		j,
+		false,
 		false,
 		program,
 		p.Title, p.ImageURL, p.StationID, p.Encoding, p.Path,
+		p.PostProcess,
 		from,
 	); err != nil {
 		return err
@@ -301,9 +412,12 @@ func (r *Recorder) recordByStartTime(
 	ctx context.Context,
 	logger zerolog.Logger,
 	client *radiko.Client,
+	j *job.Job,
 	zenrokuMode bool,
+	byTimeRange bool,
 	program *radiko.Prog,
 	podcastTitle, imageURL, stationID, encoding, path string,
+	postProcess ffmpeg.Pipeline,
 	from time.Time,
 ) error {
 	logger.Info().
@@ -313,25 +427,20 @@ func (r *Recorder) recordByStartTime(
 		Str("program_to", program.To).
 		Msg("program found")
 
-	mode := "normal"
-	if zenrokuMode {
-		mode = "zenroku"
+	profile, err := r.Config().Profile(encoding)
+	if err != nil {
+		return err
 	}
-	fileName := fmt.Sprintf(
-		"%s_%s_%s.%s",
-		program.Title,
-		from.Format("2006年01月02日"),
-		mode,
-		encoding,
-	)
-	output := filepath.Join(r.targetDir, fileName)
-
-	if _, err := os.Stat(output); err == nil {
-		logger.Info().Str("output", output).Msg("file already exists")
-		return nil
+	output := recordingFileName(program.Title, from, zenrokuMode, profile.Ext())
+	if skipped, err := r.skipIfRecorded(ctx, logger, j, output); err != nil || skipped {
+		return err
 	}
 
-	uri, err := client.TimeshiftPlaylistM3U8(ctx, stationID, from)
+	ft, to, err := ParseProgTime(program)
+	if err != nil {
+		return err
+	}
+	uri, err := timeshiftPlaylistM3U8(ctx, client, stationID, from, ft, to, byTimeRange)
 	if err != nil {
 		return errors.Wrap(
 			err,
@@ -348,126 +457,257 @@ func (r *Recorder) recordByStartTime(
 		return errors.Wrap(err, "failed to get chunklist")
 	}
 
-	aacDir, err := os.MkdirTemp(os.TempDir(), "radicaster")
+	workDirPath := r.workDirPath(stationID, ft)
+	unlock := r.lockWorkDir(workDirPath)
+	defer unlock()
+	w, err := r.openRecordingWorkDir(workDirPath)
 	if err != nil {
-		return errors.Wrap(err, "failed to create temp dir")
+		return errors.Wrap(err, "failed to open work dir")
 	}
-	defer os.RemoveAll(aacDir)
-	logger.Debug().Str("aac_temp_dir", aacDir).Msg("created temp dir")
+	defer w.close()
+	logger.Debug().
+		Str("work_dir", w.dir).
+		Int("downloaded_chunks", w.downloadedCount()).
+		Int("total_chunks", len(chunkURLs)).
+		Msg("opened work dir")
 
-	if err := r.bulkDownload(chunkURLs, aacDir); err != nil {
-		return errors.Wrap(err, "failed to download aac files")
+	concatedFile, err := r.concatChunks(ctx, logger, w, chunkURLs)
+	if err != nil {
+		return err
 	}
 
-	logger.Info().Msg("start concating aac files")
-	var concatedFile string
-	if iterCount, _, err := lo.AttemptWithDelay(
-		10,
-		10*time.Second,
-		func(i int, dur time.Duration) error {
-			var err error
-			logger.Info().Dur("duration", dur).Int("iter_count", i).Msg("concating aac files")
-			concatedFile, err = ffmpeg.ConcatAACFilesFromList(ctx, logger, aacDir)
-			if err != nil {
-				logger.Error().
-					Err(err).
-					Str("stack", fmt.Sprintf("%+v", errors.WithStack(err))).
-					Msg("failed to concat aac files")
-				return errors.Wrap(err, "failed to concat aac files")
-			}
-			return nil
-		}); err != nil {
-		return errors.Wrapf(err, "failed to concat aac files after %d times", iterCount)
+	// NOTE: タイムフリーは番組の開始時刻からのチャンクを取得するので、ファイルの先頭が番組の開始になる
+	boundary := ffmpeg.Boundary{Duration: to.Sub(ft)}
+	chapters := programChapters(ctx, logger, client, stationID, program, ft, to)
+	return r.saveRecording(ctx, logger, j, w, concatedFile, output, profile, postProcess, boundary, metadata.EpisodeMetadata{
+		Title:        program.Title,
+		Description:  program.Desc,
+		PublishedAt:  from,
+		EndedAt:      &to,
+		Performer:    program.Pfm,
+		StationID:    stationID,
+		ProgramURL:   program.URL,
+		ImageURL:     imageURL,
+		Path:         path,
+		PodcastTitle: podcastTitle,
+		ZenrokuMode:  zenrokuMode,
+		Chapters:     chapters,
+	})
+}
+
+// timeshiftPlaylistM3U8 は録音する時間帯のタイムフリーのプレイリストのURIを返す。
+// byTimeRange が false の場合は radiko.Client で番組の開始時刻 from から取得し、
+// true の場合は番組表と違ってもよい ft から to までの時間帯を指定して取得する。
+func timeshiftPlaylistM3U8(ctx context.Context, client *radiko.Client, stationID string, from, ft, to time.Time, byTimeRange bool) (string, error) {
+	if byTimeRange {
+		return radikoutil.TimeshiftPlaylistM3U8(ctx, client, stationID, ft, to)
 	}
-	logger.Info().Msg("finished concating aac files")
+	return client.TimeshiftPlaylistM3U8(ctx, stationID, from)
+}
 
-	switch encoding {
-	case config.AudioFormatAAC:
-		logger.Info().
-			Str("output", output).
-			Msg("start outputting aac")
-		absPath, err := filepath.Abs(output)
-		if err != nil {
-			return errors.Wrap(err, "failed to get abs path")
+// concatChunks はチャンクを作業ディレクトリにダウンロードして結合し、結合したファイルのパスを返す。
+// NOTE: 結合済みのファイルがあればダウンロードと結合は終わっているので、変換から再開する
+func (r *Recorder) concatChunks(ctx context.Context, logger zerolog.Logger, w *recordingWorkDir, chunkURLs []string) (string, error) {
+	concatedFile := w.concatedFile()
+	if _, err := os.Stat(concatedFile); err != nil {
+		if err := r.bulkDownload(ctx, chunkURLs, w); err != nil {
+			return "", errors.Wrap(err, "failed to download aac files")
 		}
-		if err := os.Rename(concatedFile, absPath); err != nil {
-			return errors.Wrap(err, "failed to rename file")
+
+		chunkFiles := make([]string, 0, len(chunkURLs))
+		for i, u := range chunkURLs {
+			chunkFiles = append(chunkFiles, w.chunkPath(i, u))
 		}
-		logger.Info().Msg("finish outputting aac")
-	case config.AudioFormatMP3:
-		logger.Info().
-			Str("output", output).
-			Msg("start converting aac to mp3")
+
+		logger.Info().Msg("start concating aac files")
 		if iterCount, _, err := lo.AttemptWithDelay(
 			10,
-			3*time.Second,
+			10*time.Second,
 			func(i int, dur time.Duration) error {
-				logger.Info().Dur("duration", dur).Int("iter_count", i).Msg("converting aac to mp3")
-				if err := radigo.ConvertAACtoMP3(ctx, concatedFile, output); err != nil {
-					return errors.Wrap(err, "failed to convert aac to mp3")
+				logger.Info().Dur("duration", dur).Int("iter_count", i).Msg("concating aac files")
+				partial := concatedFile + ".part.aac"
+				if err := ffmpeg.ConcatAACFilesFromList(ctx, logger, chunkFiles, partial); err != nil {
+					logger.Error().
+						Err(err).
+						Str("stack", fmt.Sprintf("%+v", errors.WithStack(err))).
+						Msg("failed to concat aac files")
+					return errors.Wrap(err, "failed to concat aac files")
 				}
-				return nil
+				return os.Rename(partial, concatedFile)
 			}); err != nil {
-			return errors.Wrapf(err, "failed to convert aac to mp3 after %d times", iterCount)
+			return "", errors.Wrapf(err, "failed to concat aac files after %d times", iterCount)
 		}
-		logger.Info().Msg("finish converting aac to mp3")
-	default:
-		return errors.Errorf("unsupported encoding: %s", encoding)
-	}
-
-	if err := metadata.WriteByAudioFilePath(
-		output,
-		metadata.EpisodeMetadata{
-			Title:        program.Title,
-			Description:  program.Desc,
-			PublishedAt:  from,
-			ImageURL:     imageURL,
-			Path:         path,
-			PodcastTitle: podcastTitle,
-			ZenrokuMode:  zenrokuMode,
-		},
-	); err != nil {
-		return errors.Wrap(err, "failed to write metadata")
+		logger.Info().Msg("finished concating aac files")
+	} else {
+		logger.Info().Str("concated_file", concatedFile).Msg("resume from concated file")
 	}
-	return nil
+	return concatedFile, nil
 }
 
-func (r *Recorder) bulkDownload(urls []string, output string) error {
-	p := pool.New().WithErrors()
+// recordingFileName は録音したエピソードのファイル名を返す。
+// NOTE: タイムフリーとライブのどちらで録音しても同じ名前になるので、同じ放送を二重に録音しない
+func recordingFileName(title string, from time.Time, zenrokuMode bool, ext string) string {
+	mode := "normal"
+	if zenrokuMode {
+		mode = "zenroku"
+	}
+	return fmt.Sprintf(
+		"%s_%s_%s.%s",
+		title,
+		from.Format("2006年01月02日"),
+		mode,
+		ext,
+	)
+}
 
-	for i, url := range urls {
-		i, url := i, url
-		p.Go(func() error {
-			if err := r.download(url, output); err != nil {
-				return errors.Wrapf(err, "failed to download %d", i)
+// isRecorded は output が既に保存されているかを返す。
+func (r *Recorder) isRecorded(ctx context.Context, output string) (bool, error) {
+	if _, err := r.storage.Stat(ctx, output); err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to stat output")
+	}
+	return true, nil
+}
+
+// skipIfRecorded は output が既に保存されていればジョブをスキップにする。
+func (r *Recorder) skipIfRecorded(ctx context.Context, logger zerolog.Logger, j *job.Job, output string) (bool, error) {
+	if recorded, err := r.isRecorded(ctx, output); err != nil || !recorded {
+		return false, err
+	}
+	logger.Info().Str("output", output).Msg("file already exists")
+	j.Status = job.StatusSkipped
+	j.Output = output
+	return true, nil
+}
+
+// saveRecording は作業ディレクトリで録音した input を encoding に変換してストレージの output に保存し、メタデータを書き込む。
+func (r *Recorder) saveRecording(
+	ctx context.Context,
+	logger zerolog.Logger,
+	j *job.Job,
+	w *recordingWorkDir,
+	input, output string,
+	profile ffmpeg.Profile,
+	postProcess ffmpeg.Pipeline,
+	boundary ffmpeg.Boundary,
+	md metadata.EpisodeMetadata,
+) error {
+	filters, kept, err := postProcess.Filters(ctx, logger, input, boundary)
+	if err != nil {
+		return errors.Wrap(err, "failed to build post process filters")
+	}
+	if len(md.Chapters) > 0 {
+		// NOTE: 切り詰めない場合は録音したファイルがそのまま保存するファイルの長さになる
+		length := kept.Duration
+		if length == 0 {
+			if length, err = ffmpeg.ProbeDuration(ctx, input); err != nil {
+				logger.Warn().Err(err).Msg("failed to probe duration for chapters")
+				length = 0
+			}
+		}
+		md.Chapters = fitChapters(md.Chapters, boundary.Offset-kept.Offset, length)
+	}
+
+	tags := md.Tags()
+	if md.ImageURL != "" {
+		// NOTE: 画像が取れなくても録音は保存する
+		if coverArt, err := r.prepareCoverArt(ctx, logger, w, md.ImageURL); err != nil {
+			logger.Warn().Err(err).Str("image_url", md.ImageURL).Msg("failed to download cover art")
+		} else {
+			tags.CoverArt = coverArt
+		}
+	}
+
+	logger.Info().
+		Str("output", output).
+		Str("codec", profile.Codec).
+		Strs("filters", filters).
+		Msg("start transcoding")
+	encoded := filepath.Join(w.dir, "transcoded."+profile.Ext())
+	if iterCount, _, err := lo.AttemptWithDelay(
+		10,
+		3*time.Second,
+		func(i int, dur time.Duration) error {
+			logger.Info().Dur("duration", dur).Int("iter_count", i).Msg("transcoding")
+			err := ffmpeg.Transcode(ctx, logger, input, encoded, profile, tags, filters...)
+			if err != nil && tags.CoverArt != "" {
+				// NOTE: 画像を埋め込めないせいで録音を失わないように、画像を外してやり直す
+				logger.Warn().Err(err).Str("cover_art", tags.CoverArt).Msg("failed to transcode with cover art, retrying without it")
+				tags.CoverArt = ""
+				err = ffmpeg.Transcode(ctx, logger, input, encoded, profile, tags, filters...)
+			}
+			if err != nil {
+				return errors.Wrap(err, "failed to transcode")
 			}
 			return nil
-		})
+		}); err != nil {
+		return errors.Wrapf(err, "failed to transcode after %d times", iterCount)
+	}
+	logger.Info().Msg("finish transcoding")
+
+	// NOTE: 実際の長さは番組表の放送時間や後処理で変わるので、保存するファイルから調べる
+	duration, err := ffmpeg.ProbeDuration(ctx, encoded)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to probe duration, falling back to the broadcast time")
+		duration = 0
+	}
+	md.DurationSeconds = duration.Seconds()
+
+	logger.Info().
+		Str("output", output).
+		Msg("start saving the recording")
+	size, err := r.putFile(ctx, output, encoded)
+	if err != nil {
+		return errors.Wrap(err, "failed to save the recording")
+	}
+	logger.Info().Msg("finish saving the recording")
+
+	// NOTE: メタデータが見つかった時には音声ファイルが揃っているように、音声ファイルの後に保存する
+	if err := metadata.Write(ctx, r.storage, output, md); err != nil {
+		// NOTE: 音声ファイルだけ残ると録音済みとしてスキップされてしまうので、消してやり直せるようにする
+		if err := r.storage.Delete(ctx, output); err != nil {
+			logger.Error().Err(err).Str("output", output).Msg("failed to delete the recording without metadata")
+		}
+		return errors.Wrap(err, "failed to write metadata")
 	}
-	if err := p.Wait(); err != nil {
-		return errors.Wrap(err, "failed to download aac files")
+
+	if err := w.remove(); err != nil {
+		logger.Warn().Err(err).Str("work_dir", w.dir).Msg("failed to remove work dir")
 	}
+
+	j.Output = output
+	j.Bytes = size
+	r.syncAfterSaved(ctx, output)
 	return nil
 }
 
-func (r *Recorder) download(link, output string) error {
-	resp, err := r.httpClient.Get(link)
+// putFile はローカルのファイルをストレージの key に保存して、そのサイズを返す。
+func (r *Recorder) putFile(ctx context.Context, key, file string) (int64, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		return err
+		return 0, errors.Wrap(err, "failed to open file")
 	}
-	defer resp.Body.Close()
-
-	_, fileName := filepath.Split(link)
-	file, err := os.Create(filepath.Join(output, fileName))
+	defer f.Close()
+	stat, err := f.Stat()
 	if err != nil {
-		return err
+		return 0, errors.Wrap(err, "failed to stat file")
+	}
+	if err := r.storage.Put(ctx, key, f, stat.Size()); err != nil {
+		return 0, err
 	}
+	return stat.Size(), nil
+}
 
-	_, err = io.Copy(file, resp.Body)
-	if closeErr := file.Close(); err == nil {
-		err = closeErr
+// syncAfterSaved は保存した録音をフィードに反映する。
+func (r *Recorder) syncAfterSaved(ctx context.Context, key string) {
+	if r.syncer == nil {
+		return
+	}
+	if err := r.syncer.Update(ctx, key); err != nil {
+		r.logger.Error().Err(err).Msg("failed to sync after saving the recording")
 	}
-	return err
 }
 
 func (r *Recorder) restartScheduler() error {
@@ -481,11 +721,48 @@ func (r *Recorder) restartScheduler() error {
 			return errors.Wrapf(err, "failed to set cron: %s", cron)
 		}
 	}
+	autoScheduled := false
 	for _, p := range r.config.Config.Programs {
+		if p.IsLive() {
+			exprs, err := liveCronExpressions(p)
+			if err != nil {
+				return errors.Wrapf(err, "failed to schedule live recording: program_title=%s", p.Title)
+			}
+			for _, expr := range exprs {
+				if _, err := s.Cron(expr).Do(r.startLiveRecording, p); err != nil {
+					return errors.Wrapf(err, "failed to set cron: %s", expr)
+				}
+			}
+			continue
+		}
+		if p.Cron == "" {
+			autoScheduled = true
+			continue
+		}
 		if _, err := s.Cron(p.Cron).Do(r.Record, p); err != nil {
 			return errors.Wrap(err, "failed to set cron")
 		}
 	}
+	// NOTE: 設定が変わった番組の古い録音が残らないように、番組表からの録音は全て設定し直す
+	r.autoSchedules.stopAll()
+	if autoScheduled {
+		if _, err := s.Every(autoScheduleRefreshInterval).Do(r.scheduleAutoRecordings); err != nil {
+			return errors.Wrap(err, "failed to schedule recordings from the program guide")
+		}
+	}
+	for _, rule := range r.config.Config.Rules {
+		if _, err := s.Cron(rule.Cron).Do(r.RecordByRule, rule); err != nil {
+			return errors.Wrapf(err, "failed to set cron: %s", rule.Cron)
+		}
+	}
+	if _, err := s.Cron(workDirCleanupCronExpression).Do(r.cleanupWorkDirs); err != nil {
+		return errors.Wrapf(err, "failed to set cron: %s", workDirCleanupCronExpression)
+	}
+	if hasRetention(r.config.Config) {
+		if _, err := s.Cron(retentionCronExpression).Do(r.Prune); err != nil {
+			return errors.Wrapf(err, "failed to set cron: %s", retentionCronExpression)
+		}
+	}
 
 	r.scheduler.Lock()
 	defer r.scheduler.Unlock()
@@ -527,9 +804,20 @@ func (r *Recorder) RefreshConfig(c config.Config) (config.Config, error) {
 	if err := r.refreshLocalConfig(updatedConfig); err != nil {
 		return config.Config{}, errors.Wrap(err, "failed to refresh local config")
 	}
+	r.syncAfterConfigRefreshed()
 	return updatedConfig, nil
 }
 
+// syncAfterConfigRefreshed はチャンネルの情報などの設定の変更をフィードに反映する。
+func (r *Recorder) syncAfterConfigRefreshed() {
+	if r.syncer == nil {
+		return
+	}
+	if err := r.syncer.Sync(); err != nil {
+		r.logger.Error().Err(err).Msg("failed to sync after refreshing config")
+	}
+}
+
 func (r *Recorder) refreshLocalConfig(c config.Config) error {
 	if r.configFilePath == "" {
 		r.logger.Debug().
@@ -569,5 +857,10 @@ func (r *Recorder) RefreshConfigByURL(configURL string) (config.Config, error) {
 		return config, errors.Wrap(err, "failed to parse config")
 	}
 
-	return r.refreshConfig(config)
+	updatedConfig, err := r.refreshConfig(config)
+	if err != nil {
+		return updatedConfig, err
+	}
+	r.syncAfterConfigRefreshed()
+	return updatedConfig, nil
 }
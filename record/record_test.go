@@ -1,49 +1,345 @@
 package record
 
 import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/rs/zerolog"
 	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/radikoutil/radikotest"
+	"github.com/upamune/radicaster/storage"
 	"github.com/upamune/radicaster/timeutil"
 )
 
+func skipIfNoFFmpeg(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+}
+
 func TestNewRecord(t *testing.T) {
+	t.Parallel()
+	skipIfNoFFmpeg(t)
+
+	now := time.Now().In(timeutil.JST()).AddDate(0, 0, -1)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 3, 0, 0, 0, timeutil.JST())
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:    "LFR",
+		Name:  "ニッポン放送",
+		Progs: []radikotest.Prog{radikotest.NewProg("オールナイトニッポン", from, time.Minute)},
+	})
+	defer srv.Close()
+
+	targetDir := t.TempDir()
 	r, err := NewRecorder(
 		zerolog.New(zerolog.NewConsoleWriter()).Level(zerolog.DebugLevel),
-		t.TempDir(),
+		storage.NewLocal(targetDir),
 		"",
 		"",
 		config.Config{
 			Programs: []config.Program{},
 		},
 		"",
+		WithRadikoEndpoint(srv.URL),
+		WithWorkDir(t.TempDir()),
 	)
 	if err != nil {
 		t.Fatalf("%+v\n", errors.WithStack(err))
 	}
 
-	now := time.Now().AddDate(0, 0, -1)
 	if err := r.Record(config.Program{
 		Cron:      "",
 		Weekdays:  []timeutil.Weekday{timeutil.Weekday(now.Weekday())},
 		StationID: "LFR",
 		Start:     "0300",
 		Encoding:  config.AudioFormatAAC,
+		Path:      "ann",
+	}); err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+
+	output := filepath.Join(targetDir, "オールナイトニッポン_"+from.Format("2006年01月02日")+"_normal.m4a")
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("output is not found: %v", err)
+	}
+	md, err := metadata.ReadByAudioFilePath(output)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if got, want := md.FeedPath(), "ann"; got != want {
+		t.Errorf("FeedPath() = %s, want %s", got, want)
+	}
+	if got, want := srv.ChunkRequests(), int(time.Minute/radikotest.ChunkDuration); got != want {
+		t.Errorf("ChunkRequests() = %d, want %d", got, want)
+	}
+}
+
+// TestRecord_BroadcastTime は番組表の曜日と 27:00 のような時刻で設定した番組を録音する。
+func TestRecord_BroadcastTime(t *testing.T) {
+	t.Parallel()
+	skipIfNoFFmpeg(t)
+
+	now := time.Now().In(timeutil.JST()).AddDate(0, 0, -1)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 3, 0, 0, 0, timeutil.JST())
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:    "LFR",
+		Name:  "ニッポン放送",
+		Progs: []radikotest.Prog{radikotest.NewProg("オールナイトニッポン", from, time.Minute)},
+	})
+	defer srv.Close()
+
+	targetDir := t.TempDir()
+	r, err := NewRecorder(zerolog.Nop(), storage.NewLocal(targetDir), "", "", config.Config{}, "", WithRadikoEndpoint(srv.URL), WithWorkDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+
+	// NOTE: 昨日の3時は、番組表では一昨日の27:00
+	if err := r.Record(config.Program{
+		Weekdays:  []timeutil.Weekday{timeutil.Weekday(now.AddDate(0, 0, -1).Weekday())},
+		StationID: "LFR",
+		Start:     "27:00",
+		Encoding:  config.AudioFormatAAC,
 	}); err != nil {
 		t.Fatalf("%+v\n", errors.WithStack(err))
 	}
+
+	output := filepath.Join(targetDir, "オールナイトニッポン_"+from.Format("2006年01月02日")+"_normal.m4a")
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("output is not found: %v", err)
+	}
 }
 
 func TestRecorder_RecordAll(t *testing.T) {
 	t.Parallel()
-	r, err := NewRecorder(zerolog.Nop(), t.TempDir(), "", "", config.Config{}, "")
+	skipIfNoFFmpeg(t)
+
+	targetDate := time.Now().In(timeutil.JST()).AddDate(0, 0, -1).Truncate(24 * time.Hour)
+	srv := radikotest.NewServer(
+		radikotest.Station{
+			ID:   "LFR",
+			Name: "ニッポン放送",
+			Progs: []radikotest.Prog{
+				radikotest.NewProg("朝の番組", targetDate, 30*time.Second),
+				radikotest.NewProg("昼の番組", targetDate.Add(time.Hour), 30*time.Second),
+			},
+		},
+		radikotest.Station{
+			ID:    "TBS",
+			Name:  "TBSラジオ",
+			Progs: []radikotest.Prog{radikotest.NewProg("録音しない番組", targetDate, 30*time.Second)},
+		},
+	)
+	defer srv.Close()
+
+	targetDir := t.TempDir()
+	r, err := NewRecorder(
+		zerolog.Nop(), storage.NewLocal(targetDir), "", "",
+		config.Config{
+			Zenroku: config.Zenroku{
+				Enable:           true,
+				Cron:             "0 5 * * *",
+				EnableStationIDs: []string{"LFR"},
+				Encoding:         config.AudioFormatAAC,
+			},
+		},
+		"",
+		WithRadikoEndpoint(srv.URL),
+		WithWorkDir(t.TempDir()),
+	)
 	if err != nil {
 		t.Fatalf("%+v\n", errors.WithStack(err))
 	}
 	if err := r.RecordAll(); err != nil {
 		t.Fatalf("%+v\n", errors.WithStack(err))
 	}
+
+	files, err := filepath.Glob(filepath.Join(targetDir, "*.m4a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(files), 2; got != want {
+		t.Errorf("recorded files = %d, want %d: %v", got, want, files)
+	}
+}
+
+// TestRecorder_RecordAll_DayMode は局の放送日の番組を1つのファイルにして、番組をチャプターにする。
+func TestRecorder_RecordAll_DayMode(t *testing.T) {
+	t.Parallel()
+	skipIfNoFFmpeg(t)
+
+	targetDate := time.Now().In(timeutil.JST()).AddDate(0, 0, -1).Truncate(24 * time.Hour)
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:   "LFR",
+		Name: "ニッポン放送",
+		Progs: []radikotest.Prog{
+			radikotest.NewProg("朝の番組", targetDate, 30*time.Second),
+			radikotest.NewProg("昼の番組", targetDate.Add(30*time.Second), 30*time.Second),
+		},
+	})
+	defer srv.Close()
+
+	targetDir := t.TempDir()
+	r, err := NewRecorder(
+		zerolog.Nop(), storage.NewLocal(targetDir), "", "",
+		config.Config{
+			Zenroku: config.Zenroku{
+				Enable:           true,
+				Cron:             "0 5 * * *",
+				EnableStationIDs: []string{"LFR"},
+				Encoding:         config.AudioFormatAAC,
+				Mode:             config.ZenrokuModeDay,
+			},
+		},
+		"",
+		WithRadikoEndpoint(srv.URL),
+		WithWorkDir(t.TempDir()),
+	)
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+	if err := r.RecordAll(); err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+
+	files, err := filepath.Glob(filepath.Join(targetDir, "*.m4a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("recorded files = %v, want 1 file", files)
+	}
+	md, err := metadata.ReadByAudioFilePath(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := md.FeedPath(); got != "zenroku/lfr" {
+		t.Errorf("FeedPath() = %s, want zenroku/lfr", got)
+	}
+	if len(md.Chapters) != 2 || md.Chapters[0].Title != "朝の番組" || md.Chapters[1].Title != "昼の番組" || md.Chapters[1].StartSeconds != 30 {
+		t.Errorf("Chapters = %+v", md.Chapters)
+	}
+}
+
+// TestRecorder_ScheduleAutoRecordings は cron を省略した番組を番組表の終了時刻から録音するように設定する。
+func TestRecorder_ScheduleAutoRecordings(t *testing.T) {
+	t.Parallel()
+
+	from := time.Now().In(timeutil.JST()).Add(time.Hour).Truncate(time.Minute)
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:    "LFR",
+		Name:  "ニッポン放送",
+		Progs: []radikotest.Prog{radikotest.NewProg("オールナイトニッポン", from, 2*time.Hour)},
+	})
+	defer srv.Close()
+
+	weekday, start := timeutil.BroadcastClock(from)
+	p := config.Program{
+		Title:     "オールナイトニッポン",
+		Weekdays:  []timeutil.Weekday{timeutil.Weekday(weekday)},
+		StationID: "LFR",
+		Start:     strings.Replace(start, ":", "", 1),
+		Encoding:  config.AudioFormatAAC,
+	}
+	r, err := NewRecorder(
+		zerolog.Nop(), storage.NewLocal(t.TempDir()), "", "",
+		config.Config{Programs: []config.Program{p}},
+		"",
+		WithRadikoEndpoint(srv.URL),
+		WithWorkDir(t.TempDir()),
+		WithRecordDelay(5*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+	t.Cleanup(r.autoSchedules.stopAll)
+
+	r.scheduleAutoRecordings()
+	b := broadcast{program: p, slot: from, from: from, to: from.Add(2 * time.Hour)}
+	runAt, ok := r.autoSchedules.runAt(b.key())
+	if !ok {
+		t.Fatalf("recording is not scheduled: %s", b.key())
+	}
+	if want := b.to.Add(5 * time.Minute); !runAt.Equal(want) {
+		t.Errorf("runAt = %v, want %v", runAt, want)
+	}
+
+	// NOTE: 番組表より早く終わる予定だった録音は、延長された終了時刻に合わせてずらす
+	r.runAutoRecording(broadcast{program: p, slot: from, from: from, to: from.Add(time.Hour)})
+	runAt, ok = r.autoSchedules.runAt(b.key())
+	if !ok {
+		t.Fatalf("recording is not rescheduled: %s", b.key())
+	}
+	if want := b.to.Add(5 * time.Minute); !runAt.Equal(want) {
+		t.Errorf("rescheduled runAt = %v, want %v", runAt, want)
+	}
+
+	r.config.Lock()
+	r.config.Config.Programs = nil
+	r.config.Unlock()
+	r.scheduleAutoRecordings()
+	if _, ok := r.autoSchedules.runAt(b.key()); ok {
+		t.Errorf("recording of the removed program is still scheduled")
+	}
+}
+
+// TestRecorder_ScheduleAutoRecordings_WithinDelay は放送が終わって録音を待っている間に番組表を見直しても、録音を取り消さない。
+func TestRecorder_ScheduleAutoRecordings_WithinDelay(t *testing.T) {
+	t.Parallel()
+
+	from := time.Now().In(timeutil.JST()).Add(-32 * time.Minute).Truncate(time.Minute)
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:    "LFR",
+		Name:  "ニッポン放送",
+		Progs: []radikotest.Prog{radikotest.NewProg("オールナイトニッポン", from, 30*time.Minute)},
+	})
+	defer srv.Close()
+
+	weekday, start := timeutil.BroadcastClock(from)
+	p := config.Program{
+		Title:     "オールナイトニッポン",
+		Weekdays:  []timeutil.Weekday{timeutil.Weekday(weekday)},
+		StationID: "LFR",
+		Start:     strings.Replace(start, ":", "", 1),
+		Encoding:  config.AudioFormatAAC,
+	}
+	r, err := NewRecorder(
+		zerolog.Nop(), storage.NewLocal(t.TempDir()), "", "",
+		config.Config{Programs: []config.Program{p}},
+		"",
+		WithRadikoEndpoint(srv.URL),
+		WithWorkDir(t.TempDir()),
+		WithRecordDelay(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+	t.Cleanup(r.autoSchedules.stopAll)
+
+	b := broadcast{program: p, slot: from, from: from, to: from.Add(30 * time.Minute)}
+	want := b.to.Add(time.Hour)
+	for name, refresh := range map[string]func(){
+		"refresh": r.scheduleAutoRecordings,
+		// NOTE: 設定を変えると全ての録音を止めてから番組表を見直す
+		"config changed": func() {
+			r.autoSchedules.stopAll()
+			r.scheduleAutoRecordings()
+		},
+	} {
+		refresh()
+		runAt, ok := r.autoSchedules.runAt(b.key())
+		if !ok {
+			t.Fatalf("%s: recording waiting for the delay is not scheduled: %s", name, b.key())
+		}
+		if !runAt.Equal(want) {
+			t.Errorf("%s: runAt = %v, want %v", name, runAt, want)
+		}
+	}
 }
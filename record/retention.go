@@ -0,0 +1,193 @@
+package record
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/xid"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/job"
+	"github.com/upamune/radicaster/metadata"
+)
+
+const retentionCronExpression = "30 * * * *"
+
+type episodeFile struct {
+	path        string
+	size        int64
+	publishedAt time.Time
+}
+
+// Prune は保持ポリシーを超えたエピソードを削除する。
+func (r *Recorder) Prune() (err error) {
+	var (
+		taskStartedTime = time.Now()
+		taskID          = xid.New().String()
+		logger          = r.logger.With().
+				Str("task_id", taskID).
+				Str("task", "prune").
+				Logger()
+	)
+	logger.Info().
+		Time("task_started_time", taskStartedTime).
+		Msg("prune task started")
+	j := r.startJob(job.Job{ID: taskID, Kind: job.KindPrune})
+	defer func() {
+		r.finishJob(j, err)
+		taskFinishedTime := time.Now()
+		logger := logger.With().
+			Time("task_started_time", taskStartedTime).
+			Time("task_finished_time", taskFinishedTime).
+			Dur("task_duration", taskFinishedTime.Sub(taskStartedTime)).Logger()
+
+		if err != nil {
+			logger.Error().Err(err).Msg("prune task finished with an error")
+			return
+		}
+		logger.Info().Msg("prune task finished")
+	}()
+
+	r.config.RLock()
+	c := r.config.Config
+	r.config.RUnlock()
+
+	ctx := context.Background()
+	groupedEpisodes, err := r.listEpisodeFiles(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list episodes")
+	}
+
+	now := time.Now()
+	var (
+		removedKeys []string
+		errs        []error
+	)
+	for feedPath, episodes := range groupedEpisodes {
+		retention, ok := retentionForFeedPath(c, feedPath)
+		if !ok {
+			continue
+		}
+		for _, ep := range selectExpiredEpisodes(episodes, retention, now) {
+			logger.Info().
+				Str("feed_path", feedPath).
+				Str("path", ep.path).
+				Time("published_at", ep.publishedAt).
+				Int64("size", ep.size).
+				Msg("remove expired episode")
+			if err := r.storage.Delete(ctx, ep.path); err != nil {
+				errs = append(errs, errors.Wrapf(err, "failed to remove %s", ep.path))
+				continue
+			}
+			if err := metadata.Remove(ctx, r.storage, ep.path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			removedKeys = append(removedKeys, ep.path)
+		}
+	}
+
+	if len(removedKeys) > 0 && r.syncer != nil {
+		if err := r.syncer.Update(ctx, removedKeys...); err != nil {
+			errs = append(errs, errors.Wrap(err, "failed to sync podcast"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Recorder) listEpisodeFiles(ctx context.Context) (map[string][]episodeFile, error) {
+	objects, err := r.storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groupedEpisodes := make(map[string][]episodeFile)
+	for _, obj := range objects {
+		if metadata.IsMetadataKey(obj.Key) {
+			continue
+		}
+		// NOTE: メタデータが無いファイルはどの番組のものか分からないので対象外
+		md, err := metadata.Read(ctx, r.storage, obj.Key)
+		if err != nil {
+			continue
+		}
+		feedPath := md.FeedPath()
+		groupedEpisodes[feedPath] = append(groupedEpisodes[feedPath], episodeFile{
+			path:        obj.Key,
+			size:        obj.Size,
+			publishedAt: md.PublishedAt,
+		})
+	}
+	return groupedEpisodes, nil
+}
+
+func hasRetention(c config.Config) bool {
+	if !c.Zenroku.Retention.IsZero() {
+		return true
+	}
+	for _, p := range c.Programs {
+		if !p.Retention.IsZero() {
+			return true
+		}
+	}
+	for _, rule := range c.Rules {
+		if !rule.Retention.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+func retentionForFeedPath(c config.Config, feedPath string) (config.Retention, bool) {
+	if strings.HasPrefix(feedPath, "zenroku/") {
+		return c.Zenroku.Retention, !c.Zenroku.Retention.IsZero()
+	}
+	for _, p := range c.Programs {
+		if p.Retention.IsZero() {
+			continue
+		}
+		if strings.ToLower(strings.TrimPrefix(p.Path, "/")) == feedPath {
+			return p.Retention, true
+		}
+	}
+	for _, rule := range c.Rules {
+		if rule.Retention.IsZero() {
+			continue
+		}
+		if strings.ToLower(strings.TrimPrefix(rule.Path, "/")) == feedPath {
+			return rule.Retention, true
+		}
+	}
+	return config.Retention{}, false
+}
+
+// selectExpiredEpisodes は新しい順に数えて保持ポリシーを超えたエピソードを返す。
+// 容量を超えたエピソードから先は、それより古いエピソードも全て期限切れにする。
+func selectExpiredEpisodes(episodes []episodeFile, retention config.Retention, now time.Time) []episodeFile {
+	sorted := slices.Clone(episodes)
+	slices.SortStableFunc(sorted, func(a, b episodeFile) int {
+		// NOTE: 降順にしたいので逆にしている
+		return b.publishedAt.Compare(a.publishedAt)
+	})
+
+	var (
+		expired    []episodeFile
+		totalBytes int64
+		overBytes  bool
+	)
+	for i, ep := range sorted {
+		totalBytes += ep.size
+		// NOTE: 新しいエピソードを消して古いエピソードを残すとフィードに穴が空くので、超えたところで打ち切る
+		if retention.MaxTotalBytes > 0 && totalBytes > retention.MaxTotalBytes {
+			overBytes = true
+		}
+		switch {
+		case overBytes,
+			retention.MaxEpisodes > 0 && i >= retention.MaxEpisodes,
+			retention.MaxAgeDays > 0 && now.Sub(ep.publishedAt) > time.Duration(retention.MaxAgeDays)*24*time.Hour:
+			expired = append(expired, ep)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,64 @@
+package record
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/upamune/radicaster/config"
+)
+
+func TestSelectExpiredEpisodes(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2023, time.September, 25, 0, 0, 0, 0, time.UTC)
+	episodes := []episodeFile{
+		{path: "3", size: 300, publishedAt: now.AddDate(0, 0, -3)},
+		{path: "1", size: 100, publishedAt: now.AddDate(0, 0, -1)},
+		{path: "10", size: 1000, publishedAt: now.AddDate(0, 0, -10)},
+		{path: "2", size: 200, publishedAt: now.AddDate(0, 0, -2)},
+		{path: "20", size: 50, publishedAt: now.AddDate(0, 0, -20)},
+	}
+	tests := map[string]struct {
+		retention config.Retention
+		want      []string
+	}{
+		"no limit": {
+			retention: config.Retention{},
+			want:      nil,
+		},
+		"max episodes": {
+			retention: config.Retention{MaxEpisodes: 2},
+			want:      []string{"3", "10", "20"},
+		},
+		"max age": {
+			retention: config.Retention{MaxAgeDays: 5},
+			want:      []string{"10", "20"},
+		},
+		"max total bytes": {
+			// NOTE: 容量を超えたエピソードより古いエピソードは、収まる大きさでも消す
+			retention: config.Retention{MaxTotalBytes: 350},
+			want:      []string{"3", "10", "20"},
+		},
+		"max total bytes after max age": {
+			retention: config.Retention{MaxAgeDays: 5, MaxTotalBytes: 300},
+			want:      []string{"3", "10", "20"},
+		},
+		"combined": {
+			retention: config.Retention{MaxEpisodes: 3, MaxAgeDays: 2, MaxTotalBytes: 1000},
+			want:      []string{"3", "10", "20"},
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for _, ep := range selectExpiredEpisodes(episodes, tt.retention, now) {
+				got = append(got, ep.path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectExpiredEpisodes() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package record
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/xid"
+	"github.com/samber/lo"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/job"
+	"github.com/upamune/radicaster/radikoutil"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+// NOTE: 毎時実行しても取りこぼさないように、前日と当日の番組表を対象にする
+const ruleScanDays = 2
+
+// RecordByRule は番組表からルールにマッチする放送済みの番組を探して録音する。
+func (r *Recorder) RecordByRule(rule config.Rule) (err error) {
+	var (
+		taskStartedTime = time.Now()
+		taskID          = xid.New().String()
+		logger          = r.logger.With().
+				Str("task_id", taskID).
+				Str("rule_title", rule.Title).
+				Logger()
+	)
+	logger.Info().
+		Time("task_started_time", taskStartedTime).
+		Msg("record_by_rule task started")
+	j := r.startJob(job.Job{ID: taskID, Kind: job.KindRecordByRule, Program: rule.Title})
+	defer func() {
+		r.finishJob(j, err)
+		taskFinishedTime := time.Now()
+		logger := logger.With().
+			Time("task_started_time", taskStartedTime).
+			Time("task_finished_time", taskFinishedTime).
+			Dur("task_duration", taskFinishedTime.Sub(taskStartedTime)).Logger()
+
+		if err != nil {
+			logger.Error().Err(err).Msg("record_by_rule task finished with an error")
+			return
+		}
+		logger.Info().Msg("record_by_rule task finished")
+	}()
+
+	ctx := context.Background()
+
+	// NOTE: Radikoのクライアントは毎回初期化しないと、認証エラーになってしまう
+	client, err := r.NewRadikoClient(
+		ctx,
+		radikoutil.WithAreaID(rule.AreaID),
+		radikoutil.WithPremium(r.radikoEmail, r.radikoPassword),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to create radiko client")
+	}
+
+	profile, err := r.Config().Profile(rule.Encoding)
+	if err != nil {
+		return err
+	}
+
+	stationIDMap := lo.Associate(rule.StationIDs, func(stationID string) (string, struct{}) {
+		return strings.ToLower(stationID), struct{}{}
+	})
+
+	now := time.Now().In(timeutil.JST())
+	var errs []error
+	for i := ruleScanDays - 1; i >= 0; i-- {
+		stations, err := client.GetStations(ctx, now.AddDate(0, 0, -i))
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "failed to get stations"))
+			continue
+		}
+		for _, station := range stations {
+			if _, ok := stationIDMap[strings.ToLower(station.ID)]; len(stationIDMap) > 0 && !ok {
+				continue
+			}
+			for _, prog := range station.Progs.Progs {
+				prog := prog
+				from, to, err := ParseProgTime(&prog)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				// NOTE: タイムフリーで聴けるのは放送が終わった番組だけ
+				if to.After(now) || !matchRule(rule, &prog, from) {
+					continue
+				}
+				// NOTE: 番組表を見直すたびに録音済みの番組のジョブを作らないように、先に確かめる
+				output := recordingFileName(prog.Title, from, false, profile.Ext())
+				if recorded, err := r.isRecorded(ctx, output); err != nil {
+					errs = append(errs, err)
+					continue
+				} else if recorded {
+					logger.Debug().Str("output", output).Msg("program matched the rule is already recorded")
+					continue
+				}
+				logger.Info().
+					Str("station_id", station.ID).
+					Str("program_title", prog.Title).
+					Time("from", from).
+					Msg("program matched the rule")
+				subTaskID := xid.New().String()
+				subJob := r.startJob(job.Job{
+					ID:        subTaskID,
+					ParentID:  taskID,
+					Kind:      job.KindRecordByRule,
+					Program:   prog.Title,
+					StationID: station.ID,
+					From:      &from,
+				})
+				err = r.recordByStartTime(
+					ctx,
+					logger.With().Str("sub_task_id", subTaskID).Logger(),
+					client,
+					subJob,
+					false,
+					false,
+					&prog,
+					rule.Title, rule.ImageURL, station.ID, rule.Encoding, rule.Path,
+					rule.PostProcess,
+					from,
+				)
+				r.finishJob(subJob, err)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseProgTime は番組表の番組の開始時刻と終了時刻を返す。
+func ParseProgTime(prog *radiko.Prog) (time.Time, time.Time, error) {
+	from, err := time.ParseInLocation("20060102150405", prog.Ft, timeutil.JST())
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrapf(err, "failed to parse ft: %s", prog.Ft)
+	}
+	to, err := time.ParseInLocation("20060102150405", prog.To, timeutil.JST())
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrapf(err, "failed to parse to: %s", prog.To)
+	}
+	return from, to, nil
+}
+
+func matchRule(rule config.Rule, prog *radiko.Prog, from time.Time) bool {
+	if !rule.Window.Contains(from.Format("1504")) {
+		return false
+	}
+	return containsAny(prog.Title, rule.Keywords.Title) ||
+		containsAny(prog.Pfm, rule.Keywords.Performer) ||
+		containsAny(prog.Desc, rule.Keywords.Description) ||
+		containsAny(prog.Info, rule.Keywords.Description)
+}
+
+func containsAny(s string, keywords []string) bool {
+	s = strings.ToLower(s)
+	for _, k := range keywords {
+		if k != "" && strings.Contains(s, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package record
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/radikoutil/radikotest"
+	"github.com/upamune/radicaster/storage"
+	"github.com/upamune/radicaster/timeutil"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+func TestMatchRule(t *testing.T) {
+	t.Parallel()
+	from := time.Date(2023, time.September, 25, 1, 0, 0, 0, timeutil.JST())
+	prog := &radiko.Prog{
+		Title: "オールナイトニッポン",
+		Pfm:   "星野源",
+		Desc:  "ゲストは未定",
+	}
+	tests := map[string]struct {
+		rule config.Rule
+		want bool
+	}{
+		"title": {
+			rule: config.Rule{Keywords: config.Keywords{Title: []string{"ナイトニッポン"}}},
+			want: true,
+		},
+		"performer": {
+			rule: config.Rule{Keywords: config.Keywords{Performer: []string{"星野"}}},
+			want: true,
+		},
+		"description": {
+			rule: config.Rule{Keywords: config.Keywords{Description: []string{"ゲスト"}}},
+			want: true,
+		},
+		"no keyword matched": {
+			rule: config.Rule{Keywords: config.Keywords{Title: []string{"JUNK"}}},
+			want: false,
+		},
+		"outside of window": {
+			rule: config.Rule{
+				Keywords: config.Keywords{Performer: []string{"星野"}},
+				Window:   config.TimeWindow{From: "2200", To: "0000"},
+			},
+			want: false,
+		},
+		"inside of window": {
+			rule: config.Rule{
+				Keywords: config.Keywords{Performer: []string{"星野"}},
+				Window:   config.TimeWindow{From: "2200", To: "0500"},
+			},
+			want: true,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := matchRule(tt.rule, prog, from); got != tt.want {
+				t.Errorf("matchRule() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecorder_RecordByRule_recorded(t *testing.T) {
+	t.Parallel()
+	from := time.Now().In(timeutil.JST()).Add(-3 * time.Hour).Truncate(time.Hour)
+	srv := radikotest.NewServer(radikotest.Station{
+		ID:    "LFR",
+		Name:  "ニッポン放送",
+		Progs: []radikotest.Prog{radikotest.NewProg("ゲストの番組", from, time.Hour)},
+	})
+	defer srv.Close()
+
+	targetDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(targetDir, recordingFileName("ゲストの番組", from, false, "m4a")), []byte("recorded"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecorder(zerolog.Nop(), storage.NewLocal(targetDir), "", "", config.Config{}, "", WithRadikoEndpoint(srv.URL), WithWorkDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+
+	if err := r.RecordByRule(config.Rule{
+		Title:      "ゲスト",
+		Keywords:   config.Keywords{Title: []string{"ゲスト"}},
+		StationIDs: []string{"LFR"},
+	}); err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+	// NOTE: 録音済みの番組はサブタスクのジョブを作らない
+	if jobs := r.Jobs(); len(jobs) != 1 || jobs[0].ParentID != "" {
+		t.Errorf("Jobs() = %+v, want only the task", jobs)
+	}
+}
@@ -0,0 +1,224 @@
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	workDirManifestFileName = "manifest.txt"
+	workDirChunksDirName    = "chunks"
+	workDirConcatedFileName = "concated.aac"
+
+	workDirCleanupCronExpression = "45 4 * * *"
+)
+
+// WithWorkDir は録音途中のチャンクを置くディレクトリを指定する。
+// 失敗したり中断した録音は次回このディレクトリから再開する。
+func WithWorkDir(dir string) Option {
+	return func(r *Recorder) {
+		r.workDir = dir
+	}
+}
+
+// recordingWorkDir は1つの録音(局と開始時刻)ごとの作業ディレクトリを表す。
+// manifest.txt にはダウンロードが完了したチャンクのURLを1行ずつ追記する。
+type recordingWorkDir struct {
+	dir string
+
+	mu         sync.Mutex
+	manifest   *os.File
+	downloaded map[string]struct{}
+}
+
+func (r *Recorder) workDirPath(stationID string, from time.Time) string {
+	return filepath.Join(
+		r.workDir,
+		fmt.Sprintf("%s_%s", strings.ToLower(stationID), from.Format("20060102150405")),
+	)
+}
+
+// lockWorkDir は同じ局と開始時刻の録音が同時に作業ディレクトリを触らないようにする。
+func (r *Recorder) lockWorkDir(dir string) func() {
+	return r.workDirLocks.lock(dir)
+}
+
+// workDirLocks は作業ディレクトリごとのロックを持つ。
+// NOTE: 録音ごとに作業ディレクトリが変わるので、誰も待っていないロックは消して溜まらないようにする
+type workDirLocks struct {
+	mu    sync.Mutex
+	locks map[string]*workDirLock
+}
+
+type workDirLock struct {
+	sync.Mutex
+	// refs はロックを持っているか待っている数
+	refs int
+}
+
+func (l *workDirLocks) lock(dir string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*workDirLock)
+	}
+	wl, ok := l.locks[dir]
+	if !ok {
+		wl = &workDirLock{}
+		l.locks[dir] = wl
+	}
+	wl.refs++
+	l.mu.Unlock()
+
+	wl.Lock()
+	return func() {
+		wl.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		wl.refs--
+		if wl.refs == 0 {
+			delete(l.locks, dir)
+		}
+	}
+}
+
+func (r *Recorder) openRecordingWorkDir(dir string) (*recordingWorkDir, error) {
+	if err := os.MkdirAll(filepath.Join(dir, workDirChunksDirName), 0777); err != nil {
+		return nil, errors.Wrap(err, "failed to create work dir")
+	}
+
+	w := &recordingWorkDir{
+		dir:        dir,
+		downloaded: make(map[string]struct{}),
+	}
+
+	manifestPath := filepath.Join(dir, workDirManifestFileName)
+	if f, err := os.Open(manifestPath); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				w.downloaded[line] = struct{}{}
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrap(err, "failed to read manifest")
+		}
+	}
+
+	f, err := os.OpenFile(manifestPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open manifest")
+	}
+	w.manifest = f
+	return w, nil
+}
+
+func (w *recordingWorkDir) chunksDir() string {
+	return filepath.Join(w.dir, workDirChunksDirName)
+}
+
+// chunkPath はプレイリストの順番でソートされるように連番を付けたパスを返す。
+func (w *recordingWorkDir) chunkPath(i int, link string) string {
+	return filepath.Join(w.chunksDir(), fmt.Sprintf("%05d_%s", i, chunkFileName(link)))
+}
+
+func (w *recordingWorkDir) concatedFile() string {
+	return filepath.Join(w.dir, workDirConcatedFileName)
+}
+
+func (w *recordingWorkDir) downloadedCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.downloaded)
+}
+
+func (w *recordingWorkDir) isDownloaded(i int, link string) bool {
+	w.mu.Lock()
+	_, ok := w.downloaded[chunkKey(link)]
+	w.mu.Unlock()
+	if !ok {
+		return false
+	}
+	// NOTE: manifestにあってもファイルが消えていたら取り直す
+	_, err := os.Stat(w.chunkPath(i, link))
+	return err == nil
+}
+
+func (w *recordingWorkDir) markDownloaded(link string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := chunkKey(link)
+	if _, err := w.manifest.WriteString(key + "\n"); err != nil {
+		return errors.Wrap(err, "failed to append to manifest")
+	}
+	w.downloaded[key] = struct{}{}
+	return nil
+}
+
+func (w *recordingWorkDir) close() error {
+	return w.manifest.Close()
+}
+
+func (w *recordingWorkDir) remove() error {
+	if err := w.close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(w.dir)
+}
+
+// chunkKey はクエリパラメータを除いたURLを返す。
+func chunkKey(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+func chunkFileName(link string) string {
+	_, fileName := filepath.Split(chunkKey(link))
+	return fileName
+}
+
+// cleanupWorkDirs はタイムフリーの期間を過ぎて再開できなくなった作業ディレクトリを削除する。
+func (r *Recorder) cleanupWorkDirs() error {
+	entries, err := os.ReadDir(r.workDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read work dir")
+	}
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(r.workDir, entry.Name())
+		// NOTE: ディレクトリの更新日時はチャンクを追加しても変わらないのでmanifestで判定する
+		info, err := os.Stat(filepath.Join(dir, workDirManifestFileName))
+		if err != nil {
+			if info, err = entry.Info(); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if time.Since(info.ModTime()) < timeshiftWindow {
+			continue
+		}
+		r.logger.Info().Str("work_dir", dir).Msg("remove stale work dir")
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
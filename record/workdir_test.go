@@ -0,0 +1,39 @@
+package record
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkDirLocks(t *testing.T) {
+	t.Parallel()
+	var l workDirLocks
+
+	unlock := l.lock("lfr_20231003010000")
+	locked := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		unlock := l.lock("lfr_20231003010000")
+		close(locked)
+		unlock()
+	}()
+	// NOTE: 別の作業ディレクトリは待たずにロックできる
+	l.lock("tbs_20231003010000")()
+
+	select {
+	case <-locked:
+		t.Fatal("the same work dir should not be locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.locks) != 0 {
+		t.Errorf("locks = %v, should be removed after unlock", l.locks)
+	}
+}
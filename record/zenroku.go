@@ -0,0 +1,231 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+	"github.com/sourcegraph/conc/pool"
+	"github.com/upamune/radicaster/config"
+	"github.com/upamune/radicaster/ffmpeg"
+	"github.com/upamune/radicaster/job"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/radikoutil"
+	"github.com/upamune/radicaster/storage"
+	"github.com/yyoshiki41/go-radiko"
+)
+
+// zenrokuDay は局の放送日を1つのファイルにするために集めたチャンクと番組のチャプター。
+type zenrokuDay struct {
+	chunkURLs []string
+	chapters  []metadata.Chapter
+	// programs は説明に載せる番組の一覧。
+	programs []string
+	duration time.Duration
+}
+
+// zenrokuDayRetryDays は局ごとに放送日を1つのファイルにする場合に、前日から遡って録音する日数。
+// NOTE: 一時的なエラーで一部の番組を取得できなかった日はファイルを保存しないので、次の全録でやり直す。
+// タイムフリーは1週間で聴けなくなるので、それより短くする
+const zenrokuDayRetryDays = 3
+
+// recordAllDays は有効な局ごとに、まだ保存していない放送日を古い順に1つのファイルに録音する。
+func (r *Recorder) recordAllDays(
+	ctx context.Context,
+	logger zerolog.Logger,
+	client *radiko.Client,
+	taskID string,
+	targetDate time.Time,
+	zenrokuConfig config.Zenroku,
+	enabledStationIDMap map[string]struct{},
+) error {
+	var (
+		stationIDs  []string
+		stationDays = make(map[string][]radiko.Station)
+	)
+	for i := zenrokuDayRetryDays - 1; i >= 0; i-- {
+		stations, err := client.GetStations(ctx, targetDate.AddDate(0, 0, -i))
+		if err != nil {
+			return errors.Wrap(err, "failed to get stations")
+		}
+		for _, station := range stations {
+			stationID := strings.ToLower(station.ID)
+			if _, ok := enabledStationIDMap[stationID]; !ok {
+				continue
+			}
+			if _, ok := stationDays[stationID]; !ok {
+				stationIDs = append(stationIDs, stationID)
+			}
+			stationDays[stationID] = append(stationDays[stationID], station)
+		}
+	}
+
+	// NOTE: 同じ局の放送日は順に録音して、局ごとに並列化する
+	pool := pool.New().WithErrors()
+	for _, stationID := range stationIDs {
+		days := stationDays[stationID]
+		pool.Go(func() error {
+			var errs []error
+			for _, station := range days {
+				if err := r.recordStationDay(ctx, logger, client, taskID, station, zenrokuConfig); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		})
+	}
+	if err := pool.Wait(); err != nil {
+		return errors.Wrap(err, "failed to record all stations")
+	}
+	return nil
+}
+
+// recordStationDay は局の放送日の全ての番組を1つのファイルに録音して、番組ごとにチャプターを付ける。
+func (r *Recorder) recordStationDay(
+	ctx context.Context,
+	logger zerolog.Logger,
+	client *radiko.Client,
+	taskID string,
+	station radiko.Station,
+	zenrokuConfig config.Zenroku,
+) (err error) {
+	progs := station.Progs.Progs
+	if len(progs) == 0 {
+		logger.Info().Str("station_id", station.ID).Msg("skip station because it has no programs")
+		return nil
+	}
+	from, _, err := ParseProgTime(&progs[0])
+	if err != nil {
+		return err
+	}
+	_, to, err := ParseProgTime(&progs[len(progs)-1])
+	if err != nil {
+		return err
+	}
+
+	profile, err := r.Config().Profile(zenrokuConfig.Encoding)
+	if err != nil {
+		return err
+	}
+	output := recordingFileName(station.Name, from, true, profile.Ext())
+	// NOTE: 遡って録音するので、保存済みの日はジョブを作らずに飛ばす
+	if _, err := r.storage.Stat(ctx, output); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrap(err, "failed to stat output")
+	}
+
+	subTaskID := xid.New().String()
+	j := r.startJob(job.Job{
+		ID:        subTaskID,
+		ParentID:  taskID,
+		Kind:      job.KindRecordAll,
+		Program:   station.Name,
+		StationID: station.ID,
+		From:      &from,
+	})
+	defer func() {
+		r.finishJob(j, err)
+	}()
+	logger = logger.With().
+		Str("sub_task_id", subTaskID).
+		Str("station_id", station.ID).
+		Time("from", from).
+		Time("to", to).
+		Logger()
+
+	day, err := collectZenrokuDay(ctx, client, station.ID, progs)
+	if err != nil {
+		return err
+	}
+
+	workDirPath := r.workDirPath(station.ID, from) + "_day"
+	unlock := r.lockWorkDir(workDirPath)
+	defer unlock()
+	w, err := r.openRecordingWorkDir(workDirPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open work dir")
+	}
+	defer w.close()
+	logger.Debug().
+		Str("work_dir", w.dir).
+		Int("downloaded_chunks", w.downloadedCount()).
+		Int("total_chunks", len(day.chunkURLs)).
+		Msg("opened work dir")
+
+	concatedFile, err := r.concatChunks(ctx, logger, w, day.chunkURLs)
+	if err != nil {
+		return err
+	}
+
+	stationID := strings.ToLower(station.ID)
+	boundary := ffmpeg.Boundary{Duration: day.duration}
+	return r.saveRecording(ctx, logger, j, w, concatedFile, output, profile, zenrokuConfig.PostProcess, boundary, metadata.EpisodeMetadata{
+		Title:        fmt.Sprintf("%s %s", station.Name, from.Format("2006年01月02日")),
+		Description:  strings.Join(day.programs, "\n"),
+		PublishedAt:  from,
+		EndedAt:      &to,
+		StationID:    station.ID,
+		ImageURL:     zenrokuConfig.Stations[stationID].ImageURL,
+		Path:         stationID,
+		PodcastTitle: station.Name,
+		ZenrokuMode:  true,
+		Chapters:     day.chapters,
+	})
+}
+
+// collectZenrokuDay は番組ごとにタイムフリーのチャンクを集めて、ファイルの中の番組と番組表のコーナーの位置をチャプターにする。
+// タイムフリーで配信されていない番組は飛ばして、その位置に録音していないことを示すチャプターを付ける。
+// NOTE: 一時的なエラーで取得できない番組があれば、欠けたファイルを保存しないようにエラーにする
+func collectZenrokuDay(ctx context.Context, client *radiko.Client, stationID string, progs []radiko.Prog) (zenrokuDay, error) {
+	var day zenrokuDay
+	for _, prog := range progs {
+		prog := prog
+		ft, to, err := ParseProgTime(&prog)
+		if err != nil {
+			return zenrokuDay{}, err
+		}
+		// NOTE: 番組をまたぐ長いプレイリストは取得できないことがあるので、番組ごとに取得する
+		uri, err := radikoutil.TimeshiftPlaylistM3U8(ctx, client, stationID, ft, to)
+		if errors.Is(err, radikoutil.ErrTimeshiftUnavailable) {
+			day.chapters = append(day.chapters, metadata.Chapter{
+				Title:        fmt.Sprintf("%s (タイムフリー対象外のため録音なし)", prog.Title),
+				StartSeconds: day.duration.Seconds(),
+			})
+			day.programs = append(day.programs, fmt.Sprintf("%s %s (録音なし)", ft.Format("15:04"), prog.Title))
+			continue
+		}
+		if err != nil {
+			return zenrokuDay{}, errors.Wrapf(err, "failed to get m3u8: %s %s", stationID, prog.Title)
+		}
+		chunkURLs, err := radiko.GetChunklistFromM3U8(uri)
+		if err != nil {
+			return zenrokuDay{}, errors.Wrapf(err, "failed to get chunklist: %s %s", stationID, prog.Title)
+		}
+		day.chapters = append(day.chapters, metadata.Chapter{
+			Title:        prog.Title,
+			StartSeconds: day.duration.Seconds(),
+		})
+		for _, c := range segmentChapters(&prog, ft, to) {
+			// NOTE: 番組の開始と同じ位置のコーナーは、番組名のチャプターを残すために捨てる
+			if c.StartSeconds == 0 {
+				continue
+			}
+			day.chapters = append(day.chapters, metadata.Chapter{
+				Title:        c.Title,
+				StartSeconds: day.duration.Seconds() + c.StartSeconds,
+			})
+		}
+		day.programs = append(day.programs, fmt.Sprintf("%s %s", ft.Format("15:04"), prog.Title))
+		day.chunkURLs = append(day.chunkURLs, chunkURLs...)
+		day.duration += to.Sub(ft)
+	}
+	if len(day.chunkURLs) == 0 {
+		return zenrokuDay{}, errors.New("no chunks to record")
+	}
+	return day, nil
+}
@@ -0,0 +1,72 @@
+package record
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/upamune/radicaster/metadata"
+	"github.com/upamune/radicaster/radikoutil"
+	"github.com/upamune/radicaster/radikoutil/radikotest"
+	"github.com/upamune/radicaster/timeutil"
+)
+
+func TestCollectZenrokuDay(t *testing.T) {
+	t.Parallel()
+
+	targetDate := time.Now().In(timeutil.JST()).AddDate(0, 0, -1).Truncate(24 * time.Hour)
+	progs := []radikotest.Prog{
+		radikotest.NewProg("朝の番組", targetDate, 30*time.Second),
+		radikotest.NewProg("中継", targetDate.Add(30*time.Second), 30*time.Second),
+		radikotest.NewProg("昼の番組", targetDate.Add(time.Minute), 30*time.Second),
+	}
+	tests := map[string]struct {
+		status       int
+		wantErr      bool
+		wantChapters []metadata.Chapter
+	}{
+		"not available for timeshift": {
+			status: http.StatusNotFound,
+			wantChapters: []metadata.Chapter{
+				{Title: "朝の番組", StartSeconds: 0},
+				{Title: "中継 (タイムフリー対象外のため録音なし)", StartSeconds: 30},
+				{Title: "昼の番組", StartSeconds: 30},
+			},
+		},
+		"transient error": {
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			srv := radikotest.NewServer(radikotest.Station{ID: "LFR", Name: "ニッポン放送", Progs: progs})
+			defer srv.Close()
+			srv.SetTimeshiftStatus("LFR", targetDate.Add(30*time.Second), tt.status)
+
+			ctx := context.Background()
+			client, err := radikoutil.NewClient(ctx, radikoutil.WithEndpoint(srv.URL))
+			if err != nil {
+				t.Fatalf("%+v\n", errors.WithStack(err))
+			}
+			day, err := collectZenrokuDay(ctx, client, "LFR", progs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("collectZenrokuDay() error = %+v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(day.chapters, tt.wantChapters) {
+				t.Errorf("chapters = %+v, want %+v", day.chapters, tt.wantChapters)
+			}
+			if want := time.Minute; day.duration != want {
+				t.Errorf("duration = %v, want %v", day.duration, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Package server はHTTPサーバーを TCP(TLS) と unix ソケットで待ち受ける。
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+	"github.com/sourcegraph/conc/pool"
+)
+
+const unixSocketPerm = 0660
+
+// Config は待ち受けの設定を表す。
+// ListenAddr と UnixSocket の少なくとも一方は指定する必要がある。
+type Config struct {
+	ListenAddr  string
+	TLSCertFile string
+	TLSKeyFile  string
+	UnixSocket  string
+}
+
+func (c Config) validate() error {
+	if c.ListenAddr == "" && c.UnixSocket == "" {
+		return errors.New("listen address or unix socket is required")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("both tls cert and key are required")
+	}
+	if c.TLSCertFile != "" && c.ListenAddr == "" {
+		return errors.New("tls requires listen address")
+	}
+	return nil
+}
+
+type Server struct {
+	logger    zerolog.Logger
+	server    *http.Server
+	listeners []net.Listener
+}
+
+// New は設定に従ってリスナーを開く。Serve を呼ぶまでリクエストは処理しない。
+func New(logger zerolog.Logger, handler http.Handler, c Config) (*Server, error) {
+	if err := c.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	s := &Server{
+		logger: logger,
+		server: &http.Server{Handler: handler},
+	}
+
+	if c.ListenAddr != "" {
+		ln, err := net.Listen("tcp", c.ListenAddr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to listen: %s", c.ListenAddr)
+		}
+		if c.TLSCertFile != "" {
+			reloader, err := newCertReloader(logger, c.TLSCertFile, c.TLSKeyFile)
+			if err != nil {
+				ln.Close()
+				return nil, errors.Wrap(err, "failed to load tls certificate")
+			}
+			ln = tls.NewListener(ln, &tls.Config{
+				GetCertificate: reloader.GetCertificate,
+				NextProtos:     []string{"h2", "http/1.1"},
+				MinVersion:     tls.VersionTLS12,
+			})
+		}
+		s.listeners = append(s.listeners, ln)
+	}
+
+	if c.UnixSocket != "" {
+		ln, err := listenUnix(c.UnixSocket)
+		if err != nil {
+			s.closeListeners()
+			return nil, errors.Wrapf(err, "failed to listen: %s", c.UnixSocket)
+		}
+		s.listeners = append(s.listeners, ln)
+	}
+
+	return s, nil
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	// NOTE: 前回異常終了した時のソケットファイルが残っているとlistenできない
+	// 間違えて指定した普通のファイルを消さないように、ソケットの場合だけ消す
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Newf("not a socket: %s", path)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to remove stale socket")
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to stat socket")
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: 同じグループのリバースプロキシ(nginxなど)から繋げるようにする
+	if err := os.Chmod(path, unixSocketPerm); err != nil {
+		ln.Close()
+		return nil, errors.Wrap(err, "failed to chmod socket")
+	}
+	return ln, nil
+}
+
+// Addrs は待ち受けているアドレスを返す。
+func (s *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// Serve は全てのリスナーでリクエストを処理する。Shutdown されると nil を返す。
+func (s *Server) Serve() error {
+	p := pool.New().WithErrors()
+	for _, ln := range s.listeners {
+		ln := ln
+		s.logger.Info().
+			Str("network", ln.Addr().Network()).
+			Str("addr", ln.Addr().String()).
+			Msg("http server is listening")
+		p.Go(func() error {
+			err := s.server.Serve(ln)
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			// NOTE: 1つでも待ち受けられなくなったら、他のリスナーも止めて終了する
+			s.server.Close()
+			return errors.Wrapf(err, "failed to serve on %s", ln.Addr())
+		})
+	}
+	return p.Wait()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) closeListeners() {
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestServer_Serve(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, "first")
+	socket := filepath.Join(dir, "radicaster.sock")
+
+	srv, err := New(zerolog.Nop(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), Config{
+		ListenAddr:  "127.0.0.1:0",
+		TLSCertFile: certFile,
+		TLSKeyFile:  keyFile,
+		UnixSocket:  socket,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve() }()
+
+	addrs := srv.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("Addrs() = %v, want 2 addrs", addrs)
+	}
+
+	tlsClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	assertGet(t, tlsClient, "https://"+addrs[0].String()+"/")
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	assertGet(t, unixClient, "http://unix/")
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Serve() error = %v, want nil", err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		// prepare は path に前回のファイルを置く。
+		prepare func(t *testing.T, path string)
+		wantErr bool
+	}{
+		"no file": {
+			prepare: func(t *testing.T, path string) {},
+		},
+		"stale socket": {
+			prepare: func(t *testing.T, path string) {
+				ln, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ln.(*net.UnixListener).SetUnlinkOnClose(false)
+				ln.Close()
+			},
+		},
+		"regular file": {
+			prepare: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "radicaster.sock")
+			tt.prepare(t, path)
+			ln, err := listenUnix(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenUnix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				// NOTE: ソケットではないファイルは消さない
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("the regular file should be kept: %v", err)
+				}
+				return
+			}
+			ln.Close()
+		})
+	}
+}
+
+func TestConfig_validate(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"tcp":              {config: Config{ListenAddr: ":3333"}},
+		"unix socket only": {config: Config{UnixSocket: "/tmp/radicaster.sock"}},
+		"tls":              {config: Config{ListenAddr: ":443", TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}},
+		"nothing":          {config: Config{}, wantErr: true},
+		"cert without key": {config: Config{ListenAddr: ":443", TLSCertFile: "cert.pem"}, wantErr: true},
+		"tls without tcp":  {config: Config{UnixSocket: "/tmp/radicaster.sock", TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertReloader_GetCertificate(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, "first")
+
+	r, err := newCertReloader(zerolog.Nop(), certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	assertCommonName(t, r, "first")
+
+	writeSelfSignedCert(t, certFile, keyFile, "second")
+	future := now.Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// NOTE: 確認する間隔が空くまでは前の証明書を返す
+	assertCommonName(t, r, "first")
+	now = now.Add(certCheckInterval)
+	assertCommonName(t, r, "second")
+
+	// NOTE: 壊れたファイルに更新されても前の証明書を使い続ける
+	if err := os.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(certCheckInterval)
+	assertCommonName(t, r, "second")
+}
+
+func assertGet(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", url, err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != "ok" {
+		t.Errorf("GET %s body = %q, want %q", url, b, "ok")
+	}
+}
+
+func assertCommonName(t *testing.T, r *certReloader, want string) {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := leaf.Subject.CommonName; got != want {
+		t.Errorf("CommonName = %s, want %s", got, want)
+	}
+}
+
+func writeSelfSignedCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
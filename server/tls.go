@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/zerolog"
+)
+
+// NOTE: ハンドシェイクのたびにファイルを調べないように間隔を空ける
+const certCheckInterval = 10 * time.Second
+
+// certReloader は証明書と秘密鍵のファイルが更新されたら読み込み直す。
+// Let's Encryptなどで証明書を更新しても再起動する必要がない。
+type certReloader struct {
+	logger            zerolog.Logger
+	certFile, keyFile string
+	now               func() time.Time
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	checkedAt   time.Time
+}
+
+func newCertReloader(logger zerolog.Logger, certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+		now:      time.Now,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	certStat, err := os.Stat(r.certFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat cert file")
+	}
+	keyStat, err := os.Stat(r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat key file")
+	}
+	if r.cert != nil && certStat.ModTime().Equal(r.certModTime) && keyStat.ModTime().Equal(r.keyModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load key pair")
+	}
+	r.cert = &cert
+	r.certModTime = certStat.ModTime()
+	r.keyModTime = keyStat.ModTime()
+	r.logger.Info().
+		Str("cert_file", r.certFile).
+		Time("cert_mod_time", r.certModTime).
+		Msg("loaded tls certificate")
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := r.now(); now.Sub(r.checkedAt) >= certCheckInterval {
+		r.checkedAt = now
+		// NOTE: 証明書と秘密鍵の片方だけが更新された途中などで読めない場合は、前の証明書を使い続ける
+		if err := r.reload(); err != nil {
+			r.logger.Warn().Err(err).Msg("failed to reload tls certificate, keep using the previous one")
+		}
+	}
+	return r.cert, nil
+}
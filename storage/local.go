@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Local はローカルのディレクトリに保存する Storage。
+type Local struct {
+	dir string
+}
+
+var _ Storage = (*Local)(nil)
+
+// NewLocal は dir に保存する Local を返す。
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Dir は保存先のディレクトリを返す。
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+func (l *Local) path(key string) (string, error) {
+	cleaned, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(cleaned)), nil
+}
+
+// Put は同じディレクトリの一時ファイルに書き込んでから rename する。
+// NOTE: 一時ファイルは "." から始まる名前にして List に出てこないようにする
+func (l *Local) Put(_ context.Context, key string, r io.Reader, _ int64) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return errors.Wrap(err, "failed to create directory")
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+	// NOTE: CreateTemp は 0600 で作るので、これまで通り他のユーザーからも読めるようにする
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return errors.Wrap(err, "failed to change file mode")
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return errors.Wrap(err, "failed to rename file")
+	}
+	return nil
+}
+
+// List はディレクトリ以下の全てのファイルを返す。"." から始まるファイルとディレクトリは含めない。
+func (l *Local) List(_ context.Context) ([]Object, error) {
+	var objects []Object
+	if err := filepath.WalkDir(l.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != l.dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{
+			Key:     filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to walk directory")
+	}
+	return objects, nil
+}
+
+func (l *Local) Stat(_ context.Context, key string) (Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Object{}, ErrNotExist
+		}
+		return Object{}, errors.Wrap(err, "failed to stat file")
+	}
+	if info.IsDir() {
+		return Object{}, ErrNotExist
+	}
+	cleaned, _ := CleanKey(key)
+	return Object{Key: cleaned, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Open(_ context.Context, key string) (io.ReadSeekCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotExist
+		}
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+	return f, nil
+}
+
+func (l *Local) Delete(_ context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrap(err, "failed to remove file")
+	}
+	return nil
+}
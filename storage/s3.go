@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// NOTE: 署名付きURLはポッドキャストのアプリが再生を始めるまで使えれば良い
+const defaultPresignExpiry = time.Hour
+
+type S3Option func(s *S3)
+
+// WithS3Prefix はバケットの中の保存先のプレフィックスを指定する。
+func WithS3Prefix(prefix string) S3Option {
+	return func(s *S3) {
+		s.prefix = strings.Trim(prefix, "/")
+	}
+}
+
+// WithS3Region はバケットのリージョンを指定する。
+func WithS3Region(region string) S3Option {
+	return func(s *S3) {
+		s.region = region
+	}
+}
+
+// WithS3Insecure はエンドポイントに https ではなく http で接続する。MinIOをローカルで動かす場合などに使う。
+func WithS3Insecure() S3Option {
+	return func(s *S3) {
+		s.insecure = true
+	}
+}
+
+// WithPresignExpiry は署名付きURLの有効期限を指定する。
+func WithPresignExpiry(d time.Duration) S3Option {
+	return func(s *S3) {
+		s.presignExpiry = d
+	}
+}
+
+// S3 はS3互換のオブジェクトストレージに保存する Storage。
+type S3 struct {
+	client *minio.Client
+	bucket string
+
+	prefix        string
+	region        string
+	insecure      bool
+	presignExpiry time.Duration
+}
+
+var (
+	_ Storage   = (*S3)(nil)
+	_ Presigner = (*S3)(nil)
+)
+
+// NewS3 は endpoint (例: s3.amazonaws.com, localhost:9000) の bucket に保存する S3 を返す。
+func NewS3(endpoint, bucket, accessKey, secretKey string, opts ...S3Option) (*S3, error) {
+	s := &S3{
+		bucket:        bucket,
+		presignExpiry: defaultPresignExpiry,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: !s.insecure,
+		Region: s.region,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create s3 client")
+	}
+	s.client = client
+	return s, nil
+}
+
+func (s *S3) objectName(key string) (string, error) {
+	cleaned, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if s.prefix == "" {
+		return cleaned, nil
+	}
+	return path.Join(s.prefix, cleaned), nil
+}
+
+func (s *S3) key(objectName string) string {
+	if s.prefix == "" {
+		return objectName
+	}
+	return strings.TrimPrefix(objectName, s.prefix+"/")
+}
+
+func isNotFound(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey"
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if _, err := s.client.PutObject(ctx, s.bucket, name, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	}); err != nil {
+		return errors.Wrapf(err, "failed to put object: %s", name)
+	}
+	return nil
+}
+
+func (s *S3) List(ctx context.Context) ([]Object, error) {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+	var objects []Object
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, errors.Wrap(info.Err, "failed to list objects")
+		}
+		objects = append(objects, Object{
+			Key:     s.key(info.Key),
+			Size:    info.Size,
+			ModTime: info.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return Object{}, ErrNotExist
+		}
+		return Object{}, errors.Wrapf(err, "failed to stat object: %s", name)
+	}
+	return Object{Key: s.key(info.Key), Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get object: %s", name)
+	}
+	// NOTE: GetObject は読み込むまでリクエストしないので、ここで存在を確かめる
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if isNotFound(err) {
+			return nil, ErrNotExist
+		}
+		return nil, errors.Wrapf(err, "failed to get object: %s", name)
+	}
+	return obj, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+		return errors.Wrapf(err, "failed to remove object: %s", name)
+	}
+	return nil
+}
+
+// PresignedURL は key のファイルを取得できる署名付きURLを返す。
+func (s *S3) PresignedURL(ctx context.Context, key string) (string, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return "", err
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, name, s.presignExpiry, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to presign object: %s", name)
+	}
+	return u.String(), nil
+}
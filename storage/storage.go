@@ -0,0 +1,53 @@
+// Package storage は録音したファイルとメタデータの保存先を表す。
+// キーは "/" 区切りの相対パスで、ローカルのディレクトリとS3互換のオブジェクトストレージに保存できる。
+package storage
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrNotExist はキーのファイルが無いことを表す。
+var ErrNotExist = errors.New("storage: object does not exist")
+
+// Object は保存されたファイルの情報を表す。
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage は録音したファイルの保存先。
+type Storage interface {
+	// Put は r の size バイトを key に保存する。途中で失敗しても中途半端なファイルは残さない。
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// List は保存されている全てのファイルを返す。
+	List(ctx context.Context) ([]Object, error)
+	// Stat は key のファイルの情報を返す。無ければ ErrNotExist を返す。
+	Stat(ctx context.Context, key string) (Object, error)
+	// Open は key のファイルを開く。無ければ ErrNotExist を返す。
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete は key のファイルを削除する。無い場合は何もしない。
+	Delete(ctx context.Context, key string) error
+}
+
+// Presigner は署名付きのURLでファイルを直接配信できる Storage が実装する。
+type Presigner interface {
+	PresignedURL(ctx context.Context, key string) (string, error)
+}
+
+// CleanKey は key を "/" 区切りの正規化された相対パスにする。
+// 保存先の外を指すキーはエラーにする。
+func CleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if cleaned == "" || cleaned == "." {
+		return "", errors.Newf("storage: invalid key: %q", key)
+	}
+	return cleaned, nil
+}
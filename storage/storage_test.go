@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/xid"
+)
+
+func TestCleanKey(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		"file":             {key: "ann.aac", want: "ann.aac"},
+		"subdirectory":     {key: "zenroku/lfr/ann.aac", want: "zenroku/lfr/ann.aac"},
+		"leading slash":    {key: "/ann.aac", want: "ann.aac"},
+		"parent directory": {key: "../../etc/passwd", want: "etc/passwd"},
+		"backslash":        {key: `zenroku\ann.aac`, want: "zenroku/ann.aac"},
+		"empty":            {key: "", wantErr: true},
+		"root":             {key: "/", wantErr: true},
+	}
+	for name, tt := range tests {
+		name, tt := name, tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := CleanKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CleanKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CleanKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocal(t *testing.T) {
+	t.Parallel()
+	testStorage(t, NewLocal(t.TempDir()))
+}
+
+// TestS3 はS3互換のストレージで動かす。MinIOなどを起動して環境変数を指定した場合だけ実行する。
+//
+//	docker run -p 9000:9000 -e MINIO_ROOT_USER=minioadmin -e MINIO_ROOT_PASSWORD=minioadmin minio/minio server /data
+//	RADICASTER_TEST_S3_ENDPOINT=localhost:9000 RADICASTER_TEST_S3_BUCKET=radicaster \
+//	RADICASTER_TEST_S3_ACCESS_KEY=minioadmin RADICASTER_TEST_S3_SECRET_KEY=minioadmin go test ./storage
+func TestS3(t *testing.T) {
+	t.Parallel()
+	endpoint := os.Getenv("RADICASTER_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("RADICASTER_TEST_S3_ENDPOINT is not set")
+	}
+	s, err := NewS3(
+		endpoint,
+		os.Getenv("RADICASTER_TEST_S3_BUCKET"),
+		os.Getenv("RADICASTER_TEST_S3_ACCESS_KEY"),
+		os.Getenv("RADICASTER_TEST_S3_SECRET_KEY"),
+		// NOTE: 前回のテストのファイルが残っていても影響しないように、毎回違うプレフィックスにする
+		WithS3Prefix("radicaster-test-"+xid.New().String()),
+		WithS3Insecure(),
+	)
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+	ctx := context.Background()
+	if exists, err := s.client.BucketExists(ctx, s.bucket); err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	} else if !exists {
+		t.Fatalf("bucket %s does not exist", s.bucket)
+	}
+	testStorage(t, s)
+
+	u, err := s.PresignedURL(ctx, "ann.aac")
+	if err != nil {
+		t.Fatalf("%+v\n", errors.WithStack(err))
+	}
+	if !strings.Contains(u, "X-Amz-Signature=") {
+		t.Errorf("PresignedURL() = %s, want a signed url", u)
+	}
+}
+
+func testStorage(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	for key, body := range map[string]string{
+		"ann.aac":             "ann",
+		"ann.aac.json":        `{"title":"ann"}`,
+		"zenroku/lfr/ann.aac": "zenroku",
+	} {
+		if err := s.Put(ctx, key, strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("Put(%s) error = %+v", key, err)
+		}
+	}
+
+	objects, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %+v", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	if got, want := strings.Join(keys, ","), "ann.aac,ann.aac.json,zenroku/lfr/ann.aac"; got != want {
+		t.Errorf("List() = %s, want %s", got, want)
+	}
+
+	obj, err := s.Stat(ctx, "zenroku/lfr/ann.aac")
+	if err != nil {
+		t.Fatalf("Stat() error = %+v", err)
+	}
+	if obj.Key != "zenroku/lfr/ann.aac" || obj.Size != int64(len("zenroku")) {
+		t.Errorf("Stat() = %+v", obj)
+	}
+
+	f, err := s.Open(ctx, "ann.aac")
+	if err != nil {
+		t.Fatalf("Open() error = %+v", err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "ann"; got != want {
+		t.Errorf("Open() = %s, want %s", got, want)
+	}
+
+	if err := s.Delete(ctx, "ann.aac"); err != nil {
+		t.Fatalf("Delete() error = %+v", err)
+	}
+	if err := s.Delete(ctx, "ann.aac"); err != nil {
+		t.Errorf("Delete() for a deleted key error = %+v", err)
+	}
+	if _, err := s.Stat(ctx, "ann.aac"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat() for a deleted key error = %v, want ErrNotExist", err)
+	}
+	if _, err := s.Open(ctx, "ann.aac"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open() for a deleted key error = %v, want ErrNotExist", err)
+	}
+}
@@ -91,3 +91,41 @@ func LastSpecifiedWeekday(weekday Weekday, now time.Time) (time.Time, error) {
 	}
 	return time.Time{}, fmt.Errorf("failed to find last specified weekday: %s", time.Weekday(weekday))
 }
+
+// BroadcastDayStartHour はラジオの番組表で1日が始まる時刻。
+// 5時より前の番組は前の日の24時以降の番組として扱われる。
+const BroadcastDayStartHour = 5
+
+// BroadcastClock は番組表での曜日と "HH:MM" 形式の時刻を返す。
+// 例えば水曜1時は火曜25:00になる。
+func BroadcastClock(t time.Time) (time.Weekday, string) {
+	t = t.In(jst)
+	weekday, hour := t.Weekday(), t.Hour()
+	if hour < BroadcastDayStartHour {
+		weekday = (weekday + 6) % 7
+		hour += 24
+	}
+	return weekday, fmt.Sprintf("%02d:%02d", hour, t.Minute())
+}
+
+// NormalizeBroadcastTime は番組表での曜日と開始時刻を、実際の曜日と "HHMM" 形式の時刻に変換する。
+// 時刻は "HHMM" か "HH:MM" 形式で、24:00 から 28:59 までは翌日の0時から4時59分として扱う。
+// 例えば火曜 "2500" は水曜 "0100" になる。
+func NormalizeBroadcastTime(weekday Weekday, start string) (Weekday, string, error) {
+	hhmm := strings.Replace(start, ":", "", 1)
+	if len(hhmm) != 4 {
+		return 0, "", errors.Newf("start must be HHMM or HH:MM: %q", start)
+	}
+	var hour, minute int
+	if _, err := fmt.Sscanf(hhmm, "%02d%02d", &hour, &minute); err != nil {
+		return 0, "", errors.Newf("start must be HHMM or HH:MM: %q", start)
+	}
+	if hour < 0 || hour >= 24+BroadcastDayStartHour || minute < 0 || minute >= 60 {
+		return 0, "", errors.Newf("start must be between 00:00 and 28:59: %q", start)
+	}
+	if hour >= 24 {
+		weekday = (weekday + 1) % 7
+		hour -= 24
+	}
+	return weekday, fmt.Sprintf("%02d%02d", hour, minute), nil
+}
@@ -58,3 +58,66 @@ func TestLastSpecifiedWeekday(t *testing.T) {
 		})
 	}
 }
+
+func TestBroadcastClock(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		t           time.Time
+		wantWeekday time.Weekday
+		wantClock   string
+	}{
+		{t: time.Date(2023, time.September, 26, 22, 0, 0, 0, JST()), wantWeekday: time.Tuesday, wantClock: "22:00"},
+		{t: time.Date(2023, time.September, 27, 1, 0, 0, 0, JST()), wantWeekday: time.Tuesday, wantClock: "25:00"},
+		{t: time.Date(2023, time.September, 27, 4, 59, 0, 0, JST()), wantWeekday: time.Tuesday, wantClock: "28:59"},
+		{t: time.Date(2023, time.September, 27, 5, 0, 0, 0, JST()), wantWeekday: time.Wednesday, wantClock: "05:00"},
+		{t: time.Date(2023, time.September, 24, 3, 0, 0, 0, JST()), wantWeekday: time.Saturday, wantClock: "27:00"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.t.Format(time.DateTime), func(t *testing.T) {
+			t.Parallel()
+			weekday, clock := BroadcastClock(tt.t)
+			if weekday != tt.wantWeekday || clock != tt.wantClock {
+				t.Errorf("BroadcastClock() got = %s %s, want %s %s", weekday, clock, tt.wantWeekday, tt.wantClock)
+			}
+		})
+	}
+}
+
+func TestNormalizeBroadcastTime(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		weekday     time.Weekday
+		start       string
+		wantWeekday time.Weekday
+		wantStart   string
+		wantErr     bool
+	}{
+		{weekday: time.Tuesday, start: "0100", wantWeekday: time.Tuesday, wantStart: "0100"},
+		{weekday: time.Tuesday, start: "2200", wantWeekday: time.Tuesday, wantStart: "2200"},
+		{weekday: time.Tuesday, start: "2500", wantWeekday: time.Wednesday, wantStart: "0100"},
+		{weekday: time.Tuesday, start: "25:30", wantWeekday: time.Wednesday, wantStart: "0130"},
+		{weekday: time.Saturday, start: "2400", wantWeekday: time.Sunday, wantStart: "0000"},
+		{weekday: time.Sunday, start: "2859", wantWeekday: time.Monday, wantStart: "0459"},
+		{weekday: time.Sunday, start: "2900", wantErr: true},
+		{weekday: time.Sunday, start: "0160", wantErr: true},
+		{weekday: time.Sunday, start: "100", wantErr: true},
+		{weekday: time.Sunday, start: "ab:cd", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("%s %s", tt.weekday, tt.start), func(t *testing.T) {
+			t.Parallel()
+			weekday, start, err := NormalizeBroadcastTime(Weekday(tt.weekday), tt.start)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeBroadcastTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if time.Weekday(weekday) != tt.wantWeekday || start != tt.wantStart {
+				t.Errorf("NormalizeBroadcastTime() got = %s %s, want %s %s", time.Weekday(weekday), start, tt.wantWeekday, tt.wantStart)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+// Package user は radicaster を使う人のアカウントと権限を管理する。
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role はユーザーの権限を表す。
+type Role string
+
+const (
+	// RoleAdmin は設定を変更でき、全てのフィードを聴ける。
+	RoleAdmin Role = "admin"
+	// RoleListener は許可されたフィードだけを聴ける。
+	RoleListener Role = "listener"
+)
+
+func (r Role) validate() error {
+	switch r {
+	case RoleAdmin, RoleListener:
+		return nil
+	}
+	return errors.Newf("unknown role: %s", r)
+}
+
+// AllFeeds は全てのフィードを聴けることを表すフィードのパス。
+const AllFeeds = "all"
+
+const tokenBytes = 24
+
+var (
+	ErrNotFound      = errors.New("user not found")
+	ErrLastAdmin     = errors.New("cannot remove the last admin")
+	ErrEmptyPassword = errors.New("password is required for a new user")
+)
+
+type User struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password_hash"`
+	Role         Role     `json:"role"`
+	Feeds        []string `json:"feeds,omitempty"`
+	// Token は個人用フィードのURLに付けるトークン。
+	Token string `json:"token"`
+}
+
+// IsAdmin はユーザーが管理者かどうかを返す。
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// CanAccessFeed はユーザーがフィードを聴けるかどうかを返す。
+func (u User) CanAccessFeed(feedPath string) bool {
+	if u.IsAdmin() {
+		return true
+	}
+	feedPath = normalizeFeedPath(feedPath)
+	for _, f := range u.Feeds {
+		if f == AllFeeds || f == feedPath {
+			return true
+		}
+	}
+	return false
+}
+
+// FeedPaths は個人用フィードに載せるフィードのパスを返す。
+func (u User) FeedPaths() []string {
+	if u.IsAdmin() {
+		return []string{AllFeeds}
+	}
+	return u.Feeds
+}
+
+// Params はユーザーを作成・更新するときの値。
+// Password が空の場合は今のパスワードのままにする。
+type Params struct {
+	Password string   `json:"password" form:"password"`
+	Role     Role     `json:"role" form:"role"`
+	Feeds    []string `json:"feeds" form:"feeds"`
+}
+
+// Store はユーザーをJSONファイルに保存する。
+// path が空の場合はファイルに保存しない。
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]User
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		users: make(map[string]User),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Wrap(err, "failed to open user file")
+	}
+	defer f.Close()
+
+	var users []User
+	if err := json.NewDecoder(f).Decode(&users); err != nil {
+		return nil, errors.Wrap(err, "failed to decode user json")
+	}
+	for _, u := range users {
+		s.users[u.Name] = u
+	}
+	return s, nil
+}
+
+// Len はユーザーの数を返す。
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// Get は名前でユーザーを探す。
+func (s *Store) Get(name string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	return u, ok
+}
+
+// List は名前順にユーザーを返す。
+func (s *Store) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users
+}
+
+// Authenticate は名前とパスワードが一致するユーザーを返す。
+func (s *Store) Authenticate(name, password string) (User, bool) {
+	u, ok := s.Get(name)
+	if !ok {
+		return User{}, false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return User{}, false
+	}
+	return u, true
+}
+
+// ByToken は個人用フィードのトークンが一致するユーザーを返す。
+func (s *Store) ByToken(token string) (User, bool) {
+	if token == "" {
+		return User{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if subtle.ConstantTimeCompare([]byte(u.Token), []byte(token)) == 1 {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// Put はユーザーを作成するか、既にいれば更新する。
+func (s *Store) Put(name string, params Params) (User, error) {
+	if name == "" || strings.ContainsAny(name, ":/") {
+		return User{}, errors.Newf("invalid user name: %q", name)
+	}
+	if err := params.Role.validate(); err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, exists := s.users[name]
+	u := prev
+	u.Name = name
+	u.Role = params.Role
+	u.Feeds = make([]string, 0, len(params.Feeds))
+	for _, f := range params.Feeds {
+		u.Feeds = append(u.Feeds, normalizeFeedPath(f))
+	}
+	if params.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, errors.Wrap(err, "failed to hash password")
+		}
+		u.PasswordHash = string(hash)
+	} else if !exists {
+		return User{}, ErrEmptyPassword
+	}
+	if u.Token == "" {
+		token, err := newToken()
+		if err != nil {
+			return User{}, err
+		}
+		u.Token = token
+	}
+	if exists && prev.IsAdmin() && !u.IsAdmin() && s.countAdmins() == 1 {
+		return User{}, ErrLastAdmin
+	}
+
+	s.users[name] = u
+	if err := s.save(); err != nil {
+		s.rollback(name, prev, exists)
+		return User{}, err
+	}
+	return u, nil
+}
+
+// Delete はユーザーを削除する。最後の管理者は削除できない。
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.users[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if prev.IsAdmin() && s.countAdmins() == 1 {
+		return ErrLastAdmin
+	}
+	delete(s.users, name)
+	if err := s.save(); err != nil {
+		s.rollback(name, prev, true)
+		return err
+	}
+	return nil
+}
+
+// RotateToken は個人用フィードのトークンを作り直す。古いトークンは使えなくなる。
+func (s *Store) RotateToken(name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.users[name]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	token, err := newToken()
+	if err != nil {
+		return User{}, err
+	}
+	u := prev
+	u.Token = token
+	s.users[name] = u
+	if err := s.save(); err != nil {
+		s.rollback(name, prev, true)
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (s *Store) countAdmins() int {
+	var n int
+	for _, u := range s.users {
+		if u.IsAdmin() {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Store) rollback(name string, prev User, existed bool) {
+	// NOTE: 保存できなかった変更は再起動すると消えるので戻しておく
+	if existed {
+		s.users[name] = prev
+	} else {
+		delete(s.users, name)
+	}
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
+	// NOTE: 書き込み途中で落ちてもユーザーが壊れないように一時ファイルからリネームする
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".users-*.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp user file")
+	}
+	defer os.Remove(f.Name())
+
+	// NOTE: パスワードのハッシュが入るので他のユーザーからは読めないようにする
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to chmod temp user file")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(users); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to encode user json")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temp user file")
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return errors.Wrap(err, "failed to rename user file")
+	}
+	return nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NOTE: `/ann` のような設定を `ann` と同値にしてあげる
+func normalizeFeedPath(feedPath string) string {
+	return strings.ToLower(strings.Trim(feedPath, "/"))
+}
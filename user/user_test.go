@@ -0,0 +1,83 @@
+package user
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "users.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	if _, err := s.Put("admin", Params{Password: "secret", Role: RoleAdmin}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	listener, err := s.Put("kid", Params{Password: "secret", Role: RoleListener, Feeds: []string{"/ANN"}})
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := s.Put("nopass", Params{Role: RoleListener}); !errors.Is(err, ErrEmptyPassword) {
+		t.Errorf("Put() without password error = %v, want %v", err, ErrEmptyPassword)
+	}
+	if _, err := s.Put("bad", Params{Password: "secret", Role: "owner"}); err == nil {
+		t.Errorf("Put() should reject an unknown role")
+	}
+
+	if _, ok := s.Authenticate("kid", "wrong"); ok {
+		t.Errorf("Authenticate() should reject a wrong password")
+	}
+	u, ok := s.Authenticate("kid", "secret")
+	if !ok {
+		t.Fatalf("Authenticate() should accept the password")
+	}
+	if !u.CanAccessFeed("ann") || u.CanAccessFeed("junk") || u.CanAccessFeed(AllFeeds) {
+		t.Errorf("CanAccessFeed() listener feeds = %v", u.Feeds)
+	}
+	if got, ok := s.ByToken(listener.Token); !ok || got.Name != "kid" {
+		t.Errorf("ByToken() got = %v, %v", got, ok)
+	}
+
+	// NOTE: パスワードを空で更新するとパスワードは変わらない
+	if _, err := s.Put("kid", Params{Role: RoleListener, Feeds: []string{"ann", "junk"}}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, ok := s.Authenticate("kid", "secret"); !ok {
+		t.Errorf("Put() without password should keep the password")
+	}
+
+	rotated, err := s.RotateToken("kid")
+	if err != nil {
+		t.Fatalf("RotateToken() error = %v", err)
+	}
+	if _, ok := s.ByToken(listener.Token); ok || rotated.Token == listener.Token {
+		t.Errorf("RotateToken() should invalidate the previous token")
+	}
+
+	if err := s.Delete("admin"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Delete() last admin error = %v, want %v", err, ErrLastAdmin)
+	}
+	if _, err := s.Put("admin", Params{Role: RoleListener}); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Put() demoting last admin error = %v, want %v", err, ErrLastAdmin)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	got, ok := reloaded.Authenticate("kid", "secret")
+	if !ok || got.Token != rotated.Token || len(got.Feeds) != 2 {
+		t.Errorf("reloaded user got = %+v", got)
+	}
+	if err := reloaded.Delete("kid"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if reloaded.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", reloaded.Len())
+	}
+}